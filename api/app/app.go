@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"weatherapi/configs"
+	"weatherapi/handlers"
+	"weatherapi/repository"
+	"weatherapi/server"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// App wires the routes of the API to its dependencies, every App is independent of the others except for the
+// WebSocket clients and metrics, which are process-global
+type App struct {
+	Fiber   *fiber.App
+	Service *services.Service
+	conf    *configs.Config
+	db      *gorm.DB
+}
+
+// NewWeatherRepository keeps the weather records in memory for memory:// connection strings, and in the database otherwise
+func NewWeatherRepository(conf *configs.Config, db *gorm.DB, clock utils.Clock) repository.WeatherRepository {
+	if server.IsMemory(conf.DbConnectionString) {
+		return repository.NewMemory(clock)
+	}
+	return repository.NewGorm(db)
+}
+
+// New builds the API on the given dependencies. The App takes ownership of the database, which Shutdown closes.
+func New(conf *configs.Config, db *gorm.DB, columns *configs.ColumnsConfig, clock utils.Clock, broadcaster handlers.Broadcaster) *App {
+	// the timestamps gorm sets are taken from the clock as well, in UTC like those of OpenDb
+	db.Config.NowFunc = func() time.Time {
+		return clock.Now().UTC()
+	}
+	service := services.New(db, NewWeatherRepository(conf, db, clock), conf, columns, clock)
+	h := handlers.New(service, conf, columns, clock, broadcaster)
+	services.InstrumentDb(db)
+
+	fiberApp := fiber.New()
+
+	registerRequestLogging(fiberApp)
+	server.RegisterWebSocket(fiberApp)
+	registerMetrics(fiberApp)
+
+	// every route is bounded by its configured timeout
+	get := func(path string, handler fiber.Handler) {
+		fiberApp.Get(path, withTimeout(conf.RouteTimeout(path)), handler)
+	}
+	// writes require an API token, and can be retried safely with an Idempotency-Key header once authorized
+	post := func(path string, handler fiber.Handler) {
+		fiberApp.Post(path, withTimeout(conf.RouteTimeout(path)), h.Authorize, idempotent(service, clock, conf.RouteTimeout(path)), handler)
+	}
+	put := func(path string, handler fiber.Handler) {
+		fiberApp.Put(path, withTimeout(conf.RouteTimeout(path)), h.Authorize, idempotent(service, clock, conf.RouteTimeout(path)), handler)
+	}
+	del := func(path string, handler fiber.Handler) {
+		fiberApp.Delete(path, withTimeout(conf.RouteTimeout(path)), h.Authorize, idempotent(service, clock, conf.RouteTimeout(path)), handler)
+	}
+
+	get("/ping", func(c *fiber.Ctx) error {
+		return c.SendString("Pong")
+	})
+	get("/healthz", h.Healthz)
+	get("/readyz", h.Readyz)
+	get("/status", h.GetStatus)
+	get("/cache/stats", h.GetCacheStatistics)
+	// static routes need to be registered before the date parameter routes
+	get("/weather/anomalies", h.GetAnomalies)
+	get("/weather/normals", h.GetNormals)
+	get("/weather/forecast", h.GetForecast)
+	get("/weather/extremes", h.GetExtremes)
+	get("/weather/degree-days", h.GetDegreeDays)
+	get("/weather/compare", h.ComparePeriods)
+	get("/weather/histogram", h.GetHistogram)
+	get("/weather/correlation", h.GetCorrelation)
+	post("/weather/normals/recompute", h.RecomputeNormals)
+	get("/weather/:from", h.GetWeatherRecordsForSingleDay)
+	get("/weather/:date/history", h.GetWeatherHistory)
+	get("/weather/:from/:to", h.GetWeatherRecordsForRange)
+	post("/weather", h.CreateWeatherRecord)
+	post("/weather/batch", h.CreateWeatherRecords)
+	put("/weather/:date", h.PutWeatherRecord)
+	del("/weather/:date", h.DeleteWeatherRecord)
+	post("/weather/:date/review", h.ReviewWeatherRecord)
+
+	return &App{Fiber: fiberApp, Service: service, conf: conf, db: db}
+}
+
+// Listen serves the API on the configured host until Shutdown is called
+func (a *App) Listen() error {
+	return a.Fiber.Listen(a.conf.AppHost)
+}
+
+// Shutdown stops accepting connections, and gives in-flight requests and WebSocket clients the grace period to finish.
+// There is no background work besides the messages queued for WebSocket clients, which are delivered before their close frame.
+func (a *App) Shutdown(gracePeriod time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	var errs []error
+	if err := a.Fiber.ShutdownWithContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("error draining requests: %w", err))
+	}
+	// requests are drained first, as they may still broadcast to WebSocket clients
+	if err := server.CloseWebSockets(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("error closing WebSocket clients: %w", err))
+	}
+	if err := server.CloseDb(a.db); err != nil {
+		errs = append(errs, fmt.Errorf("error closing database: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (a *App) Config() *configs.Config {
+	return a.conf
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package app
+
+import "net"
+
+// watchDisconnect does not watch connections on platforms without MSG_PEEK support in the syscall package,
+// the queries of clients that disconnect run until they complete or time out
+func watchDisconnect(conn net.Conn, disconnected func()) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package app
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// watchDisconnect calls disconnected when the client closes the connection, until the returned stop function is called.
+// fasthttp does not read from the connection while a request is handled, so the socket is peeked at without consuming
+// anything: data sent by the client (e.g. a pipelined request) is left for fasthttp, and ends the watch.
+// Connections that do not expose their socket, like TLS connections, are not watched.
+func watchDisconnect(conn net.Conn, disconnected func()) (stop func()) {
+	sysConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return func() {}
+	}
+	rawConn, err := sysConn.SyscallConn()
+	if err != nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		closed := false
+		buf := make([]byte, 1)
+		// the callback is called again whenever the socket becomes readable, until it returns true or the read deadline passes
+		_ = rawConn.Read(func(fd uintptr) bool {
+			n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				return false
+			}
+			// a read of 0 bytes is the end of the stream, errors like ECONNRESET mean the connection is gone too
+			closed = n == 0 || err != nil
+			return true
+		})
+		if closed {
+			disconnected()
+		}
+	}()
+
+	return func() {
+		// a deadline in the past wakes up the pending read, fasthttp sets its own deadlines before reading the next request
+		_ = conn.SetReadDeadline(time.Now())
+		<-done
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"weatherapi/logging"
+	"weatherapi/metrics"
+	"weatherapi/repository"
+	"weatherapi/server"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// responseStatus returns the status of the response, errors returned by handlers are only turned into a response after all middlewares complete
+func responseStatus(c *fiber.Ctx, err error) int {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	} else if err != nil {
+		return fiber.StatusInternalServerError
+	}
+	return c.Response().StatusCode()
+}
+
+// registerRequestLogging reads or creates the X-Request-ID of every request, and logs each request once it completes
+func registerRequestLogging(app *fiber.App) {
+	app.Use(requestid.New(requestid.Config{ContextKey: logging.RequestIDKey}))
+
+	app.Use(func(c *fiber.Ctx) error {
+		// the header value is only valid during the request, WebSocket connections outlive it
+		requestId := strings.Clone(c.Locals(logging.RequestIDKey).(string))
+		c.Locals(logging.RequestIDKey, requestId)
+		c.SetUserContext(logging.WithRequestID(c.UserContext(), requestId))
+
+		start := time.Now()
+		err := c.Next()
+		slog.InfoContext(c.UserContext(), "Request",
+			"method", c.Method(),
+			"url", c.OriginalURL(),
+			"status", responseStatus(c, err),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+		return err
+	})
+}
+
+// statusClientClosedRequest is logged for requests whose client disconnected before the response, the status is never sent
+const statusClientClosedRequest = 499
+
+// errClientDisconnected is the cause of request contexts that were cancelled because the client disconnected
+var errClientDisconnected = errors.New("client disconnected")
+
+// withTimeout cancels the request context after the timeout, and answers requests that exceeded it with 504.
+// The context is cancelled as well when the client disconnects (see watchDisconnect), so its queries stop early.
+func withTimeout(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		ctx, cancelCause := context.WithCancelCause(ctx)
+		defer cancelCause(nil)
+		c.SetUserContext(ctx)
+
+		stop := watchDisconnect(c.Context().Conn(), func() { cancelCause(errClientDisconnected) })
+		err := c.Next()
+		stop()
+
+		if errors.Is(context.Cause(ctx), errClientDisconnected) {
+			slog.InfoContext(ctx, "Client disconnected", "error", err)
+			return c.Status(statusClientClosedRequest).SendString("Client closed request")
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			slog.WarnContext(ctx, "Request timed out", "timeout", timeout.String(), "error", err)
+			return c.Status(fiber.StatusGatewayTimeout).SendString("Request timed out")
+		}
+		return err
+	}
+}
+
+// maxIdempotencyKeyLength bounds the keys clients may choose, UUIDs are recommended
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRetryAfterSeconds is how long retries of a request that is still handled are asked to wait
+const idempotencyRetryAfterSeconds = 1
+
+// idempotencyLeaseGrace is how long the claim of a request outlives its timeout, which leaves time to store the response
+const idempotencyLeaseGrace = 5 * time.Second
+
+// idempotent stores the first response to a write request with an Idempotency-Key header, and replays it to retries of the request.
+// Keys are scoped to the API token, reusing a key of the token for a different method, path or body is rejected with 422.
+// Server errors release the key, so the request can be retried with it. Requests are authorized before (see
+// Handlers.Authorize), so only responses to authorized requests are stored, and only under the token that made the request.
+// A key is claimed for the timeout of the route, so the key of a request that never completed can be claimed again.
+func idempotent(service *services.Service, clock utils.Clock, timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("Idempotency-Key")
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			slog.WarnContext(c.UserContext(), "Idempotency key too long", "length", len(key))
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Idempotency-Key")
+		}
+		// the header value is only valid until the handler returns
+		key = strings.Clone(key)
+
+		// the query is part of the request, e.g. on_conflict changes what a write does
+		fingerprint := sha256.New()
+		for _, part := range [][]byte{[]byte(c.Method()), []byte(c.OriginalURL()), c.Body()} {
+			fingerprint.Write(part)
+			fingerprint.Write([]byte{0})
+		}
+
+		actor := repository.AuthorOf(c.UserContext()).Actor
+		claimedAt := clock.Now()
+		stored, err := service.BeginIdempotentRequest(c.UserContext(), actor, key, hex.EncodeToString(fingerprint.Sum(nil)), claimedAt, timeout+idempotencyLeaseGrace)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			slog.WarnContext(c.UserContext(), "Idempotency key reused for a different request", "idempotency_key", key)
+			return c.Status(fiber.StatusUnprocessableEntity).SendString("Idempotency-Key was used for a different request")
+		case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+			// Retry-After tells the retry apart from a conflict with a stored record, which is final
+			slog.WarnContext(c.UserContext(), "Request with idempotency key in progress", "idempotency_key", key)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(idempotencyRetryAfterSeconds))
+			return c.Status(fiber.StatusConflict).SendString("A request with this Idempotency-Key is in progress")
+		case err != nil:
+			slog.ErrorContext(c.UserContext(), "Error claiming idempotency key", "error", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+		case stored != nil:
+			slog.InfoContext(c.UserContext(), "Replaying stored response", "idempotency_key", key, "status", stored.Status)
+			c.Set("Idempotent-Replayed", "true")
+			c.Set(fiber.HeaderContentType, stored.ContentType)
+			return c.Status(stored.Status).Send(stored.Body)
+		}
+
+		err = c.Next()
+		// the outcome is stored even when the request timed out
+		ctx := context.WithoutCancel(c.UserContext())
+		status := responseStatus(c, err)
+		if err != nil || status >= fiber.StatusInternalServerError {
+			if releaseErr := service.ReleaseIdempotencyKey(ctx, actor, key, claimedAt); releaseErr != nil {
+				slog.ErrorContext(ctx, "Error releasing idempotency key", "error", releaseErr)
+			}
+			return err
+		}
+
+		response := services.StoredResponse{
+			Status:      status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        c.Response().Body(),
+		}
+		if err := service.CompleteIdempotentRequest(ctx, actor, key, claimedAt, response, clock.Now()); err != nil {
+			slog.ErrorContext(ctx, "Error storing response of idempotency key", "error", err)
+		}
+		return nil
+	}
+}
+
+// registerMetrics records the count and latency of every request by route and status
+func registerMetrics(app *fiber.App) {
+	requests := metrics.Counter("http_requests_total", "Number of HTTP requests", "method", "route", "status")
+	durations := metrics.Histogram("http_request_duration_seconds", "Duration of HTTP requests in seconds", metrics.DefaultBuckets, "method", "route", "status")
+	metrics.GaugeFunc("websocket_connections", "Number of connected WebSocket clients", func() float64 {
+		return float64(server.ConnectedClients())
+	})
+
+	app.Use(func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		// the route pattern keeps the number of label values bounded, unlike the requested path.
+		// fiber reuses the buffer behind the method, so it needs to be copied before it is stored
+		labels := []string{strings.Clone(c.Method()), c.Route().Path, strconv.Itoa(responseStatus(c, err))}
+		requests.Inc(labels...)
+		durations.Observe(time.Since(start).Seconds(), labels...)
+		return err
+	})
+
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		metrics.Default.Write(c)
+		return nil
+	})
+}
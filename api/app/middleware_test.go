@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSlowRoute serves a route that blocks until its context is cancelled, and reports the cause of the cancellation.
+// A fast route answers right away
+func serveSlowRoute(t *testing.T, timeout time.Duration) (string, <-chan error) {
+	causes := make(chan error, 1)
+	fiberApp := fiber.New(fiber.Config{DisableStartupMessage: true})
+	fiberApp.Get("/slow", withTimeout(timeout), func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		causes <- context.Cause(c.UserContext())
+		return c.UserContext().Err()
+	})
+	fiberApp.Get("/fast", withTimeout(timeout), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = fiberApp.Listener(listener) }()
+	t.Cleanup(func() { _ = fiberApp.Shutdown() })
+	return listener.Addr().String(), causes
+}
+
+func TestWithTimeout(t *testing.T) {
+	t.Run("cancels the queries of clients that disconnect", func(t *testing.T) {
+		addr, causes := serveSlowRoute(t, time.Minute)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if err := conn.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case cause := <-causes:
+			assert.True(t, errors.Is(cause, errClientDisconnected), "cause: %v", cause)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the request context was not cancelled after the client disconnected")
+		}
+	})
+
+	t.Run("answers requests that exceed the timeout with 504", func(t *testing.T) {
+		addr, causes := serveSlowRoute(t, 50*time.Millisecond)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, err = fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Contains(t, string(response), "504")
+		assert.True(t, errors.Is(<-causes, context.DeadlineExceeded))
+	})
+	t.Run("keeps connections usable for the next request", func(t *testing.T) {
+		addr, _ := serveSlowRoute(t, time.Minute)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, err = fmt.Fprint(conn, "GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\nGET /fast HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 2, strings.Count(string(response), "200 OK"))
+	})
+}
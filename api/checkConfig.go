@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"weatherapi/server"
+)
+
+// runCheckConfig validates the configuration and columns.yaml, and with -connect that the database is reachable and migrated
+func runCheckConfig(args []string) int {
+	flags, env := newFlagSet("check-config", "check-config [flags]")
+	connect := flags.Bool("connect", false, "also connect to the database and check its migrations")
+	args, code, ok := parseFlags(flags, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(flags, "check-config takes no arguments")
+	}
+
+	conf, columns, code := loadConfig(env)
+	if code != exitOK {
+		return code
+	}
+	if *connect {
+		db, code := openDb(conf, true)
+		if code != exitOK {
+			return code
+		}
+		server.CloseDb(db)
+	}
+
+	// the connection string is reduced to its scheme, it may contain credentials
+	scheme, _, _ := strings.Cut(conf.DbConnectionString, "://")
+	var measurements []string
+	for _, measurement := range columns.Measurements {
+		measurements = append(measurements, measurement.Name)
+	}
+	fmt.Printf("host: %s\n", conf.AppHost)
+	fmt.Printf("database: %s\n", scheme)
+	fmt.Printf("timezone: %s\n", conf.Location)
+	fmt.Printf("log: %s, %s\n", conf.LogFormat, conf.LogLevel)
+	fmt.Printf("measurements: %s\n", strings.Join(measurements, ", "))
+	fmt.Println("configuration is valid")
+	return exitOK
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"weatherapi/app"
+	"weatherapi/configs"
+	"weatherapi/logging"
+	"weatherapi/migrations"
+	"weatherapi/repository"
+	"weatherapi/server"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"gorm.io/gorm"
+)
+
+// exit codes shared by all commands
+const (
+	exitOK = 0
+	// the command failed, e.g. the database is unreachable or records were rejected
+	exitFailure = 1
+	// the command line is invalid
+	exitUsage = 2
+	// the configuration is invalid, or the database is not migrated
+	exitConfig = 3
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"serve", "serve the API, the default when no command is given", runServe},
+	{"migrate", "apply, revert or list the schema migrations", runMigrate},
+	{"ingest", "create weather records from a tab separated file", runIngest},
+	{"export", "write the weather records of a range as csv, tsv or json", runExport},
+	{"seed", "create generated weather records", runSeed},
+	{"tokens", "create, list or revoke API tokens", runTokens},
+	{"check-config", "validate the configuration and columns.yaml", runCheckConfig},
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: weatherapi <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun `weatherapi <command> -h` for the flags of a command.")
+}
+
+// configFlags are the flags of every command that override environment variables, and configs/.env.<APP_ENV>
+var configFlags = []struct {
+	name  string
+	env   string
+	usage string
+}{
+	{"env", "APP_ENV", "environment, selects configs/.env.<env>"},
+	{"db", "DB_CONNECTION_STRING", "database connection string"},
+	{"host", "APP_HOST", "host and port the API listens on"},
+	{"api-token", "API_TOKEN", "API token"},
+	{"log-level", "LOG_LEVEL", "minimum log level: debug, info, warn or error"},
+	{"log-format", "LOG_FORMAT", "log format: text or json"},
+}
+
+// overrides are the environment variables set by flags
+type overrides map[string]string
+
+// newFlagSet creates the flags of a command, including the config flags and -set NAME=VALUE for any other variable
+func newFlagSet(name string, usage string) (*flag.FlagSet, overrides) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: weatherapi %s\n\nflags:\n", usage)
+		flags.PrintDefaults()
+	}
+
+	env := overrides{}
+	for _, configFlag := range configFlags {
+		flags.Func(configFlag.name, fmt.Sprintf("%s (overrides %s)", configFlag.usage, configFlag.env), func(value string) error {
+			env[configFlag.env] = value
+			return nil
+		})
+	}
+	flags.Func("set", "set any environment variable, as NAME=VALUE (repeatable)", func(value string) error {
+		name, value, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return errors.New("expected NAME=VALUE")
+		}
+		env[name] = value
+		return nil
+	})
+	return flags, env
+}
+
+// parseFlags parses the arguments of a command, where flags may come before and after the positional arguments.
+// It returns the positional arguments, or the exit code when the command should not run.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, int, bool) {
+	var positional []string
+	for {
+		err := flags.Parse(args)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK, false
+		}
+		if err != nil {
+			return nil, exitUsage, false
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, exitOK, true
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// usageError reports an invalid command line
+func usageError(flags *flag.FlagSet, format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	flags.Usage()
+	return exitUsage
+}
+
+// fail reports the error of a command and returns its exit code
+func fail(code int, format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "weatherapi: "+format+"\n", args...)
+	return code
+}
+
+// loadConfig applies the overrides, and loads the configuration and columns.yaml shared by all commands
+func loadConfig(env overrides) (*configs.Config, *configs.ColumnsConfig, int) {
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+
+	conf, err := configs.Load()
+	if err != nil {
+		return nil, nil, fail(exitConfig, "invalid configuration: %v", err)
+	}
+	if err := logging.Configure(conf.LogFormat, conf.LogLevel); err != nil {
+		return nil, nil, fail(exitConfig, "failed to configure logging: %v", err)
+	}
+	columns, err := configs.LoadColumns("configs/columns.yaml")
+	if err != nil {
+		return nil, nil, fail(exitConfig, "invalid columns.yaml: %v", err)
+	}
+	return conf, columns, exitOK
+}
+
+// openDb connects to the configured database, which has to be fully migrated unless the command migrates it
+func openDb(conf *configs.Config, requireMigrated bool) (*gorm.DB, int) {
+	db, err := server.OpenDb(conf.DbConnectionString)
+	if err != nil {
+		return nil, fail(exitFailure, "%v", err)
+	}
+	if !requireMigrated {
+		return db, exitOK
+	}
+	// an in-memory database starts out empty, it is migrated right away
+	if server.IsMemory(conf.DbConnectionString) {
+		if _, err := migrations.Up(context.Background(), db); err != nil {
+			server.CloseDb(db)
+			return nil, fail(exitFailure, "%v", err)
+		}
+		return db, exitOK
+	}
+	if err := migrations.Check(context.Background(), db); err != nil {
+		server.CloseDb(db)
+		if errors.Is(err, migrations.ErrNotMigrated) || errors.Is(err, migrations.ErrUnknown) {
+			return nil, fail(exitConfig, "run `weatherapi migrate up` first: %v", err)
+		}
+		return nil, fail(exitFailure, "%v", err)
+	}
+	return db, exitOK
+}
+
+// openService loads the configuration and builds the service on the migrated database, the returned function closes it
+func openService(env overrides) (*services.Service, *configs.Config, func(), int) {
+	conf, columns, code := loadConfig(env)
+	if code != exitOK {
+		return nil, nil, nil, code
+	}
+	db, code := openDb(conf, true)
+	if code != exitOK {
+		return nil, nil, nil, code
+	}
+	clock := utils.SystemClock{}
+	service := services.New(db, app.NewWeatherRepository(conf, db, clock), conf, columns, clock)
+	return service, conf, func() { server.CloseDb(db) }, exitOK
+}
+
+// withCommandAuthor returns a context whose changes are recorded as made by the operating system user running the command
+func withCommandAuthor(ctx context.Context, source string) context.Context {
+	actor := "cli"
+	if current, err := user.Current(); err == nil {
+		actor = "cli:" + current.Username
+	}
+	return repository.WithAuthor(ctx, repository.Author{Actor: actor, Source: source})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCli(t *testing.T) {
+	t.Run("parses flags before and after arguments", func(t *testing.T) {
+		flags, env := newFlagSet("migrate", "migrate [flags] up|down [steps]|status")
+		args, code, ok := parseFlags(flags, []string{"-env", "test", "down", "2", "-db", "sqlite://:memory:", "-set", "LOG_LEVEL=warn"})
+
+		assert.True(t, ok)
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, []string{"down", "2"}, args)
+		assert.Equal(t, overrides{"APP_ENV": "test", "DB_CONNECTION_STRING": "sqlite://:memory:", "LOG_LEVEL": "warn"}, env)
+	})
+
+	t.Run("reports invalid flags as usage errors", func(t *testing.T) {
+		flags, _ := newFlagSet("migrate", "migrate [flags] up|down [steps]|status")
+		flags.SetOutput(&bytes.Buffer{})
+
+		_, code, ok := parseFlags(flags, []string{"up", "-set", "LOG_LEVEL"})
+		assert.False(t, ok)
+		assert.Equal(t, exitUsage, code)
+
+		_, code, ok = parseFlags(flags, []string{"-h"})
+		assert.False(t, ok)
+		assert.Equal(t, exitOK, code)
+	})
+
+	t.Run("check-config reports invalid settings", func(t *testing.T) {
+		// the overrides are written to the environment, which is restored after the test
+		t.Setenv("APP_ENV", "test")
+		t.Setenv("FORECAST_MODEL", "")
+		t.Setenv("NORMALS_WINDOW_DAYS", "")
+
+		assert.Equal(t, exitConfig, runCheckConfig([]string{"-set", "FORECAST_MODEL=arima"}))
+		assert.Equal(t, exitConfig, runCheckConfig([]string{"-set", "FORECAST_MODEL=holt", "-set", "NORMALS_WINDOW_DAYS=-7"}))
+		assert.Equal(t, exitOK, runCheckConfig([]string{"-set", "FORECAST_MODEL=holt_winters", "-set", "NORMALS_WINDOW_DAYS=15"}))
+	})
+
+	t.Run("check-config reports settings out of range", func(t *testing.T) {
+		t.Setenv("APP_ENV", "test")
+		reset := func() {
+			for _, setting := range []string{
+				"REQUEST_TIMEOUT", "ROUTE_TIMEOUTS", "IDEMPOTENCY_TTL", "SHUTDOWN_GRACE_PERIOD", "FILL_MAX_GAP_DAYS", "MAX_RANGE_DAYS",
+				"ANOMALY_THRESHOLD", "ANOMALY_WINDOW_DAYS", "ANOMALY_SEASONAL_WINDOW_DAYS", "ANOMALY_MIN_SAMPLES",
+				"FORECAST_SEASON_DAYS", "NORMALS_START_YEAR", "NORMALS_END_YEAR",
+			} {
+				t.Setenv(setting, "")
+			}
+		}
+
+		for _, set := range [][]string{
+			{"REQUEST_TIMEOUT=0s"},
+			{"REQUEST_TIMEOUT=-5s"},
+			{"ROUTE_TIMEOUTS=/weather/forecast=0s"},
+			{"IDEMPOTENCY_TTL=0s"},
+			{"SHUTDOWN_GRACE_PERIOD=-1s"},
+			{"FILL_MAX_GAP_DAYS=-1"},
+			{"MAX_RANGE_DAYS=0"},
+			{"ANOMALY_THRESHOLD=0"},
+			{"ANOMALY_THRESHOLD=NaN"},
+			{"ANOMALY_WINDOW_DAYS=0"},
+			{"ANOMALY_SEASONAL_WINDOW_DAYS=183"},
+			{"ANOMALY_MIN_SAMPLES=0"},
+			{"FORECAST_SEASON_DAYS=0"},
+			{"NORMALS_START_YEAR=2020", "NORMALS_END_YEAR=1991"},
+		} {
+			reset()
+			var args []string
+			for _, setting := range set {
+				args = append(args, "-set", setting)
+			}
+			assert.Equal(t, exitConfig, runCheckConfig(args), set)
+		}
+		reset()
+		assert.Equal(t, exitOK, runCheckConfig([]string{"-set", "NORMALS_START_YEAR=1991", "-set", "NORMALS_END_YEAR=2020", "-set", "FILL_MAX_GAP_DAYS=0"}))
+	})
+
+	t.Run("exports records in the format ingest reads", func(t *testing.T) {
+		records, err := parseRecords(strings.NewReader("2023-01-01\t75.5\t22.25\n\n2023-01-02\t31\t-4.5\n"))
+		assert.Nil(t, err)
+		assert.Equal(t, []services.WeatherRecordBody{
+			{RecordedAt: "2023-01-01", Humidity: 75.5, Temperature: 22.25},
+			{RecordedAt: "2023-01-02", Humidity: 31, Temperature: -4.5},
+		}, records)
+
+		var responses []services.WeatherRecordResponse
+		for _, record := range records {
+			responses = append(responses, services.WeatherRecordResponse{
+				Date: record.RecordedAt,
+				Raw:  services.RawWeatherRecordUnits{Humidity: record.Humidity, Temperature: record.Temperature},
+			})
+		}
+		var tsv bytes.Buffer
+		assert.Nil(t, writeRecords(&tsv, "tsv", responses))
+		assert.Equal(t, "2023-01-01\t75.5\t22.25\n2023-01-02\t31\t-4.5\n", tsv.String())
+
+		var csv bytes.Buffer
+		assert.Nil(t, writeRecords(&csv, "csv", responses))
+		assert.Equal(t, "date,humidity,temperature,anomaly_fields\n2023-01-01,75.5,22.25,\n2023-01-02,31,-4.5,\n", csv.String())
+
+		var json bytes.Buffer
+		assert.Nil(t, writeRecords(&json, "json", nil))
+		assert.Equal(t, "[]\n", json.String())
+	})
+
+	t.Run("rejects malformed lines", func(t *testing.T) {
+		_, err := parseRecords(strings.NewReader("2023-01-01\t75.5\t22.25\n2023-01-02 31 -4.5\n"))
+		assert.ErrorContains(t, err, "line 2")
+
+		_, err = parseRecords(strings.NewReader("2023-01-01\thumid\t22.25\n"))
+		assert.ErrorContains(t, err, "invalid humidity")
+	})
+
+	t.Run("generates the same records for the same seed", func(t *testing.T) {
+		from := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+		records := generateRecords(from, 365, 7)
+
+		assert.Equal(t, 365, len(records))
+		assert.Equal(t, "2023-01-01", records[0].RecordedAt)
+		assert.Equal(t, "2023-12-31", records[364].RecordedAt)
+		assert.Equal(t, records, generateRecords(from, 365, 7))
+		assert.NotEqual(t, records, generateRecords(from, 365, 8))
+		for _, record := range records {
+			assert.Nil(t, services.ValidateWeatherRecord(&record, utils.SystemClock{}, time.UTC))
+		}
+	})
+}
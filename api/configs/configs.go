@@ -1,10 +1,18 @@
 package configs
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
-	"sync"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	// embed the timezone database so the reference timezone resolves on hosts without one
+	_ "time/tzdata"
 
 	"github.com/goccy/go-yaml"
 	"github.com/joho/godotenv"
@@ -14,6 +22,43 @@ type Config struct {
 	ApiToken           string
 	AppHost            string
 	DbConnectionString string
+	// log output: "text" or "json", and the minimum level (debug, info, warn or error)
+	LogFormat string
+	LogLevel  string
+	// reference timezone that defines "today" and the calendar day of dates on every route
+	Location *time.Location
+	// time in-flight requests and WebSocket clients are given to finish on SIGTERM or SIGINT
+	ShutdownGracePeriod time.Duration
+	// time a request may take before it is answered with 504, and overrides by route path (e.g. /weather/forecast)
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+	// number of query results kept in the in-process cache, 0 disables caching, and the time they are kept (0 until the next write)
+	QueryCacheSize int
+	QueryCacheTTL  time.Duration
+	// time the first response to a write request with an Idempotency-Key header is stored and replayed to retries
+	IdempotencyTTL time.Duration
+	// maximum number of consecutive missing days that may be synthesized by gap filling
+	FillMaxGapDays int
+	// longest range, in days, that may be requested from the routes that walk every day of it
+	MaxRangeDays int
+	// anomaly detection: scoring method (zscore or iqr) and the score beyond which a value is flagged
+	AnomalyMethod    string
+	AnomalyThreshold float64
+	// anomaly detection: days of recent history, and days around the same date in past years, to compare against
+	AnomalyWindowDays         int
+	AnomalySeasonalWindowDays int
+	// anomaly detection: minimum number of historical values required before a baseline is used
+	AnomalyMinSamples int
+	// climatology normals: reference period in years (0 leaves the bound open) and the days around each day of the year that are pooled
+	NormalsStartYear  int
+	NormalsEndYear    int
+	NormalsWindowDays int
+	// forecasting: model used when none is requested, and the length of the seasonal cycle in days
+	ForecastModel      string
+	ForecastSeasonDays int
+	// degree days: default base temperature and the unit ("C" or "F") it is expressed in
+	DegreeDayBase float64
+	DegreeDayUnit string
 }
 
 type RawColumnsConfig struct {
@@ -23,84 +68,269 @@ type RawColumnsConfig struct {
 	} `yaml:"columns"`
 }
 
+type Measurement struct {
+	// Name matches the column name in columns.yaml and the field name on models.Weather
+	Name string
+	Unit string
+}
+
 type ColumnsConfig struct {
 	DateFormat        string
 	HumidityFormat    string
 	TemperatureFormat string
+	// all measured columns (every column except the date), sorted by name
+	Measurements []Measurement
 }
 
-var (
-	conf          *Config
-	onceConfigs   sync.Once
-	onceColumns   sync.Once
-	columnsConfig *ColumnsConfig
-)
+// FindMeasurement looks up a measurement by its case-insensitive name
+func (c *ColumnsConfig) FindMeasurement(name string) (Measurement, bool) {
+	for _, measurement := range c.Measurements {
+		if strings.EqualFold(measurement.Name, name) {
+			return measurement, true
+		}
+	}
+	return Measurement{}, false
+}
 
 var dateFormats = map[string]string{
 	"YYYY-MM-DD": "2006-01-02",
 }
 
-func GetColumns() *ColumnsConfig {
-	onceColumns.Do(func() {
-		columnsYaml, err := os.ReadFile("configs/columns.yaml")
-		if err != nil {
-			log.Fatalln(err)
-		}
+// LoadColumns reads the column definitions from the given columns.yaml
+func LoadColumns(path string) (*ColumnsConfig, error) {
+	columnsYaml, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-		var rawColumnsConfig = RawColumnsConfig{}
+	var rawColumnsConfig = RawColumnsConfig{}
 
-		if err := yaml.Unmarshal(columnsYaml, &rawColumnsConfig); err != nil {
-			log.Fatalln(err)
-		}
-		dateFormat := dateFormats[rawColumnsConfig.Columns["Date"].Unit]
-		if dateFormat == "" {
-			log.Fatalln("invalid date format specified in columns.yaml")
-		}
+	if err := yaml.Unmarshal(columnsYaml, &rawColumnsConfig); err != nil {
+		return nil, err
+	}
+	dateFormat := dateFormats[rawColumnsConfig.Columns["Date"].Unit]
+	if dateFormat == "" {
+		return nil, errors.New("invalid date format specified in columns.yaml")
+	}
 
-		columnsConfig = &ColumnsConfig{
-			DateFormat:        dateFormat,
-			HumidityFormat:    rawColumnsConfig.Columns["Humidity"].Unit,
-			TemperatureFormat: rawColumnsConfig.Columns["Temperature"].Unit,
+	var measurements []Measurement
+	for name, column := range rawColumnsConfig.Columns {
+		if name != "Date" {
+			measurements = append(measurements, Measurement{Name: name, Unit: column.Unit})
 		}
+	}
+	sort.Slice(measurements, func(i, j int) bool {
+		return measurements[i].Name < measurements[j].Name
 	})
-	return columnsConfig
+
+	return &ColumnsConfig{
+		DateFormat:        dateFormat,
+		HumidityFormat:    rawColumnsConfig.Columns["Humidity"].Unit,
+		TemperatureFormat: rawColumnsConfig.Columns["Temperature"].Unit,
+		Measurements:      measurements,
+	}, nil
 }
 
-func Get() *Config {
-	onceConfigs.Do(func() {
-		appEnv := os.Getenv("APP_ENV")
-		if appEnv == "" {
-			log.Fatalln("APP_ENV environment variable is not set")
-		}
+// envReader reads typed environment variables, collecting every invalid value instead of stopping at the first
+type envReader struct {
+	errs []error
+}
 
-		envFilename := fmt.Sprintf("./configs/.env.%s", appEnv)
-		err := godotenv.Load(envFilename)
-		if err != nil {
-			log.Printf("No %s file found. Using system environment variables\n", envFilename)
-		}
+func (r *envReader) fail(format string, args ...any) {
+	r.errs = append(r.errs, fmt.Errorf(format, args...))
+}
 
-		conf = &Config{
-			ApiToken:           os.Getenv("API_TOKEN"),
-			AppHost:            os.Getenv("APP_HOST"),
-			DbConnectionString: os.Getenv("DB_CONNECTION_STRING"),
-		}
+// getIntEnv reads an optional integer environment variable, falling back to the given default
+func (r *envReader) getIntEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		r.fail("%s environment variable must be an integer: %v", key, err)
+	}
+	return parsed
+}
+
+// getFloatEnv reads an optional float environment variable, falling back to the given default
+func (r *envReader) getFloatEnv(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.fail("%s environment variable must be a number: %v", key, err)
+	}
+	return parsed
+}
+
+// getStringEnv reads an optional environment variable, falling back to the given default
+func (r *envReader) getStringEnv(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// getIntRangeEnv reads an optional integer environment variable that has to lie between min and max, falling back to the given default
+func (r *envReader) getIntRangeEnv(key string, fallback int, min int, max int) int {
+	value := r.getIntEnv(key, fallback)
+	if value < min || value > max {
+		r.fail("%s environment variable must be between %d and %d: %d", key, min, max, value)
+	}
+	return value
+}
 
-		if conf.ApiToken == "" {
-			log.Fatalln("API_TOKEN environment variable is not set")
+// getChoiceEnv reads an optional environment variable that has to be one of the given choices, falling back to the given default
+func (r *envReader) getChoiceEnv(key string, fallback string, choices ...string) string {
+	value := r.getStringEnv(key, fallback)
+	if !slices.Contains(choices, value) {
+		r.fail("%s environment variable must be one of %s: %s", key, strings.Join(choices, ", "), value)
+	}
+	return value
+}
+
+// getDurationEnv reads an optional duration environment variable (e.g. 10s), falling back to the given default
+func (r *envReader) getDurationEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		r.fail("%s environment variable must be a duration: %v", key, err)
+	}
+	return parsed
+}
+
+// getPositiveDurationEnv reads an optional duration environment variable that has to be greater than zero, falling back to the given default
+func (r *envReader) getPositiveDurationEnv(key string, fallback time.Duration) time.Duration {
+	value := r.getDurationEnv(key, fallback)
+	if value <= 0 {
+		r.fail("%s environment variable must be a positive duration: %s", key, value)
+	}
+	return value
+}
+
+// getPositiveFloatEnv reads an optional float environment variable that has to be finite and greater than zero, falling back to the given default
+func (r *envReader) getPositiveFloatEnv(key string, fallback float64) float64 {
+	value := r.getFloatEnv(key, fallback)
+	if math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		r.fail("%s environment variable must be a positive number: %v", key, value)
+	}
+	return value
+}
+
+// getDurationMapEnv reads an optional comma separated list of key=duration pairs, e.g. /weather/forecast=30s
+func (r *envReader) getDurationMapEnv(key string) map[string]time.Duration {
+	durations := map[string]time.Duration{}
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
 		}
-		if conf.AppHost == "" {
-			log.Fatalln("APP_HOST environment variable is not set")
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			r.fail("%s environment variable must be a list of key=duration pairs", key)
+			continue
 		}
-		if conf.DbConnectionString == "" {
-			log.Fatalln("DB_CONNECTION_STRING environment variable is not set")
+		parsed, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			r.fail("%s environment variable must be a list of key=duration pairs: %v", key, err)
+			continue
 		}
-		log.Println("Configuration loaded successfully")
-	})
-	return conf
+		if parsed <= 0 {
+			r.fail("%s environment variable must be a list of positive durations: %s", key, strings.TrimSpace(pair))
+			continue
+		}
+		durations[strings.TrimSpace(name)] = parsed
+	}
+	return durations
 }
 
-func init() {
-	// Load the configuration once during startup
-	Get()
-	GetColumns()
+// RouteTimeout returns the timeout of the route with the given path
+func (c *Config) RouteTimeout(path string) time.Duration {
+	if timeout, ok := c.RouteTimeouts[path]; ok {
+		return timeout
+	}
+	return c.RequestTimeout
+}
+
+// getLocationEnv reads an optional IANA timezone name (e.g. Europe/Berlin), falling back to the given default
+func (r *envReader) getLocationEnv(key string, fallback string) *time.Location {
+	location, err := time.LoadLocation(r.getStringEnv(key, fallback))
+	if err != nil {
+		r.fail("%s environment variable must be a valid timezone: %v", key, err)
+		return time.UTC
+	}
+	return location
+}
+
+// Load reads the configuration from the environment, after loading ./configs/.env.<APP_ENV> when it exists.
+// Variables already set in the environment take precedence over the file.
+func Load() (*Config, error) {
+	appEnv := os.Getenv("APP_ENV")
+	if appEnv == "" {
+		return nil, errors.New("APP_ENV environment variable is not set")
+	}
+
+	envFilename := fmt.Sprintf("./configs/.env.%s", appEnv)
+	err := godotenv.Load(envFilename)
+	if err != nil {
+		log.Printf("No %s file found. Using system environment variables\n", envFilename)
+	}
+
+	r := &envReader{}
+	conf := &Config{
+		ApiToken:            os.Getenv("API_TOKEN"),
+		AppHost:             os.Getenv("APP_HOST"),
+		DbConnectionString:  os.Getenv("DB_CONNECTION_STRING"),
+		LogFormat:           r.getStringEnv("LOG_FORMAT", "text"),
+		LogLevel:            r.getStringEnv("LOG_LEVEL", "info"),
+		Location:            r.getLocationEnv("TIMEZONE", "UTC"),
+		ShutdownGracePeriod: r.getPositiveDurationEnv("SHUTDOWN_GRACE_PERIOD", 15*time.Second),
+		RequestTimeout:      r.getPositiveDurationEnv("REQUEST_TIMEOUT", 10*time.Second),
+		RouteTimeouts:       r.getDurationMapEnv("ROUTE_TIMEOUTS"),
+		QueryCacheSize:      r.getIntRangeEnv("QUERY_CACHE_SIZE", 256, 0, math.MaxInt),
+		QueryCacheTTL:       r.getDurationEnv("QUERY_CACHE_TTL", time.Minute),
+		IdempotencyTTL:      r.getPositiveDurationEnv("IDEMPOTENCY_TTL", 24*time.Hour),
+		FillMaxGapDays:      r.getIntRangeEnv("FILL_MAX_GAP_DAYS", 7, 0, 3660),
+		MaxRangeDays:        r.getIntRangeEnv("MAX_RANGE_DAYS", 3660, 1, math.MaxInt),
+
+		AnomalyMethod:             r.getChoiceEnv("ANOMALY_METHOD", "zscore", "zscore", "iqr"),
+		AnomalyThreshold:          r.getPositiveFloatEnv("ANOMALY_THRESHOLD", 3),
+		AnomalyWindowDays:         r.getIntRangeEnv("ANOMALY_WINDOW_DAYS", 30, 1, 3660),
+		AnomalySeasonalWindowDays: r.getIntRangeEnv("ANOMALY_SEASONAL_WINDOW_DAYS", 7, 0, 182),
+		AnomalyMinSamples:         r.getIntRangeEnv("ANOMALY_MIN_SAMPLES", 10, 1, math.MaxInt),
+
+		NormalsStartYear:  r.getIntEnv("NORMALS_START_YEAR", 0),
+		NormalsEndYear:    r.getIntEnv("NORMALS_END_YEAR", 0),
+		NormalsWindowDays: r.getIntRangeEnv("NORMALS_WINDOW_DAYS", 7, 0, 182),
+
+		ForecastModel:      r.getChoiceEnv("FORECAST_MODEL", "holt", "seasonal_naive", "holt", "holt_winters"),
+		ForecastSeasonDays: r.getIntRangeEnv("FORECAST_SEASON_DAYS", 365, 1, math.MaxInt),
+
+		DegreeDayBase: r.getFloatEnv("DEGREE_DAY_BASE", 18),
+		DegreeDayUnit: r.getChoiceEnv("DEGREE_DAY_UNIT", "C", "C", "F"),
+	}
+
+	// a year of 0 leaves that end of the reference period open
+	if conf.NormalsStartYear != 0 && conf.NormalsEndYear != 0 && conf.NormalsStartYear > conf.NormalsEndYear {
+		r.fail("NORMALS_START_YEAR environment variable must not be after NORMALS_END_YEAR: %d > %d", conf.NormalsStartYear, conf.NormalsEndYear)
+	}
+	if conf.ApiToken == "" {
+		r.fail("API_TOKEN environment variable is not set")
+	}
+	if conf.AppHost == "" {
+		r.fail("APP_HOST environment variable is not set")
+	}
+	if conf.DbConnectionString == "" {
+		r.fail("DB_CONNECTION_STRING environment variable is not set")
+	}
+	if err := errors.Join(r.errs...); err != nil {
+		return nil, err
+	}
+	return conf, nil
 }
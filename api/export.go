@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"weatherapi/services"
+	"weatherapi/utils"
+)
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// writeRecords writes the records as csv with a header, as tsv in the format read by ingest, or as the JSON of the API
+func writeRecords(writer io.Writer, format string, records []services.WeatherRecordResponse) error {
+	switch format {
+	case "json":
+		if records == nil {
+			records = []services.WeatherRecordResponse{}
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "tsv":
+		for _, record := range records {
+			line := strings.Join([]string{record.Date, formatFloat(record.Raw.Humidity), formatFloat(record.Raw.Temperature)}, "\t")
+			if _, err := io.WriteString(writer, line+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{"date", "humidity", "temperature", "anomaly_fields"})
+	for _, record := range records {
+		csvWriter.Write([]string{
+			record.Date,
+			formatFloat(record.Raw.Humidity),
+			formatFloat(record.Raw.Temperature),
+			strings.Join(record.AnomalyFields, ","),
+		})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// runExport writes the records of a range, both bounds are optional
+func runExport(args []string) int {
+	flags, env := newFlagSet("export", "export [flags]")
+	from := flags.String("from", "", "first date of the range, YYYY-MM-DD (default: the first record)")
+	to := flags.String("to", "", "last date of the range, YYYY-MM-DD (default: the last record)")
+	format := flags.String("format", "csv", "output format: csv, tsv or json")
+	output := flags.String("output", "", "file to write to (default: stdout)")
+	args, code, ok := parseFlags(flags, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(flags, "export takes no arguments")
+	}
+	if *format != "csv" && *format != "tsv" && *format != "json" {
+		return usageError(flags, "invalid format: %s", *format)
+	}
+	for _, date := range []string{*from, *to} {
+		if date != "" && !utils.IsValidDate(date) {
+			return usageError(flags, "invalid date: %s", date)
+		}
+	}
+
+	service, _, closeDb, code := openService(env)
+	if code != exitOK {
+		return code
+	}
+	defer closeDb()
+
+	result, err := service.GetWeatherRecordsForRange(context.Background(), *from, *to, services.RangeOptions{})
+	if err != nil {
+		return fail(exitFailure, "%v", err)
+	}
+
+	writer := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	if err := writeRecords(writer, *format, result.Records); err != nil {
+		return fail(exitFailure, "%v", err)
+	}
+	return exitOK
+}
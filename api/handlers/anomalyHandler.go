@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log/slog"
+	"strconv"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handlers) GetAnomalies(c *fiber.Ctx) error {
+	from := c.Query("from")
+	to := c.Query("to")
+
+	if !utils.IsValidDate(from) {
+		slog.WarnContext(c.UserContext(), "Invalid 'from' date format", "value", from)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !utils.IsValidDate(to) {
+		slog.WarnContext(c.UserContext(), "Invalid 'to' date format", "value", to)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	settings, err := h.service.DefaultAnomalySettings()
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error loading anomaly settings", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+
+	if method := c.Query("method"); method != "" {
+		settings.Method, err = services.ParseAnomalyMethod(method)
+		if err != nil {
+			slog.WarnContext(c.UserContext(), "Invalid 'method'", "value", method)
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+	}
+	if threshold := c.Query("threshold"); threshold != "" {
+		settings.Threshold, err = strconv.ParseFloat(threshold, 64)
+		if err != nil || settings.Threshold <= 0 {
+			slog.WarnContext(c.UserContext(), "Invalid 'threshold'", "value", threshold)
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+	}
+
+	results, err := h.service.ScanForAnomalies(c.UserContext(), from, to, settings)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error scanning for anomalies", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(results)
+}
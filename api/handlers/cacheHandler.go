@@ -0,0 +1,9 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handlers) GetCacheStatistics(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.service.GetCacheStatistics())
+}
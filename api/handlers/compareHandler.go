@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log/slog"
+	"strings"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// parsePeriod splits a "YYYY-MM-DD/YYYY-MM-DD" period into its validated bounds
+func parsePeriod(period string) (string, string, bool) {
+	from, to, found := strings.Cut(period, "/")
+	if !found || !utils.IsValidDate(from) || !utils.IsValidDate(to) || to < from {
+		return "", "", false
+	}
+	return from, to, true
+}
+
+func (h *Handlers) ComparePeriods(c *fiber.Ctx) error {
+	fromA, toA, ok := parsePeriod(c.Query("a"))
+	if !ok {
+		slog.WarnContext(c.UserContext(), "Invalid period 'a'", "value", c.Query("a"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	fromB, toB, ok := parsePeriod(c.Query("b"))
+	if !ok {
+		slog.WarnContext(c.UserContext(), "Invalid period 'b'", "value", c.Query("b"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	if h.exceedsMaxRange(fromA, toA) || h.exceedsMaxRange(fromB, toB) {
+		slog.WarnContext(c.UserContext(), "Range exceeds the maximum number of days", "a", c.Query("a"), "b", c.Query("b"), "max_days", h.conf.MaxRangeDays)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	result, err := h.service.ComparePeriods(c.UserContext(), fromA, toA, fromB, toB)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error comparing periods", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(result)
+}
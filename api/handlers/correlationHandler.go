@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"log/slog"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// the largest lag, in days, that may be requested
+const maxCorrelationLag = 365
+
+func (h *Handlers) GetCorrelation(c *fiber.Ctx) error {
+	columnsConfig := h.columns
+	from := c.Query("from")
+	to := c.Query("to")
+
+	x, ok := columnsConfig.FindMeasurement(c.Query("x"))
+	if !ok {
+		slog.WarnContext(c.UserContext(), "Invalid 'x' field", "value", c.Query("x"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	y, ok := columnsConfig.FindMeasurement(c.Query("y"))
+	if !ok {
+		slog.WarnContext(c.UserContext(), "Invalid 'y' field", "value", c.Query("y"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !utils.IsValidDate(from) {
+		slog.WarnContext(c.UserContext(), "Invalid 'from' date format", "value", from)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !utils.IsValidDate(to) || to < from {
+		slog.WarnContext(c.UserContext(), "Invalid 'to' date format", "value", to)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if h.exceedsMaxRange(from, to) {
+		slog.WarnContext(c.UserContext(), "Range exceeds the maximum number of days", "from", from, "to", to, "max_days", h.conf.MaxRangeDays)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	lag := c.QueryInt("lag", 0)
+	if lag < -maxCorrelationLag || lag > maxCorrelationLag {
+		slog.WarnContext(c.UserContext(), "Invalid 'lag'", "value", c.Query("lag"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	maxLag := c.QueryInt("max_lag", 0)
+	if maxLag < 0 || maxLag > maxCorrelationLag {
+		slog.WarnContext(c.UserContext(), "Invalid 'max_lag'", "value", c.Query("max_lag"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	result, err := h.service.GetCorrelation(c.UserContext(), x, y, from, to, lag, maxLag)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error getting correlation", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(result)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handlers) GetDegreeDays(c *fiber.Ctx) error {
+	conf := h.conf
+	from := c.Query("from")
+	to := c.Query("to")
+
+	if !utils.IsValidDate(from) {
+		slog.WarnContext(c.UserContext(), "Invalid 'from' date format", "value", from)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !utils.IsValidDate(to) || to < from {
+		slog.WarnContext(c.UserContext(), "Invalid 'to' date format", "value", to)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if h.exceedsMaxRange(from, to) {
+		slog.WarnContext(c.UserContext(), "Range exceeds the maximum number of days", "from", from, "to", to, "max_days", h.conf.MaxRangeDays)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	options := services.DegreeDayOptions{
+		Unit:   c.Query("unit", conf.DegreeDayUnit),
+		Bucket: c.Query("bucket", "month"),
+	}
+	if !services.IsValidTemperatureUnit(options.Unit) {
+		slog.WarnContext(c.UserContext(), "Invalid 'unit'", "value", options.Unit)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !services.IsValidDegreeDayBucket(options.Bucket) {
+		slog.WarnContext(c.UserContext(), "Invalid 'bucket'", "value", options.Bucket)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	// the configured base is converted when a different unit is requested without a base
+	options.Base = services.ConvertTemperature(conf.DegreeDayBase, conf.DegreeDayUnit, options.Unit)
+	if base := c.Query("base"); base != "" {
+		var err error
+		options.Base, err = strconv.ParseFloat(base, 64)
+		if err != nil || math.IsNaN(options.Base) || math.IsInf(options.Base, 0) {
+			slog.WarnContext(c.UserContext(), "Invalid 'base'", "value", base)
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+	}
+
+	result, err := h.service.GetDegreeDays(c.UserContext(), from, to, options)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error getting degree days", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(result)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"log/slog"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// the largest number of highs and lows that may be requested per measurement
+const maxExtremes = 100
+
+func (h *Handlers) GetExtremes(c *fiber.Ctx) error {
+	from := c.Query("from")
+	to := c.Query("to")
+
+	if !utils.IsValidDate(from) {
+		slog.WarnContext(c.UserContext(), "Invalid 'from' date format", "value", from)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !utils.IsValidDate(to) {
+		slog.WarnContext(c.UserContext(), "Invalid 'to' date format", "value", to)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	n := c.QueryInt("n", 10)
+	if n < 1 || n > maxExtremes {
+		slog.WarnContext(c.UserContext(), "Invalid 'n'", "value", c.Query("n"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	results, err := h.service.GetExtremes(c.UserContext(), from, to, n)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error getting extremes", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(results)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// the longest forecast or backtest that may be requested, in days
+const maxForecastDays = 365
+
+func (h *Handlers) GetForecast(c *fiber.Ctx) error {
+	conf := h.conf
+
+	model, err := utils.ParseForecastModel(c.Query("model", conf.ForecastModel))
+	if err != nil {
+		slog.WarnContext(c.UserContext(), "Invalid 'model'", "value", c.Query("model"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	options := services.ForecastOptions{
+		Model:      model,
+		SeasonDays: c.QueryInt("season", conf.ForecastSeasonDays),
+		Days:       c.QueryInt("days", 7),
+	}
+	if options.SeasonDays < 1 {
+		slog.WarnContext(c.UserContext(), "Invalid 'season'", "value", c.Query("season"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if options.Days < 1 || options.Days > maxForecastDays {
+		slog.WarnContext(c.UserContext(), "Invalid 'days'", "value", c.Query("days"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	if c.Query("backtest") != "" {
+		holdout := c.QueryInt("backtest")
+		if holdout < 1 || holdout > maxForecastDays {
+			slog.WarnContext(c.UserContext(), "Invalid 'backtest'", "value", c.Query("backtest"))
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+
+		result, err := h.service.Backtest(c.UserContext(), options, holdout)
+		if err != nil {
+			return forecastError(c, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(result)
+	}
+
+	results, err := h.service.GetForecast(c.UserContext(), options)
+	if err != nil {
+		return forecastError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(results)
+}
+
+func forecastError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, utils.ErrInsufficientHistory) {
+		slog.WarnContext(c.UserContext(), "Not enough history to forecast", "error", err)
+		return c.Status(fiber.StatusUnprocessableEntity).SendString("Not enough history to forecast")
+	}
+	slog.ErrorContext(c.UserContext(), "Error forecasting weather", "error", err)
+	return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"time"
+	"weatherapi/configs"
+	"weatherapi/services"
+	"weatherapi/utils"
+)
+
+// Broadcaster sends a message to all WebSocket clients, socketio.Broadcast outside of tests
+type Broadcaster func(message []byte, messageType ...int)
+
+// Handlers serves the routes of the API
+type Handlers struct {
+	service     *services.Service
+	conf        *configs.Config
+	columns     *configs.ColumnsConfig
+	clock       utils.Clock
+	broadcaster Broadcaster
+}
+
+func New(service *services.Service, conf *configs.Config, columns *configs.ColumnsConfig, clock utils.Clock, broadcaster Broadcaster) *Handlers {
+	return &Handlers{
+		service:     service,
+		conf:        conf,
+		columns:     columns,
+		clock:       clock,
+		broadcaster: broadcaster,
+	}
+}
+
+// exceedsMaxRange reports whether the range spans more days than may be requested, the dates are expected to be valid
+func (h *Handlers) exceedsMaxRange(from string, to string) bool {
+	fromDate, _ := time.Parse(h.columns.DateFormat, from)
+	toDate, _ := time.Parse(h.columns.DateFormat, to)
+	return utils.DaysBetween(fromDate, toDate)+1 > h.conf.MaxRangeDays
+}
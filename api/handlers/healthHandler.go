@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"log/slog"
+	"weatherapi/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Healthz reports that the process is alive, without checking any dependency
+func (h *Handlers) Healthz(c *fiber.Ctx) error {
+	return c.SendString("OK")
+}
+
+// Readyz reports whether every dependency needed to serve requests is available
+func (h *Handlers) Readyz(c *fiber.Ctx) error {
+	response := h.service.ReadinessChecks(c.UserContext())
+	if response.Status != services.CheckOk {
+		slog.WarnContext(c.UserContext(), "Readiness check failed", "checks", response.Checks)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(response)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (h *Handlers) GetStatus(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.service.GetStatus(c.UserContext(), h.clock.Now()))
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// the largest number of bins that may be requested
+const maxHistogramBins = 1000
+
+// parseEdges parses comma separated, finite and strictly ascending bin edges
+func parseEdges(value string) ([]float64, bool) {
+	parts := strings.Split(value, ",")
+	if len(parts) < 2 || len(parts) > maxHistogramBins+1 {
+		return nil, false
+	}
+	var edges []float64
+	for i, part := range parts {
+		edge, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(edge) || math.IsInf(edge, 0) || (i > 0 && edge <= edges[i-1]) {
+			return nil, false
+		}
+		edges = append(edges, edge)
+	}
+	return edges, true
+}
+
+func (h *Handlers) GetHistogram(c *fiber.Ctx) error {
+	from := c.Query("from")
+	to := c.Query("to")
+
+	measurement, ok := h.columns.FindMeasurement(c.Query("field"))
+	if !ok {
+		slog.WarnContext(c.UserContext(), "Invalid 'field'", "value", c.Query("field"))
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !utils.IsValidDate(from) {
+		slog.WarnContext(c.UserContext(), "Invalid 'from' date format", "value", from)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+	if !utils.IsValidDate(to) {
+		slog.WarnContext(c.UserContext(), "Invalid 'to' date format", "value", to)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	var edges []float64
+	if c.Query("edges") != "" {
+		if c.Query("bins") != "" {
+			slog.WarnContext(c.UserContext(), "Both 'bins' and 'edges' passed")
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+		edges, ok = parseEdges(c.Query("edges"))
+		if !ok {
+			slog.WarnContext(c.UserContext(), "Invalid 'edges'", "value", c.Query("edges"))
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+	}
+
+	bins := 20
+	if value := c.Query("bins"); value != "" {
+		var err error
+		bins, err = strconv.Atoi(value)
+		if err != nil || bins < 1 || bins > maxHistogramBins {
+			slog.WarnContext(c.UserContext(), "Invalid 'bins'", "value", value)
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+	}
+
+	result, err := h.service.GetHistogram(c.UserContext(), measurement, from, to, bins, edges)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error getting histogram", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(result)
+}
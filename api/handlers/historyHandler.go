@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"log/slog"
+	"strings"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetWeatherHistory lists the changes of the record of a date, a date without changes has an empty history
+func (h *Handlers) GetWeatherHistory(c *fiber.Ctx) error {
+	// the parameter is only valid during the request
+	date := strings.Clone(c.Params("date"))
+	if !utils.IsValidDate(date) {
+		slog.WarnContext(c.UserContext(), "Invalid date format", "value", date)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	history, err := h.service.GetWeatherHistory(c.UserContext(), date)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error getting weather history", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(history)
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"log/slog"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handlers) GetNormals(c *fiber.Ctx) error {
+	dayOfYear := 0
+	if doy := c.Query("doy"); doy != "" {
+		var err error
+		dayOfYear, err = strconv.Atoi(doy)
+		if err != nil || dayOfYear < 1 || dayOfYear > 365 {
+			slog.WarnContext(c.UserContext(), "Invalid 'doy'", "value", doy)
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+	}
+
+	results, err := h.service.GetNormals(c.UserContext(), dayOfYear)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error getting normals", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(results)
+}
+
+func (h *Handlers) RecomputeNormals(c *fiber.Ctx) error {
+	if err := h.service.RecomputeAllNormals(c.UserContext()); err != nil {
+		slog.ErrorContext(c.UserContext(), "Error recomputing normals", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
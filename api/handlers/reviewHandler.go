@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"weatherapi/models"
+	"weatherapi/repository"
+	"weatherapi/services"
+	"weatherapi/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReviewBody is the quality state a review moves a record to
+type ReviewBody struct {
+	Quality string `json:"quality"`
+}
+
+// ReviewWeatherRecord moves the record of a date to another quality state. Reviews are recorded in the history of the record
+// with the admin source, the transitions that are allowed are listed in the README.
+func (h *Handlers) ReviewWeatherRecord(c *fiber.Ctx) error {
+	author := repository.AuthorOf(c.UserContext())
+	author.Source = repository.SourceAdmin
+	ctx := repository.WithAuthor(c.UserContext(), author)
+
+	// the parameter is only valid during the request
+	date := strings.Clone(c.Params("date"))
+	if !utils.IsValidDate(date) {
+		slog.WarnContext(ctx, "Invalid date format", "value", date)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	review := new(ReviewBody)
+	if err := c.BodyParser(review); err != nil {
+		slog.ErrorContext(ctx, "Error parsing request body", "error", err)
+		validationRejections.Inc("invalid_body")
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid request")
+	}
+	if !models.IsQuality(review.Quality) {
+		slog.WarnContext(ctx, "Invalid quality", "value", review.Quality)
+		validationRejections.Inc("invalid_quality")
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
+
+	reviewed, err := h.service.ReviewWeatherRecord(ctx, date, review.Quality)
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).SendString("No record for date")
+	}
+	if errors.Is(err, services.ErrInvalidQualityTransition) {
+		slog.WarnContext(ctx, "Rejected review", "date", date, "error", err)
+		return c.Status(fiber.StatusUnprocessableEntity).SendString(err.Error())
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reviewing weather record", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+
+	// the values did not change, so the anomaly event of the record is not sent again
+	if err := h.broadcast(ctx, "record", reviewed); err != nil {
+		slog.ErrorContext(ctx, "Error marshalling record to JSON", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+	return c.Status(fiber.StatusOK).JSON(reviewed)
+}
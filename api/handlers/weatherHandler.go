@@ -1,9 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
-	"weatherapi/configs"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"weatherapi/metrics"
+	"weatherapi/models"
+	"weatherapi/repository"
 	"weatherapi/services"
 	"weatherapi/utils"
 
@@ -11,103 +19,394 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-// abstracted to make it mockable in tests
-var BroadcastFunc = socketio.Broadcast
+var (
+	broadcasts           = metrics.Counter("websocket_broadcasts_total", "Number of messages broadcast to WebSocket clients", "event")
+	broadcastFailures    = metrics.Counter("websocket_broadcast_failures_total", "Number of messages that could not be broadcast", "event")
+	validationRejections = metrics.Counter("validation_rejections_total", "Number of rejected write requests", "reason")
+)
+
+// AnomalyEvent is broadcast in addition to the record itself when a new record is flagged as anomalous
+type AnomalyEvent struct {
+	Event  string                         `json:"event"`
+	Record services.WeatherRecordResponse `json:"record"`
+}
+
+// resolveRange resolves the date tokens of a route to explicit bounds, and echoes them in the response headers
+func (h *Handlers) resolveRange(c *fiber.Ctx, fromToken string, toToken string) (string, string, bool) {
+	dateFormat := h.columns.DateFormat
+	today := utils.Today(h.clock, h.conf.Location)
+
+	from, _, err := utils.ResolveDateRange(fromToken, today)
+	if err != nil {
+		slog.WarnContext(c.UserContext(), "Invalid 'from' date format", "value", fromToken)
+		return "", "", false
+	}
+	_, to, err := utils.ResolveDateRange(toToken, today)
+	if err != nil {
+		slog.WarnContext(c.UserContext(), "Invalid 'to' date format", "value", toToken)
+		return "", "", false
+	}
 
-func GetWeatherRecordsForSingleDay(c *fiber.Ctx) error {
-	from := c.Params("from")
+	c.Set("X-Range-From", from.Format(dateFormat))
+	c.Set("X-Range-To", to.Format(dateFormat))
+	return from.Format(dateFormat), to.Format(dateFormat), true
+}
 
-	if !utils.IsValidDate(from) {
-		log.Println("Invalid 'from' date format:", from)
+func (h *Handlers) GetWeatherRecordsForSingleDay(c *fiber.Ctx) error {
+	from, to, ok := h.resolveRange(c, c.Params("from"), c.Params("from"))
+	if !ok {
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
 	}
 
-	results, err := services.GetWeatherRecordsForSingleDay(from)
+	// months, weeks and relative ranges cover multiple days, the options of range queries are handled by the range query
+	if from != to || c.Query("as_of") != "" || c.Query("quality") != "" || c.Query("fill") != "" || c.Query("with") != "" {
+		return h.getWeatherRecordsForRange(c, from, to)
+	}
+
+	result, err := h.service.GetWeatherRecordsForSingleDay(c.UserContext(), from)
 	if err != nil {
-		log.Println("Error getting weather records:", err)
+		slog.ErrorContext(c.UserContext(), "Error getting weather records", "error", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
 	}
-	return c.Status(fiber.StatusOK).JSON(results)
+	return sendQueryResult(c, result)
 }
 
-func GetWeatherRecordsForRange(c *fiber.Ctx) error {
-	from := c.Params("from")
-	to := c.Params("to")
+// isNotModified evaluates the conditional request headers against the result.
+// If-None-Match takes precedence over If-Modified-Since, which fiber's Fresh does not honor on its own.
+func isNotModified(c *fiber.Ctx, result services.QueryResult) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		for _, etag := range strings.Split(noneMatch, ",") {
+			etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+			if etag == "*" || etag == result.ETag {
+				return true
+			}
+		}
+		return false
+	}
+
+	modifiedSince, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince))
+	if err != nil || result.LastModified.IsZero() {
+		return false
+	}
+	// the header has a resolution of seconds
+	return !result.LastModified.Truncate(time.Second).After(modifiedSince)
+}
 
-	if !utils.IsValidDate(from) {
-		log.Println("Invalid 'from' date format:", from)
+// sendQueryResult sets the validators of the result, and answers conditional requests that are still fresh with 304
+func sendQueryResult(c *fiber.Ctx, result services.QueryResult) error {
+	c.Set(fiber.HeaderETag, result.ETag)
+	if !result.LastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, result.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if isNotModified(c, result) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.Status(fiber.StatusOK).JSON(result.Records)
+}
+
+func (h *Handlers) GetWeatherRecordsForRange(c *fiber.Ctx) error {
+	from, to, ok := h.resolveRange(c, c.Params("from"), c.Params("to"))
+	if !ok {
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
 	}
-	if !utils.IsValidDate(to) {
-		log.Println("Invalid 'to' date format:", to)
+	return h.getWeatherRecordsForRange(c, from, to)
+}
+
+func (h *Handlers) getWeatherRecordsForRange(c *fiber.Ctx, from string, to string) error {
+	fill, err := services.ParseFillMethod(c.Query("fill"))
+	if err != nil {
+		slog.WarnContext(c.UserContext(), "Invalid 'fill' method", "value", c.Query("fill"))
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
 	}
 
-	results, err := services.GetWeatherRecordsForRange(from, to)
+	options := services.RangeOptions{Fill: fill}
+	if value := c.Query("as_of"); value != "" {
+		asOf, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			slog.WarnContext(c.UserContext(), "Invalid 'as_of' time", "value", value)
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+		options.AsOf = &asOf
+	}
+	if value := c.Query("quality"); value != "" {
+		for _, quality := range strings.Split(value, ",") {
+			if !models.IsQuality(quality) {
+				slog.WarnContext(c.UserContext(), "Invalid 'quality' state", "value", quality)
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+			}
+			// the query is only valid during the request, and the options are part of the cache key
+			options.Quality = append(options.Quality, strings.Clone(quality))
+		}
+	}
+	for _, with := range strings.Split(c.Query("with"), ",") {
+		switch with {
+		case "":
+		case "departure":
+			options.WithDeparture = true
+		default:
+			slog.WarnContext(c.UserContext(), "Invalid 'with' option", "value", with)
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+		}
+	}
+
+	result, err := h.service.GetWeatherRecordsForRange(c.UserContext(), from, to, options)
+	if errors.Is(err, services.ErrGapTooLarge) {
+		slog.WarnContext(c.UserContext(), "Refusing to fill gap", "error", err)
+		return c.Status(fiber.StatusUnprocessableEntity).SendString("Gap too large to fill")
+	}
 	if err != nil {
-		log.Println("Error getting weather records:", err)
+		slog.ErrorContext(c.UserContext(), "Error getting weather records", "error", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
 	}
-	return c.Status(fiber.StatusOK).JSON(results)
+	return sendQueryResult(c, result)
 }
 
-func CreateWeatherRecord(c *fiber.Ctx) error {
-	conf := configs.Get()
-	if c.Get("X-Api-Token") != conf.ApiToken {
-		log.Println("Invalid or missing API token")
+// Authorize rejects requests without a valid API token with 401. It runs before the other middlewares of the write routes,
+// so that nothing is done or stored on behalf of unauthorized requests.
+func (h *Handlers) Authorize(c *fiber.Ctx) error {
+	if !h.isAuthorized(c) {
+		validationRejections.Inc("unauthorized")
 		return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
 	}
+	return c.Next()
+}
+
+// isAuthorized accepts the configured API token, and the tokens created with `weatherapi tokens create` until they are revoked.
+// The changes of authorized requests are recorded as made by the token, api_token for the configured one and token:<id> otherwise.
+func (h *Handlers) isAuthorized(c *fiber.Ctx) bool {
+	conf := h.conf
+	secret := c.Get("X-Api-Token")
+	if secret == "" {
+		slog.WarnContext(c.UserContext(), "Missing API token")
+		return false
+	}
+	actor := "api_token"
+	if secret != conf.ApiToken {
+		token, err := h.service.FindValidToken(c.UserContext(), secret)
+		if err != nil {
+			slog.ErrorContext(c.UserContext(), "Error checking API token", "error", err)
+			return false
+		}
+		if token == nil {
+			slog.WarnContext(c.UserContext(), "Invalid or revoked API token")
+			return false
+		}
+		actor = fmt.Sprintf("token:%d", token.ID)
+	}
+
+	c.SetUserContext(repository.WithAuthor(c.UserContext(), repository.Author{Actor: actor, Source: repository.SourceApi}))
+	return true
+}
+
+// broadcast sends the JSON encoded payload to all WebSocket clients
+func (h *Handlers) broadcast(ctx context.Context, event string, payload any) error {
+	message, err := json.Marshal(payload)
+	if err != nil {
+		broadcastFailures.Inc(event)
+		return err
+	}
+
+	slog.InfoContext(ctx, "Broadcasting", "event", event, "message", string(message))
+	h.broadcaster(message, socketio.TextMessage)
+	broadcasts.Inc(event)
+	return nil
+}
+
+// maxBatchSize is the maximum number of records of a batch write
+const maxBatchSize = 1000
+
+const invalidConflictStrategy = "Invalid on_conflict, expected error, skip, overwrite or keep_newer"
+
+// isValidRecord rejects invalid records, counting the reason
+func (h *Handlers) isValidRecord(c *fiber.Ctx, record *services.WeatherRecordBody) bool {
+	var validationErr *services.ValidationError
+	if err := services.ValidateWeatherRecord(record, h.clock, h.conf.Location); errors.As(err, &validationErr) {
+		slog.WarnContext(c.UserContext(), "Invalid weather record", "reason", validationErr.Reason, "error", err)
+		validationRejections.Inc(validationErr.Reason)
+		return false
+	}
+	return true
+}
+
+// conflictStrategy parses the on_conflict query parameter of writes, by default a record of a taken date is a conflict
+func conflictStrategy(c *fiber.Ctx) (repository.ConflictStrategy, bool) {
+	strategy, ok := repository.ParseConflictStrategy(c.Query("on_conflict"))
+	if !ok {
+		slog.WarnContext(c.UserContext(), "Invalid conflict strategy", "on_conflict", c.Query("on_conflict"))
+		validationRejections.Inc("invalid_on_conflict")
+	}
+	return strategy, ok
+}
+
+// sendSavedRecord responds with a saved record, 201 when it was inserted. The outcome is in the X-Write-Outcome header.
+func sendSavedRecord(c *fiber.Ctx, record services.WeatherRecordResponse, outcome repository.UpsertOutcome) error {
+	c.Set("X-Write-Outcome", string(outcome))
+	if outcome == repository.Inserted {
+		return c.Status(fiber.StatusCreated).JSON(record)
+	}
+	return c.Status(fiber.StatusOK).JSON(record)
+}
+
+// broadcastRecord sends a saved record to all WebSocket clients, followed by an anomaly event when it was flagged
+func (h *Handlers) broadcastRecord(ctx context.Context, record services.WeatherRecordResponse) error {
+	if err := h.broadcast(ctx, "record", record); err != nil {
+		return fmt.Errorf("error marshalling record to JSON: %v", err)
+	}
+	if len(record.AnomalyFields) > 0 {
+		if err := h.broadcast(ctx, "anomaly", AnomalyEvent{Event: "anomaly", Record: record}); err != nil {
+			return fmt.Errorf("error marshalling anomaly event to JSON: %v", err)
+		}
+	}
+	return nil
+}
+
+// CreateWeatherRecord creates a record, the on_conflict parameter decides what happens when its date already has one.
+// Only records that were inserted or updated are broadcast.
+func (h *Handlers) CreateWeatherRecord(c *fiber.Ctx) error {
+	strategy, ok := conflictStrategy(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).SendString(invalidConflictStrategy)
+	}
 
 	record := new(services.WeatherRecordBody)
 
 	if err := c.BodyParser(record); err != nil {
-		log.Println("Error parsing request body:", err)
+		slog.ErrorContext(c.UserContext(), "Error parsing request body", "error", err)
+		validationRejections.Inc("invalid_body")
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid request")
 	}
 
-	// Note: A validation library would simplify these checks
-	if !utils.IsValidDate(record.RecordedAt) {
-		log.Println("Invalid date format:", record.RecordedAt)
+	if !h.isValidRecord(c, record) {
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
 	}
 
-	if utils.IsDateInFuture(record.RecordedAt) {
-		log.Println("Date is in the future:", record.RecordedAt)
-		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	slog.InfoContext(c.UserContext(), "Received request to create", "date", record.RecordedAt, "humidity", record.Humidity, "temperature", record.Temperature, "on_conflict", strategy)
+
+	// the unique index of the dates detects records of dates that already have one
+	saved, outcome, err := h.service.SaveWeatherRecord(c.UserContext(), record, strategy)
+	if errors.Is(err, repository.ErrDuplicateDate) {
+		slog.WarnContext(c.UserContext(), "Record already exists for date", "date", record.RecordedAt)
+		validationRejections.Inc("duplicate_date")
+		return c.Status(fiber.StatusConflict).SendString("Record already exists for date")
+	}
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Error creating weather record", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+
+	if outcome != repository.Unchanged {
+		if err := h.broadcastRecord(c.UserContext(), saved); err != nil {
+			slog.ErrorContext(c.UserContext(), "Error broadcasting record", "error", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+		}
 	}
 
-	if record.Humidity < 0 || record.Humidity > 100 {
-		log.Println("Humidity out of range:", record.Humidity)
+	return sendSavedRecord(c, saved, outcome)
+}
+
+// PutWeatherRecord replaces the measurements of the record of a date, or creates it. The date of the body is optional.
+func (h *Handlers) PutWeatherRecord(c *fiber.Ctx) error {
+	record := new(services.WeatherRecordBody)
+	if err := c.BodyParser(record); err != nil {
+		slog.ErrorContext(c.UserContext(), "Error parsing request body", "error", err)
+		validationRejections.Inc("invalid_body")
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid request")
+	}
+
+	// the parameter is only valid during the request
+	date := strings.Clone(c.Params("date"))
+	if record.RecordedAt != "" && record.RecordedAt != date {
+		slog.WarnContext(c.UserContext(), "Date of the body does not match the path", "date", date, "body_date", record.RecordedAt)
+		validationRejections.Inc("date_mismatch")
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
 	}
+	record.RecordedAt = date
 
-	log.Println("Received request to create:", record)
+	if !h.isValidRecord(c, record) {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
+	}
 
-	// verify record is not already in the database
-	results, err := services.GetWeatherRecordsForSingleDay(record.RecordedAt)
+	saved, outcome, err := h.service.SaveWeatherRecord(c.UserContext(), record, repository.ConflictOverwrite)
+	if errors.Is(err, repository.ErrDuplicateDate) {
+		// created concurrently by another request
+		slog.WarnContext(c.UserContext(), "Record was created concurrently", "date", record.RecordedAt)
+		return c.Status(fiber.StatusConflict).SendString("Record was created concurrently, retry the request")
+	}
 	if err != nil {
-		log.Println("Error getting weather records:", err)
+		slog.ErrorContext(c.UserContext(), "Error saving weather record", "error", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
 	}
-	if len(results) > 0 {
-		log.Println("Record already exists for date:", record.RecordedAt)
-		return c.Status(fiber.StatusConflict).SendString("Record already exists for date")
+
+	if outcome != repository.Unchanged {
+		if err := h.broadcastRecord(c.UserContext(), saved); err != nil {
+			slog.ErrorContext(c.UserContext(), "Error broadcasting record", "error", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+		}
+	}
+
+	return sendSavedRecord(c, saved, outcome)
+}
+
+// DeleteWeatherRecord deletes the record of a date, its revisions keep the deleted values
+func (h *Handlers) DeleteWeatherRecord(c *fiber.Ctx) error {
+	// the parameter is only valid during the request
+	date := strings.Clone(c.Params("date"))
+	if !utils.IsValidDate(date) {
+		slog.WarnContext(c.UserContext(), "Invalid date format", "value", date)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid Request")
 	}
 
-	firstRecord, err := services.CreateWeatherRecord(record)
+	err := h.service.DeleteWeatherRecord(c.UserContext(), date)
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).SendString("No record for date")
+	}
 	if err != nil {
-		log.Println("Error creating weather record:", err)
+		slog.ErrorContext(c.UserContext(), "Error deleting weather record", "error", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
 	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// CreateWeatherRecords writes a batch of records. Every record has to be valid, the on_conflict parameter decides what happens to
+// records whose date already has one, which are reported as conflicts by default.
+func (h *Handlers) CreateWeatherRecords(c *fiber.Ctx) error {
+	strategy, ok := conflictStrategy(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).SendString(invalidConflictStrategy)
+	}
 
-	firstRecordJson, err := json.Marshal(firstRecord)
+	var records []services.WeatherRecordBody
+	if err := c.BodyParser(&records); err != nil {
+		slog.ErrorContext(c.UserContext(), "Error parsing request body", "error", err)
+		validationRejections.Inc("invalid_body")
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid request")
+	}
+	if len(records) == 0 || len(records) > maxBatchSize {
+		slog.WarnContext(c.UserContext(), "Invalid batch size", "size", len(records))
+		validationRejections.Inc("invalid_batch_size")
+		return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("A batch has between 1 and %d records", maxBatchSize))
+	}
+	for i := range records {
+		if !h.isValidRecord(c, &records[i]) {
+			return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("Invalid record at index %d", i))
+		}
+	}
+
+	response, err := h.service.SaveWeatherRecords(c.UserContext(), records, strategy)
 	if err != nil {
-		log.Println("Error marshalling record to JSON:", err)
+		slog.ErrorContext(c.UserContext(), "Error saving weather records", "error", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
 	}
 
-	log.Println("Broadcasting record:", string(firstRecordJson))
-	BroadcastFunc(firstRecordJson, socketio.TextMessage)
+	for _, result := range response.Results {
+		if result.Outcome != string(repository.Inserted) && result.Outcome != string(repository.Updated) {
+			continue
+		}
+		if err := h.broadcastRecord(c.UserContext(), *result.Record); err != nil {
+			slog.ErrorContext(c.UserContext(), "Error broadcasting record", "error", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+		}
+	}
 
-	return c.Status(fiber.StatusCreated).JSON(firstRecord)
+	return c.Status(fiber.StatusOK).JSON(response)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"weatherapi/repository"
+	"weatherapi/services"
+	"weatherapi/utils"
+)
+
+// ingestResult counts the outcome of saving a batch of records
+type ingestResult struct {
+	inserted  int
+	updated   int
+	unchanged int
+	rejected  int
+	// the ingestion batch of the saved records, empty when none was valid
+	batchID string
+}
+
+func (r ingestResult) String() string {
+	counts := fmt.Sprintf("inserted %d, updated %d, unchanged %d, rejected %d", r.inserted, r.updated, r.unchanged, r.rejected)
+	if r.batchID == "" {
+		return counts
+	}
+	return fmt.Sprintf("batch %s: %s", r.batchID, counts)
+}
+
+// saveRecords validates the records like POST /weather does, and saves the valid ones in a single batch.
+// The strategy decides what happens to dates that already have a record, conflicts of repository.ConflictError count as unchanged.
+func saveRecords(ctx context.Context, service *services.Service, location *time.Location, records []services.WeatherRecordBody, strategy repository.ConflictStrategy) (ingestResult, error) {
+	var result ingestResult
+	var valid []services.WeatherRecordBody
+	for _, record := range records {
+		if err := services.ValidateWeatherRecord(&record, utils.SystemClock{}, location); err != nil {
+			fmt.Fprintf(os.Stderr, "rejected %s: %v\n", record.RecordedAt, err)
+			result.rejected++
+			continue
+		}
+		valid = append(valid, record)
+	}
+	if len(valid) == 0 {
+		return result, nil
+	}
+
+	response, err := service.SaveWeatherRecords(ctx, valid, strategy)
+	if err != nil {
+		return result, err
+	}
+	result.batchID = response.BatchID
+	result.inserted = response.Inserted
+	result.updated = response.Updated
+	result.unchanged = response.Unchanged + response.Conflicts
+	return result, nil
+}
+
+// parseRecords reads lines of date, humidity and temperature separated by tabs, the format of data/weather.dat
+func parseRecords(reader io.Reader) ([]services.WeatherRecordBody, error) {
+	var records []services.WeatherRecordBody
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected date, humidity and temperature separated by tabs", line)
+		}
+		humidity, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid humidity: %v", line, err)
+		}
+		temperature, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid temperature: %v", line, err)
+		}
+		records = append(records, services.WeatherRecordBody{RecordedAt: fields[0], Humidity: humidity, Temperature: temperature})
+	}
+	return records, scanner.Err()
+}
+
+// runIngest saves the records of a file, or of stdin when the file is -
+func runIngest(args []string) int {
+	flags, env := newFlagSet("ingest", "ingest [flags] <file>")
+	onConflict := flags.String("on-conflict", string(repository.ConflictSkip), "what to do with dates that already have a record: skip, overwrite or keep_newer")
+	source := flags.String("source", "", "name of the source of the records (default: the name of the file, stdin for -)")
+	instrument := flags.String("instrument", "", "instrument that measured the records")
+	args, code, ok := parseFlags(flags, args)
+	if !ok {
+		return code
+	}
+	if len(args) != 1 {
+		return usageError(flags, "expected a single file")
+	}
+	strategy, ok := repository.ParseConflictStrategy(*onConflict)
+	if !ok || strategy == repository.ConflictError {
+		return usageError(flags, "invalid on-conflict: %s", *onConflict)
+	}
+
+	reader := io.Reader(os.Stdin)
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	records, err := parseRecords(reader)
+	if err != nil {
+		return fail(exitFailure, "%s: %v", args[0], err)
+	}
+	if *source == "" {
+		*source = "stdin"
+		if args[0] != "-" {
+			*source = filepath.Base(args[0])
+		}
+	}
+	for i := range records {
+		records[i].Source = *source
+		records[i].Instrument = *instrument
+	}
+
+	service, conf, closeDb, code := openService(env)
+	if code != exitOK {
+		return code
+	}
+	defer closeDb()
+
+	ctx := withCommandAuthor(context.Background(), repository.SourceIngest)
+	result, err := saveRecords(ctx, service, conf.Location, records, strategy)
+	fmt.Println(result)
+	if err != nil {
+		return fail(exitFailure, "%v", err)
+	}
+	if result.rejected > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
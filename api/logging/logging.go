@@ -0,0 +1,80 @@
+// Package logging configures log/slog and carries the request id through contexts into every log line
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type contextKey struct{}
+
+// RequestIDKey is the fiber locals key of the request id, locals are carried over to WebSocket connections
+const RequestIDKey = "request_id"
+
+// WithRequestID returns a context whose log lines carry the given request id
+func WithRequestID(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestId)
+}
+
+// RequestID returns the request id of the context, or an empty string
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(contextKey{}).(string)
+	return requestId
+}
+
+// contextHandler adds the request id of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestId := RequestID(ctx); requestId != "" {
+		record.AddAttrs(slog.String("request_id", requestId))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// New creates a logger writing "json" or "text" lines of at least the given level (debug, info, warn or error)
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+
+	options := &slog.HandlerOptions{Level: minLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected json or text", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Configure replaces the default logger, which the standard log package writes through as well
+func Configure(format string, level string) error {
+	logger, err := New(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDIsAddedToEveryLine(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, "json", "info")
+	assert.Nil(t, err)
+
+	ctx := WithRequestID(context.Background(), "abc-123")
+	logger.InfoContext(ctx, "creating record", "date", "2025-01-01")
+	logger.With("component", "websocket").WarnContext(ctx, "closed")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	for _, line := range lines {
+		var entry map[string]any
+		err := json.Unmarshal([]byte(line), &entry)
+		assert.Nil(t, err)
+		assert.Equal(t, "abc-123", entry["request_id"])
+	}
+}
+
+func TestWithoutRequestID(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, _ := New(&buffer, "text", "info")
+	logger.InfoContext(context.Background(), "starting")
+
+	assert.Contains(t, buffer.String(), "msg=starting")
+	assert.NotContains(t, buffer.String(), "request_id")
+	assert.Equal(t, "", RequestID(context.Background()))
+}
+
+func TestLevels(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, _ := New(&buffer, "text", "warn")
+	logger.Info("hidden")
+	logger.Warn("shown")
+
+	assert.NotContains(t, buffer.String(), "hidden")
+	assert.Contains(t, buffer.String(), "shown")
+}
+
+func TestInvalidSettings(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "xml", "info")
+	assert.NotNil(t, err)
+	_, err = New(&bytes.Buffer{}, "json", "verbose")
+	assert.NotNil(t, err)
+}
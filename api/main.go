@@ -1,39 +1,84 @@
 package main
 
 import (
-	"log"
-	"weatherapi/configs"
-	"weatherapi/handlers"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"weatherapi/app"
+	"weatherapi/utils"
 
-	"weatherapi/server"
-
-	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/contrib/socketio"
 )
 
-func Setup() *fiber.App {
-	app := fiber.New()
+func main() {
+	name, args := "serve", os.Args[1:]
+	// without a command the binary serves, flags included
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	for _, command := range commands {
+		if command.name == name {
+			os.Exit(command.run(args))
+		}
+	}
+	if name == "help" {
+		printUsage()
+		os.Exit(exitOK)
+	}
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n", name)
+	printUsage()
+	os.Exit(exitUsage)
+}
 
-	server.RegisterWebSocket(app)
+// runServe serves the API until SIGTERM or SIGINT, and refuses to start on a database that is not migrated
+func runServe(args []string) int {
+	flags, env := newFlagSet("serve", "serve [flags]")
+	args, code, ok := parseFlags(flags, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(flags, "serve takes no arguments")
+	}
 
-	app.Use(func(c *fiber.Ctx) error {
-		log.Printf("Request URL: %s %s", c.Method(), c.OriginalURL())
-		return c.Next()
-	})
+	conf, columns, code := loadConfig(env)
+	if code != exitOK {
+		return code
+	}
+	db, code := openDb(conf, true)
+	if code != exitOK {
+		return code
+	}
+	a := app.New(conf, db, columns, utils.SystemClock{}, socketio.Broadcast)
 
-	app.Get("/ping", func(c *fiber.Ctx) error {
-		return c.SendString("Pong")
-	})
-	app.Get("/weather/:from", handlers.GetWeatherRecordsForSingleDay)
-	app.Get("/weather/:from/:to", handlers.GetWeatherRecordsForRange)
-	app.Post("/weather", handlers.CreateWeatherRecord)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
-	return app
-}
+	listenErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "host", conf.AppHost)
+		listenErr <- a.Listen()
+	}()
 
-func main() {
-	app := Setup()
+	select {
+	case err := <-listenErr:
+		slog.Error("Server stopped", "error", err)
+		return exitFailure
+	case <-ctx.Done():
+	}
+	// a second signal terminates immediately
+	stop()
 
-	conf := configs.Get()
-	log.Println("Starting server at", conf.AppHost)
-	log.Fatal(app.Listen(conf.AppHost))
+	slog.Info("Shutting down", "grace_period", conf.ShutdownGracePeriod.String())
+	if err := a.Shutdown(conf.ShutdownGracePeriod); err != nil {
+		slog.Error("Shutdown incomplete", "error", err)
+		return exitFailure
+	}
+	slog.Info("Server stopped")
+	return exitOK
 }
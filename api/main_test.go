@@ -1,35 +1,87 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+	"weatherapi/app"
 	"weatherapi/configs"
 	"weatherapi/handlers"
+	"weatherapi/logging"
+	"weatherapi/migrations"
 	"weatherapi/models"
+	"weatherapi/repository"
 	"weatherapi/server"
 	"weatherapi/services"
+	"weatherapi/utils"
 
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
 )
 
 func TestConfigs(t *testing.T) {
-	configs := configs.Get()
-	assert.Contains(t, configs.DbConnectionString, "sqlite")
+	// the assertions hold for the test environment, whichever one the tests are run in
+	t.Setenv("APP_ENV", "test")
+	conf, err := configs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, conf.DbConnectionString, "sqlite")
+	assert.Equal(t, "UTC", conf.Location.String())
+
+	t.Run("reports every invalid value", func(t *testing.T) {
+		t.Setenv("REQUEST_TIMEOUT", "soon")
+		t.Setenv("QUERY_CACHE_SIZE", "many")
+		t.Setenv("ANOMALY_METHOD", "mad")
+		t.Setenv("NORMALS_WINDOW_DAYS", "-1")
+		t.Setenv("FORECAST_MODEL", "arima")
+		t.Setenv("DEGREE_DAY_UNIT", "K")
+
+		_, err := configs.Load()
+		for _, key := range []string{"REQUEST_TIMEOUT", "QUERY_CACHE_SIZE", "ANOMALY_METHOD", "NORMALS_WINDOW_DAYS", "FORECAST_MODEL", "DEGREE_DAY_UNIT"} {
+			assert.ErrorContains(t, err, key)
+		}
+	})
+
+	t.Run("rejects values out of range", func(t *testing.T) {
+		t.Setenv("QUERY_CACHE_SIZE", "-1")
+		t.Setenv("NORMALS_WINDOW_DAYS", "400")
+
+		_, err := configs.Load()
+		assert.ErrorContains(t, err, "QUERY_CACHE_SIZE")
+		assert.ErrorContains(t, err, "NORMALS_WINDOW_DAYS")
+	})
 }
 
 func TestColumnConfigs(t *testing.T) {
-	columns := configs.GetColumns()
+	columns, err := configs.LoadColumns("configs/columns.yaml")
+	assert.Nil(t, err)
 	assert.Equal(t, columns.DateFormat, "2006-01-02")
 	assert.Equal(t, columns.HumidityFormat, "%")
 	assert.Equal(t, columns.TemperatureFormat, "°C")
+	assert.Equal(t, columns.Measurements, []configs.Measurement{{Name: "Humidity", Unit: "%"}, {Name: "Temperature", Unit: "°C"}})
+
+	measurement, ok := columns.FindMeasurement("temperature")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "Temperature", measurement.Name)
+	_, ok = columns.FindMeasurement("Date")
+	assert.Equal(t, false, ok)
 }
 
 func TestPingRoute(t *testing.T) {
-	app := Setup()
+	app, _ := newTestApp(t)
 	req, _ := http.NewRequest("GET", "/ping", nil)
 	res, err := app.Test(req, -1)
 
@@ -40,17 +92,76 @@ func TestPingRoute(t *testing.T) {
 	assert.Equal(t, "Pong", string(body))
 }
 
-func prepareTestDB() *gorm.DB {
-	db := server.GetDb()
-	db.Migrator().DropTable(&models.Weather{})
-	db.AutoMigrate(&models.Weather{})
-	return db
+type testOptions struct {
+	clock       utils.Clock
+	broadcaster handlers.Broadcaster
+	configure   func(conf *configs.Config)
+	columns     func(columns *configs.ColumnsConfig)
 }
 
-func TestCreateWeatherRoute(t *testing.T) {
-	app := Setup()
+type testOption func(options *testOptions)
+
+func withClock(clock utils.Clock) testOption {
+	return func(options *testOptions) { options.clock = clock }
+}
+
+func withBroadcaster(broadcaster handlers.Broadcaster) testOption {
+	return func(options *testOptions) { options.broadcaster = broadcaster }
+}
+
+func withConfig(configure func(conf *configs.Config)) testOption {
+	return func(options *testOptions) { options.configure = configure }
+}
 
+func withColumns(configure func(columns *configs.ColumnsConfig)) testOption {
+	return func(options *testOptions) { options.columns = configure }
+}
+
+var testDatabases atomic.Int64
+
+// newTestApp builds an App on its own in-memory database with migrated tables, so tests do not share any state
+// besides the WebSocket clients and metrics. Broadcasts are dropped unless a broadcaster is given.
+func newTestApp(t *testing.T, options ...testOption) (*fiber.App, *gorm.DB) {
+	t.Helper()
+	testOptions := testOptions{clock: utils.SystemClock{}, broadcaster: func(message []byte, messageType ...int) {}}
+	for _, option := range options {
+		option(&testOptions)
+	}
+
+	// the tests are written against the test environment, whichever one they are run in
+	t.Setenv("APP_ENV", "test")
+	conf, err := configs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the connections of the pool share the named database, which lives until the last one is closed
+	conf.DbConnectionString = fmt.Sprintf("sqlite://file:test-%d?mode=memory&cache=shared", testDatabases.Add(1))
+	if testOptions.configure != nil {
+		testOptions.configure(conf)
+	}
+	columns, err := configs.LoadColumns("configs/columns.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testOptions.columns != nil {
+		testOptions.columns(columns)
+	}
+
+	db, err := server.OpenDb(conf.DbConnectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.CloseDb(db) })
+	if _, err := migrations.Up(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	return app.New(conf, db, columns, testOptions.clock, testOptions.broadcaster).Fiber, db
+}
+
+func TestCreateWeatherRoute(t *testing.T) {
 	t.Run("weather creation endpoint requires a token", func(t *testing.T) {
+		app, _ := newTestApp(t)
 		req, _ := http.NewRequest("POST", "/weather", nil)
 		req.Header.Set("Content-Type", "application/json")
 		res, err := app.Test(req, -1)
@@ -63,7 +174,7 @@ func TestCreateWeatherRoute(t *testing.T) {
 	})
 
 	t.Run("weather creation endpoint fails when passing an invalid date", func(t *testing.T) {
-		prepareTestDB()
+		app, _ := newTestApp(t)
 
 		requestBody := `{"date":"invalid date!","humidity":60.98765,"temperature":25.98765}`
 		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
@@ -80,7 +191,7 @@ func TestCreateWeatherRoute(t *testing.T) {
 	})
 
 	t.Run("weather creation endpoint fails when passing a humidity that is too high", func(t *testing.T) {
-		prepareTestDB()
+		app, _ := newTestApp(t)
 
 		requestBody := `{"date":"2025-01-01","humidity":160.98765,"temperature":25.98765}`
 		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
@@ -97,7 +208,7 @@ func TestCreateWeatherRoute(t *testing.T) {
 	})
 
 	t.Run("weather creation endpoint fails when passing a humidity that is too low", func(t *testing.T) {
-		prepareTestDB()
+		app, _ := newTestApp(t)
 
 		requestBody := `{"date":"2025-01-01","humidity":-10.98765,"temperature":25.98765}`
 		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
@@ -113,8 +224,33 @@ func TestCreateWeatherRoute(t *testing.T) {
 		assert.Equal(t, "Invalid Request", string(body))
 	})
 
+	t.Run("weather creation endpoint fails when passing a date after today in the reference timezone", func(t *testing.T) {
+		// mock the clock
+		app, _ := newTestApp(t, withClock(utils.FixedClock{Time: time.Date(2024, time.June, 12, 23, 30, 0, 0, time.UTC)}))
+
+		requestBody := `{"date":"2024-06-13","humidity":60.98765,"temperature":25.98765}`
+		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", "abcdef")
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 400, res.StatusCode)
+
+		// today is accepted
+		requestBody = `{"date":"2024-06-12","humidity":60.98765,"temperature":25.98765}`
+		req, _ = http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", "abcdef")
+		res, err = app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 201, res.StatusCode)
+	})
+
 	t.Run("weather creation endpoint fails when passing insufficient inputs", func(t *testing.T) {
-		prepareTestDB()
+		app, _ := newTestApp(t)
 
 		requestBody := `{"temperature":25.98765}`
 		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
@@ -131,15 +267,11 @@ func TestCreateWeatherRoute(t *testing.T) {
 	})
 
 	t.Run("weather creation endpoint saves data to db, returns formatted record, and broadcasts a websocket message", func(t *testing.T) {
-		db := prepareTestDB()
-
 		// mock socketio.Broadcast
-		original := handlers.BroadcastFunc
 		websocketEvent := []byte{}
-		handlers.BroadcastFunc = func(event []byte, mType ...int) {
+		app, db := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
 			websocketEvent = event
-		}
-		defer func() { handlers.BroadcastFunc = original }()
+		}))
 
 		requestBody := `{"date":"2024-06-01","humidity":60.98765,"temperature":25.98765}`
 		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
@@ -156,7 +288,7 @@ func TestCreateWeatherRoute(t *testing.T) {
 		err = json.Unmarshal(body, &actual)
 		assert.Nil(t, err)
 
-		expected := services.WeatherRecordResponse{Date: "2024-06-01", Raw: services.RawWeatherRecordUnits{Humidity: 60.98765, Temperature: 25.98765}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "60.99%", Temperature: "25.99°C"}}
+		expected := services.WeatherRecordResponse{Date: "2024-06-01", Raw: services.RawWeatherRecordUnits{Humidity: 60.98765, Temperature: 25.98765}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "60.99%", Temperature: "25.99°C"}, Quality: "raw"}
 		assert.Equal(t, expected, actual)
 
 		// Validate record exists in the database
@@ -176,11 +308,633 @@ func TestCreateWeatherRoute(t *testing.T) {
 	})
 }
 
-func TestGetWeatherRecordForSingleDayRoute(t *testing.T) {
-	app := Setup()
+// write sends an authorized JSON request, with the given additional headers
+func write(t *testing.T, app *fiber.App, method string, url string, body string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Token", "abcdef")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseBody, _ := io.ReadAll(res.Body)
+	return res, string(responseBody)
+}
+
+func TestWriteRoutes(t *testing.T) {
+	t.Run("rejects a second record of a date with 409", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		res, _ := write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		assert.Equal(t, 201, res.StatusCode)
+		res, body := write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":50,"temperature":20}`, nil)
+		assert.Equal(t, 409, res.StatusCode)
+		assert.Equal(t, "Record already exists for date", body)
+
+		var count int64
+		db.Model(&models.Weather{}).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("puts a record by date, creating it first", func(t *testing.T) {
+		var events []string
+		app, db := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			events = append(events, string(event))
+		}))
+
+		res, _ := write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, nil)
+		assert.Equal(t, 201, res.StatusCode)
+		res, body := write(t, app, "PUT", "/weather/2024-06-01", `{"date":"2024-06-01","humidity":40,"temperature":30}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+
+		var actual services.WeatherRecordResponse
+		json.Unmarshal([]byte(body), &actual)
+		assert.Equal(t, services.RawWeatherRecordUnits{Humidity: 40, Temperature: 30}, actual.Raw)
+		assert.Equal(t, 2, len(events))
+
+		var records []models.Weather
+		db.Find(&records)
+		assert.Equal(t, 1, len(records))
+		assert.Equal(t, 30.0, records[0].Temperature)
+	})
+
+	t.Run("rejects a put whose body has another date", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		res, _ := write(t, app, "PUT", "/weather/2024-06-01", `{"date":"2024-06-02","humidity":40,"temperature":30}`, nil)
+		assert.Equal(t, 400, res.StatusCode)
+		res, _ = write(t, app, "PUT", "/weather/yesterday", `{"humidity":40,"temperature":30}`, nil)
+		assert.Equal(t, 400, res.StatusCode)
+	})
+
+	t.Run("creates a batch of records and reports conflicts by default", func(t *testing.T) {
+		var events []string
+		app, db := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			events = append(events, string(event))
+		}))
+		write(t, app, "POST", "/weather", `{"date":"2024-06-02","humidity":60,"temperature":25}`, nil)
+		events = nil
+
+		batch := `[
+			{"date":"2024-06-01","humidity":60,"temperature":25},
+			{"date":"2024-06-02","humidity":50,"temperature":20},
+			{"date":"2024-06-03","humidity":40,"temperature":15},
+			{"date":"2024-06-03","humidity":30,"temperature":10}
+		]`
+		res, body := write(t, app, "POST", "/weather/batch", batch, nil)
+		assert.Equal(t, 200, res.StatusCode)
+
+		var actual services.BatchResponse
+		assert.Nil(t, json.Unmarshal([]byte(body), &actual))
+		assert.Equal(t, 2, actual.Inserted)
+		assert.Equal(t, 2, actual.Conflicts)
+		var outcomes []string
+		for _, result := range actual.Results {
+			outcomes = append(outcomes, result.Date+" "+result.Outcome)
+		}
+		assert.Equal(t, []string{"2024-06-01 inserted", "2024-06-02 conflict", "2024-06-03 inserted", "2024-06-03 conflict"}, outcomes)
+		assert.Equal(t, 2, len(events))
+
+		var count int64
+		db.Model(&models.Weather{}).Count(&count)
+		assert.Equal(t, int64(3), count)
+	})
+
+	t.Run("resolves conflicts by the on_conflict parameter", func(t *testing.T) {
+		var events []string
+		app, db := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			events = append(events, string(event))
+		}))
+
+		res, _ := write(t, app, "POST", "/weather?on_conflict=skip", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		assert.Equal(t, 201, res.StatusCode)
+		assert.Equal(t, "inserted", res.Header.Get("X-Write-Outcome"))
+
+		res, body := write(t, app, "POST", "/weather?on_conflict=skip", `{"date":"2024-06-01","humidity":50,"temperature":20}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "unchanged", res.Header.Get("X-Write-Outcome"))
+		var actual services.WeatherRecordResponse
+		json.Unmarshal([]byte(body), &actual)
+		assert.Equal(t, services.RawWeatherRecordUnits{Humidity: 60, Temperature: 25}, actual.Raw)
+
+		res, _ = write(t, app, "POST", "/weather?on_conflict=overwrite", `{"date":"2024-06-01","humidity":50,"temperature":20}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "updated", res.Header.Get("X-Write-Outcome"))
+		res, _ = write(t, app, "POST", "/weather?on_conflict=overwrite", `{"date":"2024-06-01","humidity":50,"temperature":20}`, nil)
+		assert.Equal(t, "unchanged", res.Header.Get("X-Write-Outcome"))
+
+		// the stored record was last updated now, older values are kept
+		res, _ = write(t, app, "POST", "/weather?on_conflict=keep_newer", `{"date":"2024-06-01","humidity":40,"temperature":15,"updated_at":"2024-06-02T08:00:00Z"}`, nil)
+		assert.Equal(t, "unchanged", res.Header.Get("X-Write-Outcome"))
+		res, _ = write(t, app, "POST", "/weather?on_conflict=keep_newer", `{"date":"2024-06-01","humidity":40,"temperature":15}`, nil)
+		assert.Equal(t, "updated", res.Header.Get("X-Write-Outcome"))
+
+		res, body = write(t, app, "POST", "/weather?on_conflict=replace", `{"date":"2024-06-01","humidity":40,"temperature":15}`, nil)
+		assert.Equal(t, 400, res.StatusCode)
+		assert.Equal(t, "Invalid on_conflict, expected error, skip, overwrite or keep_newer", body)
+
+		// only the insert and the two updates are broadcast
+		assert.Equal(t, 3, len(events))
+		var records []models.Weather
+		db.Find(&records)
+		assert.Equal(t, 1, len(records))
+		assert.Equal(t, 15.0, records[0].Temperature)
+	})
+
+	t.Run("resolves conflicts of a batch by the on_conflict parameter", func(t *testing.T) {
+		var events []string
+		app, _ := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			events = append(events, string(event))
+		}))
+		write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-02","humidity":60,"temperature":25}`, nil)
+		events = nil
+
+		batch := `[
+			{"date":"2024-06-01","humidity":60,"temperature":25},
+			{"date":"2024-06-02","humidity":50,"temperature":20},
+			{"date":"2024-06-03","humidity":40,"temperature":15}
+		]`
+		res, body := write(t, app, "POST", "/weather/batch?on_conflict=overwrite", batch, nil)
+		assert.Equal(t, 200, res.StatusCode)
+
+		var actual services.BatchResponse
+		assert.Nil(t, json.Unmarshal([]byte(body), &actual))
+		assert.Equal(t, 1, actual.Inserted)
+		assert.Equal(t, 1, actual.Updated)
+		assert.Equal(t, 1, actual.Unchanged)
+		var outcomes []string
+		for _, result := range actual.Results {
+			outcomes = append(outcomes, result.Date+" "+result.Outcome)
+		}
+		assert.Equal(t, []string{"2024-06-01 unchanged", "2024-06-02 updated", "2024-06-03 inserted"}, outcomes)
+		assert.Equal(t, 2, len(events))
+
+		res, _ = write(t, app, "POST", "/weather/batch?on_conflict=newest", batch, nil)
+		assert.Equal(t, 400, res.StatusCode)
+	})
+
+	t.Run("rejects a batch with an invalid record", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		res, body := write(t, app, "POST", "/weather/batch", `[{"date":"2024-06-01","humidity":60,"temperature":25},{"date":"2024-06-02","humidity":160,"temperature":25}]`, nil)
+		assert.Equal(t, 400, res.StatusCode)
+		assert.Equal(t, "Invalid record at index 1", body)
+		res, _ = write(t, app, "POST", "/weather/batch", `[]`, nil)
+		assert.Equal(t, 400, res.StatusCode)
+
+		var count int64
+		db.Model(&models.Weather{}).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
+
+func TestIdempotency(t *testing.T) {
+	t.Run("replays the first response to retries", func(t *testing.T) {
+		app, db := newTestApp(t)
+		key := map[string]string{"Idempotency-Key": "6f1c2c1e-0b6a-4d0e-9a57-8f3b2d3b9c11"}
+
+		first, firstBody := write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, key)
+		assert.Equal(t, 201, first.StatusCode)
+		assert.Equal(t, "", first.Header.Get("Idempotent-Replayed"))
+
+		retry, retryBody := write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, key)
+		assert.Equal(t, 201, retry.StatusCode)
+		assert.Equal(t, "true", retry.Header.Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Header.Get("Content-Type"), retry.Header.Get("Content-Type"))
+		assert.Equal(t, firstBody, retryBody)
+
+		var count int64
+		db.Model(&models.Weather{}).Count(&count)
+		assert.Equal(t, int64(1), count)
+
+		// without the key the retry is a conflict
+		res, _ := write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		assert.Equal(t, 409, res.StatusCode)
+	})
+
+	t.Run("asks retries of a request in progress to wait", func(t *testing.T) {
+		// the broadcast of the first request holds it until the retry was answered
+		broadcasting := make(chan struct{}, 1)
+		release := make(chan struct{})
+		app, _ := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			select {
+			case broadcasting <- struct{}{}:
+				<-release
+			default:
+			}
+		}))
+		body := `{"date":"2024-06-01","humidity":60,"temperature":25}`
+		key := map[string]string{"Idempotency-Key": "slow"}
+
+		first := make(chan int)
+		go func() {
+			req, _ := http.NewRequest("POST", "/weather", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Api-Token", "abcdef")
+			req.Header.Set("Idempotency-Key", "slow")
+			res, err := app.Test(req, -1)
+			if err != nil {
+				first <- 0
+				return
+			}
+			first <- res.StatusCode
+		}()
+		<-broadcasting
+
+		res, _ := write(t, app, "POST", "/weather", body, key)
+		assert.Equal(t, 409, res.StatusCode)
+		assert.Equal(t, "1", res.Header.Get("Retry-After"))
+		close(release)
+		assert.Equal(t, 201, <-first)
+
+		res, _ = write(t, app, "POST", "/weather", body, key)
+		assert.Equal(t, 201, res.StatusCode)
+		assert.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+		// conflicts with a stored record are final
+		res, _ = write(t, app, "POST", "/weather", body, nil)
+		assert.Equal(t, 409, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Retry-After"))
+	})
+
+	t.Run("rejects a key reused for a different request", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		key := map[string]string{"Idempotency-Key": "batch-1"}
+
+		res, _ := write(t, app, "POST", "/weather/batch", `[{"date":"2024-06-01","humidity":60,"temperature":25}]`, key)
+		assert.Equal(t, 200, res.StatusCode)
+		res, _ = write(t, app, "POST", "/weather/batch", `[{"date":"2024-06-02","humidity":60,"temperature":25}]`, key)
+		assert.Equal(t, 422, res.StatusCode)
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `[{"date":"2024-06-01","humidity":60,"temperature":25}]`, key)
+		assert.Equal(t, 422, res.StatusCode)
+		res, _ = write(t, app, "POST", "/weather/batch?on_conflict=overwrite", `[{"date":"2024-06-01","humidity":60,"temperature":25}]`, key)
+		assert.Equal(t, 422, res.StatusCode)
+	})
+
+	t.Run("stores client errors, but not unauthorized requests", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		invalid := map[string]string{"Idempotency-Key": "invalid"}
+		res, _ := write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":160,"temperature":25}`, invalid)
+		assert.Equal(t, 400, res.StatusCode)
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":160,"temperature":25}`, invalid)
+		assert.Equal(t, 400, res.StatusCode)
+		assert.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+
+		unauthorized := map[string]string{"Idempotency-Key": "unauthorized", "X-Api-Token": "wrong"}
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, unauthorized)
+		assert.Equal(t, 401, res.StatusCode)
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, map[string]string{"Idempotency-Key": "unauthorized"})
+		assert.Equal(t, 201, res.StatusCode)
+	})
+
+	t.Run("scopes keys to the token", func(t *testing.T) {
+		app, db := newTestApp(t)
+		conf, _ := configs.Load()
+		service := services.New(db, repository.NewGorm(db), conf, nil, utils.SystemClock{})
+		_, secret, err := service.CreateToken(context.Background(), "ingestion")
+		assert.Nil(t, err)
+
+		res, _ := write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, map[string]string{"Idempotency-Key": "shared"})
+		assert.Equal(t, 201, res.StatusCode)
+		// the same key of another token is a request of its own, which is not answered with the response to the first token
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, map[string]string{"Idempotency-Key": "shared", "X-Api-Token": secret})
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Idempotent-Replayed"))
+		res, _ = write(t, app, "PUT", "/weather/2024-06-02", `{"humidity":60,"temperature":25}`, map[string]string{"Idempotency-Key": "shared", "X-Api-Token": secret})
+		assert.Equal(t, 422, res.StatusCode)
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, map[string]string{"Idempotency-Key": "shared"})
+		assert.Equal(t, 201, res.StatusCode)
+		assert.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+	})
+
+	t.Run("frees the keys of requests that never completed after their lease", func(t *testing.T) {
+		_, db := newTestApp(t)
+		conf, _ := configs.Load()
+		service := services.New(db, repository.NewGorm(db), conf, nil, utils.SystemClock{})
+		ctx := context.Background()
+		claimedAt := time.Date(2024, time.June, 12, 12, 0, 0, 0, time.UTC)
+
+		stored, err := service.BeginIdempotentRequest(ctx, "api_token", "abandoned", "request", claimedAt, time.Minute)
+		assert.Nil(t, err)
+		assert.Nil(t, stored)
+		_, err = service.BeginIdempotentRequest(ctx, "api_token", "abandoned", "request", claimedAt.Add(59*time.Second), time.Minute)
+		assert.True(t, errors.Is(err, services.ErrIdempotencyKeyInProgress))
+
+		// the request that claimed the key first no longer holds it, and does not store its response
+		reclaimedAt := claimedAt.Add(time.Minute)
+		stored, err = service.BeginIdempotentRequest(ctx, "api_token", "abandoned", "request", reclaimedAt, time.Minute)
+		assert.Nil(t, err)
+		assert.Nil(t, stored)
+		assert.Nil(t, service.CompleteIdempotentRequest(ctx, "api_token", "abandoned", claimedAt, services.StoredResponse{Status: 500}, reclaimedAt))
+		assert.Nil(t, service.CompleteIdempotentRequest(ctx, "api_token", "abandoned", reclaimedAt, services.StoredResponse{Status: 201}, reclaimedAt))
+
+		// a completed request is kept for the TTL, not its lease
+		stored, err = service.BeginIdempotentRequest(ctx, "api_token", "abandoned", "request", reclaimedAt.Add(time.Hour), time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, 201, stored.Status)
+	})
+
+	t.Run("handles requests again once their key expired", func(t *testing.T) {
+		clock := &testClock{now: time.Date(2024, time.June, 12, 12, 0, 0, 0, time.UTC)}
+		app, _ := newTestApp(t, withClock(clock), withConfig(func(conf *configs.Config) {
+			conf.IdempotencyTTL = time.Hour
+		}))
+		key := map[string]string{"Idempotency-Key": "expiring"}
+
+		res, _ := write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, key)
+		assert.Equal(t, 201, res.StatusCode)
+		clock.now = clock.now.Add(59 * time.Minute)
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, key)
+		assert.Equal(t, 201, res.StatusCode)
+
+		clock.now = clock.now.Add(time.Minute)
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25}`, key)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "", res.Header.Get("Idempotent-Replayed"))
+	})
+}
+
+// testClock is a clock the test can move forward
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestHistory(t *testing.T) {
+	get := func(t *testing.T, app *fiber.App, path string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", path, nil)
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	t.Run("lists the changes of a date with their author", func(t *testing.T) {
+		app, db := newTestApp(t)
+		conf, _ := configs.Load()
+		_, secret, err := services.New(db, repository.NewGorm(db), conf, nil, utils.SystemClock{}).CreateToken(context.Background(), "steward")
+		assert.Nil(t, err)
+
+		write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":50,"temperature":25}`, map[string]string{"X-Api-Token": secret})
+		res, _ := write(t, app, "DELETE", "/weather/2024-06-01", "", nil)
+		assert.Equal(t, 204, res.StatusCode)
+
+		res, body := get(t, app, "/weather/2024-06-01/history")
+		assert.Equal(t, 200, res.StatusCode)
+		var history []services.RevisionResponse
+		assert.Nil(t, json.Unmarshal([]byte(body), &history))
+		assert.Equal(t, 3, len(history))
+
+		assert.Equal(t, "create", history[0].Operation)
+		assert.Nil(t, history[0].Before)
+		assert.Equal(t, &services.RawWeatherRecordUnits{Humidity: 60, Temperature: 25}, history[0].After)
+		assert.Equal(t, "api_token", history[0].Actor)
+		assert.Equal(t, "api", history[0].Source)
+
+		assert.Equal(t, "update", history[1].Operation)
+		assert.Equal(t, 60.0, history[1].Before.Humidity)
+		assert.Equal(t, 50.0, history[1].After.Humidity)
+		assert.Regexp(t, `^token:\d+$`, history[1].Actor)
+
+		assert.Equal(t, "delete", history[2].Operation)
+		assert.Nil(t, history[2].After)
+		assert.False(t, history[2].ChangedAt.Before(history[0].ChangedAt))
+
+		res, body = get(t, app, "/weather/2024-06-02/history")
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "[]", body)
+		res, _ = get(t, app, "/weather/yesterday/history")
+		assert.Equal(t, 400, res.StatusCode)
+	})
+
+	t.Run("deletes the record of a date", func(t *testing.T) {
+		app, db := newTestApp(t)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+
+		res, _ := write(t, app, "DELETE", "/weather/2024-06-01", "", map[string]string{"X-Api-Token": "wrong"})
+		assert.Equal(t, 401, res.StatusCode)
+		res, _ = write(t, app, "DELETE", "/weather/2024-06-01", "", nil)
+		assert.Equal(t, 204, res.StatusCode)
+		res, _ = write(t, app, "DELETE", "/weather/2024-06-01", "", nil)
+		assert.Equal(t, 404, res.StatusCode)
+
+		_, body := get(t, app, "/weather/2024-06-01")
+		var records []services.WeatherRecordResponse
+		json.Unmarshal([]byte(body), &records)
+		assert.Empty(t, records)
+		var count int64
+		db.Model(&models.Weather{}).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		// the date can be created again
+		res, _ = write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":50,"temperature":20}`, nil)
+		assert.Equal(t, 201, res.StatusCode)
+	})
+
+	t.Run("reconstructs records as of a time", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-02","humidity":55,"temperature":22}`, nil)
+		// the pauses separate the created records from the changes after them
+		time.Sleep(10 * time.Millisecond)
+		created := time.Now().UTC().Format(time.RFC3339Nano)
+		time.Sleep(10 * time.Millisecond)
+		write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":40,"temperature":25}`, nil)
+		write(t, app, "DELETE", "/weather/2024-06-02", "", nil)
+
+		humidities := func(body string) []float64 {
+			var records []services.WeatherRecordResponse
+			assert.Nil(t, json.Unmarshal([]byte(body), &records))
+			values := []float64{}
+			for _, record := range records {
+				values = append(values, record.Raw.Humidity)
+			}
+			return values
+		}
+		_, body := get(t, app, "/weather/2024-06-01/2024-06-02?as_of="+url.QueryEscape(created))
+		assert.Equal(t, []float64{60, 55}, humidities(body))
+		_, body = get(t, app, "/weather/2024-06-01?as_of="+url.QueryEscape(created))
+		assert.Equal(t, []float64{60}, humidities(body))
+		_, body = get(t, app, "/weather/2024-06-01/2024-06-02")
+		assert.Equal(t, []float64{40}, humidities(body))
+		_, body = get(t, app, "/weather/2024-06-01/2024-06-02?as_of=2024-01-01T00:00:00Z")
+		assert.Equal(t, []float64{}, humidities(body))
+
+		res, _ := get(t, app, "/weather/2024-06-01?as_of=yesterday")
+		assert.Equal(t, 400, res.StatusCode)
+	})
+}
+
+func TestQuality(t *testing.T) {
+	get := func(t *testing.T, app *fiber.App, path string) (*http.Response, []services.WeatherRecordResponse) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", path, nil)
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []services.WeatherRecordResponse
+		body, _ := io.ReadAll(res.Body)
+		json.Unmarshal(body, &records)
+		return res, records
+	}
+	review := func(t *testing.T, app *fiber.App, date string, quality string) (*http.Response, string) {
+		t.Helper()
+		return write(t, app, "POST", "/weather/"+date+"/review", fmt.Sprintf(`{"quality":%q}`, quality), nil)
+	}
+
+	t.Run("stores the provenance and quality of written records", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		res, body := write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25,"source":"station","batch_id":"b1","instrument":"hygrometer","quality":"estimated"}`, nil)
+		assert.Equal(t, 201, res.StatusCode)
+		var saved services.WeatherRecordResponse
+		assert.Nil(t, json.Unmarshal([]byte(body), &saved))
+		assert.Equal(t, "estimated", saved.Quality)
+		assert.Equal(t, "station", saved.Source)
+		assert.Equal(t, "b1", saved.BatchID)
+		assert.Equal(t, "hygrometer", saved.Instrument)
+
+		res, _ = write(t, app, "POST", "/weather", `{"date":"2024-06-02","humidity":60,"temperature":25,"quality":"unknown"}`, nil)
+		assert.Equal(t, 400, res.StatusCode)
+		// only reviews validate records
+		res, _ = write(t, app, "PUT", "/weather/2024-06-02", `{"humidity":60,"temperature":25,"quality":"validated"}`, nil)
+		assert.Equal(t, 400, res.StatusCode)
+
+		// writes keep the provenance they do not set, new values are raw unless the write sets a quality
+		res, body = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":62,"temperature":25}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Nil(t, json.Unmarshal([]byte(body), &saved))
+		assert.Equal(t, "raw", saved.Quality)
+		assert.Equal(t, "station", saved.Source)
+		assert.Equal(t, "hygrometer", saved.Instrument)
+
+		// records of a batch without their own batch id share one
+		res, body = write(t, app, "POST", "/weather/batch", `[{"date":"2024-06-02","humidity":60,"temperature":25},{"date":"2024-06-03","humidity":60,"temperature":25}]`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		var batch services.BatchResponse
+		assert.Nil(t, json.Unmarshal([]byte(body), &batch))
+		assert.NotEmpty(t, batch.BatchID)
+		var records []models.Weather
+		db.Order("recorded_at").Find(&records)
+		assert.Equal(t, []string{"b1", batch.BatchID, batch.BatchID}, []string{records[0].BatchID, records[1].BatchID, records[2].BatchID})
+		assert.Equal(t, "raw", records[1].Quality)
+	})
+
+	t.Run("does not carry the reviewed quality over to new values", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		review(t, app, "2024-06-01", "validated")
+
+		// the same values keep their quality, whatever the write sets
+		res, body := write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":60,"temperature":25,"quality":"estimated"}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		var saved services.WeatherRecordResponse
+		assert.Nil(t, json.Unmarshal([]byte(body), &saved))
+		assert.Equal(t, "validated", saved.Quality)
+
+		res, body = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":65,"temperature":25}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Nil(t, json.Unmarshal([]byte(body), &saved))
+		assert.Equal(t, "raw", saved.Quality)
+
+		review(t, app, "2024-06-01", "validated")
+		res, body = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":70,"temperature":25,"quality":"estimated"}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Nil(t, json.Unmarshal([]byte(body), &saved))
+		assert.Equal(t, "estimated", saved.Quality)
+
+		_, records := get(t, app, "/weather/2024-06-01?quality=validated")
+		assert.Empty(t, records)
+	})
+
+	t.Run("filters reads by quality", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		review(t, app, "2024-06-01", "validated")
+		write(t, app, "POST", "/weather", `{"date":"2024-06-02","humidity":55,"temperature":22}`, nil)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-03","humidity":50,"temperature":20,"quality":"estimated"}`, nil)
+
+		dates := func(records []services.WeatherRecordResponse) []string {
+			dates := []string{}
+			for _, record := range records {
+				dates = append(dates, record.Date)
+			}
+			return dates
+		}
+		_, records := get(t, app, "/weather/2024-06-01/2024-06-03?quality=validated")
+		assert.Equal(t, []string{"2024-06-01"}, dates(records))
+		_, records = get(t, app, "/weather/2024-06-01/2024-06-03?quality=raw,estimated")
+		assert.Equal(t, []string{"2024-06-02", "2024-06-03"}, dates(records))
+		_, records = get(t, app, "/weather/2024-06-02?quality=validated")
+		assert.Empty(t, records)
+		_, records = get(t, app, "/weather/2024-06-02?quality=raw")
+		assert.Equal(t, []string{"2024-06-02"}, dates(records))
+
+		res, _ := get(t, app, "/weather/2024-06-01/2024-06-03?quality=good")
+		assert.Equal(t, 400, res.StatusCode)
+	})
+
+	t.Run("moves records between quality states by review", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+
+		res, body := review(t, app, "2024-06-01", "suspect")
+		assert.Equal(t, 200, res.StatusCode)
+		var reviewed services.WeatherRecordResponse
+		assert.Nil(t, json.Unmarshal([]byte(body), &reviewed))
+		assert.Equal(t, "suspect", reviewed.Quality)
+		assert.Equal(t, 60.0, reviewed.Raw.Humidity)
+
+		res, _ = review(t, app, "2024-06-01", "corrected")
+		assert.Equal(t, 200, res.StatusCode)
+		res, _ = review(t, app, "2024-06-01", "validated")
+		assert.Equal(t, 200, res.StatusCode)
+		// validated records have to be flagged as suspect before they can be replaced
+		res, _ = review(t, app, "2024-06-01", "estimated")
+		assert.Equal(t, 422, res.StatusCode)
+		res, _ = review(t, app, "2024-06-01", "validated")
+		assert.Equal(t, 422, res.StatusCode)
+
+		res, _ = review(t, app, "2024-06-01", "good")
+		assert.Equal(t, 400, res.StatusCode)
+		res, _ = review(t, app, "2024-06-02", "validated")
+		assert.Equal(t, 404, res.StatusCode)
+		res, _ = write(t, app, "POST", "/weather/2024-06-01/review", `{"quality":"suspect"}`, map[string]string{"X-Api-Token": "wrong"})
+		assert.Equal(t, 401, res.StatusCode)
+
+		_, records := get(t, app, "/weather/2024-06-01?quality=validated")
+		assert.Equal(t, 1, len(records))
+
+		req, _ := http.NewRequest("GET", "/weather/2024-06-01/history", nil)
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		var history []services.RevisionResponse
+		historyBody, _ := io.ReadAll(res.Body)
+		assert.Nil(t, json.Unmarshal(historyBody, &history))
+		assert.Equal(t, 4, len(history))
+		assert.Equal(t, "raw", *history[1].BeforeQuality)
+		assert.Equal(t, "suspect", *history[1].AfterQuality)
+		assert.Equal(t, "admin", history[1].Source)
+		assert.Equal(t, "api_token", history[1].Actor)
+	})
+}
 
+func TestGetWeatherRecordForSingleDayRoute(t *testing.T) {
 	t.Run("fails when passing an invalid date", func(t *testing.T) {
-		prepareTestDB()
+		app, _ := newTestApp(t)
 
 		req, _ := http.NewRequest("GET", "/weather/2025-0101T00:00:00", nil)
 		req.Header.Set("Content-Type", "application/json")
@@ -195,7 +949,7 @@ func TestGetWeatherRecordForSingleDayRoute(t *testing.T) {
 	})
 
 	t.Run("returns all records for the given day", func(t *testing.T) {
-		db := prepareTestDB()
+		app, db := newTestApp(t)
 
 		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60.98765, Temperature: 25.98765})
 		db.Create(&models.Weather{RecordedAt: "2025-01-02", Humidity: 60.98765, Temperature: 25.98765})
@@ -218,13 +972,60 @@ func TestGetWeatherRecordForSingleDayRoute(t *testing.T) {
 		}
 		assert.Equal(t, expected, actual)
 	})
+
+	t.Run("resolves calendar shortcuts to a range and echoes it in the headers", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		db.Create(&models.Weather{RecordedAt: "2024-06-02", Humidity: 60, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2024-06-03", Humidity: 60, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2024-06-30", Humidity: 60, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2024-07-01", Humidity: 60, Temperature: 20})
+
+		cases := map[string][]string{
+			"2024-06":  {"2024-06-01", "2024-06-30", "2024-06-02", "2024-06-03", "2024-06-30"},
+			"2024-W23": {"2024-06-03", "2024-06-09", "2024-06-03"},
+			"2024":     {"2024-01-01", "2024-12-31", "2024-06-02", "2024-06-03", "2024-06-30", "2024-07-01"},
+		}
+		for token, expected := range cases {
+			req, _ := http.NewRequest("GET", "/weather/"+token, nil)
+			res, err := app.Test(req, -1)
+
+			// Validate response
+			assert.Nil(t, err)
+			assert.Equal(t, 200, res.StatusCode, token)
+			assert.Equal(t, expected[0], res.Header.Get("X-Range-From"), token)
+			assert.Equal(t, expected[1], res.Header.Get("X-Range-To"), token)
+			body, _ := io.ReadAll(res.Body)
+
+			var actual []services.WeatherRecordResponse
+			err = json.Unmarshal(body, &actual)
+			assert.Nil(t, err)
+
+			var dates []string
+			for _, record := range actual {
+				dates = append(dates, record.Date)
+			}
+			assert.Equal(t, expected[2:], dates, token)
+		}
+	})
+
+	t.Run("resolves relative shortcuts against today", func(t *testing.T) {
+		// mock the clock
+		app, _ := newTestApp(t, withClock(utils.FixedClock{Time: time.Date(2024, time.June, 12, 15, 0, 0, 0, time.UTC)}))
+
+		req, _ := http.NewRequest("GET", "/weather/last-7-days", nil)
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "2024-06-06", res.Header.Get("X-Range-From"))
+		assert.Equal(t, "2024-06-12", res.Header.Get("X-Range-To"))
+	})
 }
 
 func TestGetWeatherRecordForRangeRoute(t *testing.T) {
-	app := Setup()
-
 	t.Run("fails when passing an invalid date", func(t *testing.T) {
-		prepareTestDB()
+		app, _ := newTestApp(t)
 
 		req, _ := http.NewRequest("GET", "/weather/2025-01-01T00:00:00/2025-01-03T00:00:00", nil)
 		req.Header.Set("Content-Type", "application/json")
@@ -239,7 +1040,7 @@ func TestGetWeatherRecordForRangeRoute(t *testing.T) {
 	})
 
 	t.Run("returns all records for the given day", func(t *testing.T) {
-		db := prepareTestDB()
+		app, db := newTestApp(t)
 
 		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60.98765, Temperature: 25.98765})
 		db.Create(&models.Weather{RecordedAt: "2025-01-02", Humidity: 60.98765, Temperature: 25.98765})
@@ -267,4 +1068,1399 @@ func TestGetWeatherRecordForRangeRoute(t *testing.T) {
 		}
 		assert.Equal(t, expected, actual)
 	})
+
+	t.Run("accepts calendar shortcuts as bounds", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		db.Create(&models.Weather{RecordedAt: "2024-12-31", Humidity: 60, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2025-01-15", Humidity: 60, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2025-02-28", Humidity: 60, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2025-03-01", Humidity: 60, Temperature: 20})
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01/2025-02", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "2025-01-01", res.Header.Get("X-Range-From"))
+		assert.Equal(t, "2025-02-28", res.Header.Get("X-Range-To"))
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(actual))
+	})
+
+	t.Run("fills missing days using linear interpolation", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+		db.Create(&models.Weather{RecordedAt: "2025-01-04", Humidity: 80, Temperature: 16})
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01-01/2025-01-05?fill=linear", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		expected := []services.WeatherRecordResponse{
+			{Date: "2025-01-01", Raw: services.RawWeatherRecordUnits{Humidity: 50, Temperature: 10}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "50.00%", Temperature: "10.00°C"}},
+			{Date: "2025-01-02", Raw: services.RawWeatherRecordUnits{Humidity: 60, Temperature: 12}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "60.00%", Temperature: "12.00°C"}, Synthetic: true},
+			{Date: "2025-01-03", Raw: services.RawWeatherRecordUnits{Humidity: 70, Temperature: 14}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "70.00%", Temperature: "14.00°C"}, Synthetic: true},
+			{Date: "2025-01-04", Raw: services.RawWeatherRecordUnits{Humidity: 80, Temperature: 16}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "80.00%", Temperature: "16.00°C"}},
+			// there is no later reading, so the last one is carried to the end of the range
+			{Date: "2025-01-05", Raw: services.RawWeatherRecordUnits{Humidity: 80, Temperature: 16}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "80.00%", Temperature: "16.00°C"}, Synthetic: true},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("fills the edges of the range from the readings next to it", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		db.Create(&models.Weather{RecordedAt: "2024-12-30", Humidity: 40, Temperature: 8})
+		db.Create(&models.Weather{RecordedAt: "2025-01-02", Humidity: 70, Temperature: 14})
+		db.Create(&models.Weather{RecordedAt: "2025-01-06", Humidity: 50, Temperature: 6})
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01-01/2025-01-04?fill=linear", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		var dates []string
+		var humidities []float64
+		for _, record := range actual {
+			dates = append(dates, record.Date)
+			humidities = append(humidities, record.Raw.Humidity)
+		}
+		assert.Equal(t, []string{"2025-01-01", "2025-01-02", "2025-01-03", "2025-01-04"}, dates)
+		assert.Equal(t, []float64{60, 70, 65, 60}, humidities)
+		assert.True(t, actual[0].Synthetic)
+		assert.True(t, actual[3].Synthetic)
+	})
+
+	t.Run("fills a single missing day", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+		db.Create(&models.Weather{RecordedAt: "2025-01-03", Humidity: 70, Temperature: 14})
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01-02?fill=linear", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.Equal(t, []services.WeatherRecordResponse{
+			{Date: "2025-01-02", Raw: services.RawWeatherRecordUnits{Humidity: 60, Temperature: 12}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "60.00%", Temperature: "12.00°C"}, Synthetic: true},
+		}, actual)
+	})
+
+	t.Run("fills missing days by carrying the previous observation forward", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+		db.Create(&models.Weather{RecordedAt: "2025-01-03", Humidity: 80, Temperature: 16})
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01-01/2025-01-03?fill=previous", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 3, len(actual))
+		assert.Equal(t, services.WeatherRecordResponse{Date: "2025-01-02", Raw: services.RawWeatherRecordUnits{Humidity: 50, Temperature: 10}, Formatted: services.FormattedWeatherRecordUnits{Humidity: "50.00%", Temperature: "10.00°C"}, Synthetic: true}, actual[1])
+	})
+
+	t.Run("refuses to fill gaps larger than the configured maximum", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+		db.Create(&models.Weather{RecordedAt: "2025-02-01", Humidity: 80, Temperature: 16})
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01-01/2025-02-01?fill=linear", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 422, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, "Gap too large to fill", string(body))
+	})
+
+	t.Run("fails when passing an unknown fill method", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01-01/2025-01-03?fill=cubic", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 400, res.StatusCode)
+	})
+}
+
+// seedStableHistory creates two weeks of records with little variation, ending the day before 2025-01-15
+func seedStableHistory(db *gorm.DB) {
+	for day := 1; day <= 14; day++ {
+		db.Create(&models.Weather{
+			RecordedAt:  fmt.Sprintf("2025-01-%02d", day),
+			Humidity:    50 + float64(day%4),
+			Temperature: 10 + float64(day%3),
+		})
+	}
+}
+
+func TestAnomalyDetection(t *testing.T) {
+	t.Run("flags, stores and broadcasts anomalous records on creation", func(t *testing.T) {
+		// mock socketio.Broadcast
+		websocketEvents := [][]byte{}
+		app, db := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			websocketEvents = append(websocketEvents, event)
+		}))
+		seedStableHistory(db)
+
+		requestBody := `{"date":"2025-01-15","humidity":51,"temperature":40}`
+		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", "abcdef")
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 201, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"temperature"}, actual.AnomalyFields)
+
+		// Validate the flag is stored and returned on reads
+		var weatherRecords []models.Weather
+		db.Where("recorded_at = ?", "2025-01-15").Find(&weatherRecords)
+		assert.Equal(t, true, weatherRecords[0].Anomaly)
+		assert.Equal(t, "temperature", weatherRecords[0].AnomalyFields)
+
+		req, _ = http.NewRequest("GET", "/weather/2025-01-15", nil)
+		res, _ = app.Test(req, -1)
+		body, _ = io.ReadAll(res.Body)
+		var read []services.WeatherRecordResponse
+		json.Unmarshal(body, &read)
+		assert.Equal(t, []string{"temperature"}, read[0].AnomalyFields)
+
+		// validate websocket messages: the record itself, followed by the anomaly event
+		assert.Equal(t, 2, len(websocketEvents))
+		var anomalyEvent handlers.AnomalyEvent
+		err = json.Unmarshal(websocketEvents[1], &anomalyEvent)
+		assert.Nil(t, err)
+		assert.Equal(t, "anomaly", anomalyEvent.Event)
+		assert.Equal(t, actual, anomalyEvent.Record)
+	})
+
+	t.Run("does not flag records without enough history", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		requestBody := `{"date":"2025-01-15","humidity":51,"temperature":40}`
+		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", "abcdef")
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 201, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.WeatherRecordResponse
+		json.Unmarshal(body, &actual)
+		assert.Nil(t, actual.AnomalyFields)
+	})
+
+	t.Run("scans historical data for anomalies with configurable sensitivity", func(t *testing.T) {
+		app, db := newTestApp(t)
+		seedStableHistory(db)
+		db.Create(&models.Weather{RecordedAt: "2025-01-15", Humidity: 51, Temperature: 40})
+
+		req, _ := http.NewRequest("GET", "/weather/anomalies?from=2025-01-01&to=2025-01-31&method=iqr&threshold=1.5", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.AnomalyResult
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(actual))
+		assert.Equal(t, "2025-01-15", actual[0].Record.Date)
+		assert.Equal(t, []string{"temperature"}, actual[0].Record.AnomalyFields)
+		assert.Equal(t, "temperature", actual[0].Scores[0].Field)
+		assert.Equal(t, "recent", actual[0].Scores[0].Baseline)
+
+		// a very high threshold flags nothing
+		req, _ = http.NewRequest("GET", "/weather/anomalies?from=2025-01-01&to=2025-01-31&threshold=1000", nil)
+		res, _ = app.Test(req, -1)
+		body, _ = io.ReadAll(res.Body)
+		assert.Equal(t, "[]", string(body))
+	})
+
+	t.Run("fails when passing invalid parameters", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/anomalies?from=2025-01-01",
+			"/weather/anomalies?from=2025-01-01&to=2025-01-31&method=magic",
+			"/weather/anomalies?from=2025-01-01&to=2025-01-31&threshold=-1",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+}
+
+func createWeatherRecord(app *fiber.App, requestBody string) *http.Response {
+	req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Token", "abcdef")
+	res, _ := app.Test(req, -1)
+	return res
+}
+
+func TestNormals(t *testing.T) {
+	t.Run("normals are recomputed as records are created", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		assert.Equal(t, 201, createWeatherRecord(app, `{"date":"2023-01-01","humidity":50,"temperature":10}`).StatusCode)
+		assert.Equal(t, 201, createWeatherRecord(app, `{"date":"2024-01-01","humidity":60,"temperature":14}`).StatusCode)
+
+		req, _ := http.NewRequest("GET", "/weather/normals?doy=1", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.NormalResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		expected := []services.NormalResponse{{
+			DayOfYear: 1,
+			Measurements: map[string]services.NormalUnits{
+				"humidity":    {Count: 2, Mean: 55, P10: 51, P25: 52.5, P50: 55, P75: 57.5, P90: 59},
+				"temperature": {Count: 2, Mean: 12, P10: 10.4, P25: 11, P50: 12, P75: 13, P90: 13.6},
+			},
+		}}
+		assert.Equal(t, len(expected), len(actual))
+		for field, normal := range expected[0].Measurements {
+			assert.Equal(t, normal.Count, actual[0].Measurements[field].Count)
+			assert.InDelta(t, normal.Mean, actual[0].Measurements[field].Mean, 0.0001)
+			assert.InDelta(t, normal.P10, actual[0].Measurements[field].P10, 0.0001)
+			assert.InDelta(t, normal.P90, actual[0].Measurements[field].P90, 0.0001)
+		}
+
+		// days outside of the pooling window have no normal
+		req, _ = http.NewRequest("GET", "/weather/normals?doy=180", nil)
+		res, _ = app.Test(req, -1)
+		body, _ = io.ReadAll(res.Body)
+		assert.Equal(t, "[]", string(body))
+	})
+
+	t.Run("saved records are kept when the normals fail to update", func(t *testing.T) {
+		broadcasts := 0
+		app, db := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			broadcasts++
+		}))
+		if err := db.Migrator().DropTable("weather_normals"); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 201, createWeatherRecord(app, `{"date":"2024-01-01","humidity":60,"temperature":14}`).StatusCode)
+		assert.Equal(t, 1, broadcasts)
+
+		req, _ := http.NewRequest("GET", "/weather/2024-01-01", nil)
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+	})
+
+	t.Run("range queries include the departure from normal on request", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		createWeatherRecord(app, `{"date":"2023-01-01","humidity":50,"temperature":10}`)
+		createWeatherRecord(app, `{"date":"2024-01-01","humidity":60,"temperature":14}`)
+
+		req, _ := http.NewRequest("GET", "/weather/2024-01-01/2024-01-01?with=departure", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.Equal(t, &services.RawWeatherRecordUnits{Humidity: 5, Temperature: 2}, actual[0].Departure)
+
+		// without the option, no departure is included
+		req, _ = http.NewRequest("GET", "/weather/2024-01-01/2024-01-01", nil)
+		res, _ = app.Test(req, -1)
+		body, _ = io.ReadAll(res.Body)
+		var withoutDeparture []services.WeatherRecordResponse
+		json.Unmarshal(body, &withoutDeparture)
+		assert.Nil(t, withoutDeparture[0].Departure)
+
+		// the single day route takes the option as well
+		req, _ = http.NewRequest("GET", "/weather/2024-01-01?with=departure", nil)
+		res, _ = app.Test(req, -1)
+		body, _ = io.ReadAll(res.Body)
+		var singleDay []services.WeatherRecordResponse
+		json.Unmarshal(body, &singleDay)
+		assert.Equal(t, &services.RawWeatherRecordUnits{Humidity: 5, Temperature: 2}, singleDay[0].Departure)
+	})
+
+	t.Run("incremental updates of a batch match a rebuild", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		// days around the turn of the year and February 29th, in several years
+		var records []string
+		for i, date := range []string{"2022-12-28", "2023-01-03", "2023-02-28", "2023-12-30", "2024-01-05", "2024-02-29", "2024-03-06", "2024-06-01"} {
+			records = append(records, fmt.Sprintf(`{"date":%q,"humidity":%d,"temperature":%d}`, date, 40+i*3, i*2))
+		}
+		res, _ := write(t, app, "POST", "/weather/batch", "["+strings.Join(records, ",")+"]", nil)
+		assert.Equal(t, 200, res.StatusCode)
+		write(t, app, "DELETE", "/weather/2024-01-05", "", nil)
+
+		normals := func() []models.WeatherNormal {
+			var normals []models.WeatherNormal
+			db.Order("day_of_year").Order("field").Find(&normals)
+			for i := range normals {
+				normals[i].ID, normals[i].UpdatedAt = 0, time.Time{}
+			}
+			return normals
+		}
+		incremental := normals()
+		assert.NotEmpty(t, incremental)
+
+		res, _ = write(t, app, "POST", "/weather/normals/recompute", "", nil)
+		assert.Equal(t, 204, res.StatusCode)
+		assert.Equal(t, normals(), incremental)
+	})
+
+	t.Run("normals can be rebuilt from existing records", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2023-06-01", Humidity: 50, Temperature: 20})
+
+		req, _ := http.NewRequest("POST", "/weather/normals/recompute", nil)
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 401, res.StatusCode)
+
+		req, _ = http.NewRequest("POST", "/weather/normals/recompute", nil)
+		req.Header.Set("X-Api-Token", "abcdef")
+		res, err = app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 204, res.StatusCode)
+
+		var count int64
+		db.Model(&models.WeatherNormal{}).Where("field = ?", "temperature").Count(&count)
+		assert.Equal(t, int64(15), count)
+	})
+
+	t.Run("fails when passing invalid parameters", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/normals?doy=0",
+			"/weather/normals?doy=366",
+			"/weather/normals?doy=first",
+			"/weather/2024-01-01/2024-01-02?with=everything",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+}
+
+func TestForecast(t *testing.T) {
+	// a month with a steady trend, so the expected forecast is known
+	seedTrend := func(db *gorm.DB) {
+		for day := 1; day <= 30; day++ {
+			db.Create(&models.Weather{
+				RecordedAt:  fmt.Sprintf("2025-01-%02d", day),
+				Humidity:    40 + float64(day),
+				Temperature: 5 + 0.5*float64(day),
+			})
+		}
+	}
+
+	t.Run("forecasts the days following the last record", func(t *testing.T) {
+		app, db := newTestApp(t)
+		seedTrend(db)
+
+		req, _ := http.NewRequest("GET", "/weather/forecast?days=3&model=holt", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 3, len(actual))
+		assert.Equal(t, "2025-01-31", actual[0].Date)
+		assert.Equal(t, "2025-02-02", actual[2].Date)
+		assert.Equal(t, true, actual[0].Forecast)
+		assert.InDelta(t, 20.5, actual[0].Raw.Temperature, 0.0001)
+		assert.InDelta(t, 71, actual[0].Raw.Humidity, 0.0001)
+		assert.Equal(t, "20.50°C", actual[0].Formatted.Temperature)
+		assert.NotNil(t, actual[0].Interval)
+	})
+
+	t.Run("reports error metrics over a holdout window", func(t *testing.T) {
+		app, db := newTestApp(t)
+		seedTrend(db)
+
+		req, _ := http.NewRequest("GET", "/weather/forecast?model=holt&backtest=5", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.BacktestResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 5, actual.Holdout)
+		assert.Equal(t, 5, len(actual.Forecast))
+		assert.Equal(t, "2025-01-26", actual.Forecast[0].Date)
+		assert.Equal(t, "2025-01-26", actual.Actual[0].Date)
+		assert.InDelta(t, 0, actual.Metrics["temperature"].MAE, 0.0001)
+		assert.InDelta(t, 0, actual.Metrics["humidity"].RMSE, 0.0001)
+	})
+
+	t.Run("forecasts from the records after the last gap too large to fill", func(t *testing.T) {
+		app, db := newTestApp(t)
+		// far off the trend, and separated from it by more than FILL_MAX_GAP_DAYS
+		db.Create(&models.Weather{RecordedAt: "2024-06-01", Humidity: 90, Temperature: 30})
+		seedTrend(db)
+
+		req, _ := http.NewRequest("GET", "/weather/forecast?days=1&model=holt", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual []services.WeatherRecordResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(actual))
+		assert.InDelta(t, 20.5, actual[0].Raw.Temperature, 0.0001)
+	})
+
+	t.Run("fails without enough history for the model", func(t *testing.T) {
+		app, db := newTestApp(t)
+		seedTrend(db)
+
+		req, _ := http.NewRequest("GET", "/weather/forecast?model=holt_winters", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 422, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, "Not enough history to forecast", string(body))
+	})
+
+	t.Run("fails when passing invalid parameters", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/forecast?days=0",
+			"/weather/forecast?days=1000",
+			"/weather/forecast?model=crystal_ball",
+			"/weather/forecast?season=0",
+			"/weather/forecast?backtest=-1",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+}
+
+func TestExtremes(t *testing.T) {
+	t.Run("returns the top n highs and lows per measurement", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+		db.Create(&models.Weather{RecordedAt: "2025-01-02", Humidity: 90, Temperature: -5})
+		db.Create(&models.Weather{RecordedAt: "2025-01-03", Humidity: 20, Temperature: 30})
+		// won't be included
+		db.Create(&models.Weather{RecordedAt: "2025-02-01", Humidity: 100, Temperature: 40})
+
+		req, _ := http.NewRequest("GET", "/weather/extremes?from=2025-01-01&to=2025-01-31&n=2", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual map[string]services.ExtremesResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		dates := func(records []services.WeatherRecordResponse) []string {
+			var result []string
+			for _, record := range records {
+				result = append(result, record.Date)
+			}
+			return result
+		}
+		assert.Equal(t, []string{"2025-01-03", "2025-01-01"}, dates(actual["temperature"].Highs))
+		assert.Equal(t, []string{"2025-01-02", "2025-01-01"}, dates(actual["temperature"].Lows))
+		assert.Equal(t, []string{"2025-01-02", "2025-01-01"}, dates(actual["humidity"].Highs))
+		assert.Equal(t, []string{"2025-01-03", "2025-01-01"}, dates(actual["humidity"].Lows))
+	})
+
+	t.Run("annotates and broadcasts newly created records that beat previous records", func(t *testing.T) {
+		// mock socketio.Broadcast
+		websocketEvent := []byte{}
+		app, db := newTestApp(t, withBroadcaster(func(event []byte, mType ...int) {
+			websocketEvent = event
+		}))
+		db.Create(&models.Weather{RecordedAt: "2023-06-15", Humidity: 50, Temperature: 25})
+		db.Create(&models.Weather{RecordedAt: "2024-06-01", Humidity: 50, Temperature: 28})
+		db.Create(&models.Weather{RecordedAt: "2024-07-01", Humidity: 50, Temperature: 35})
+
+		res := createWeatherRecord(app, `{"date":"2025-06-15","humidity":50,"temperature":30}`)
+		assert.Equal(t, 201, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.WeatherRecordResponse
+		err := json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		expected := []services.RecordAnnotation{
+			{Field: "temperature", Kind: "high", Scope: "daily", Previous: 25},
+			{Field: "temperature", Kind: "high", Scope: "monthly", Previous: 28},
+		}
+		assert.Equal(t, expected, actual.Records)
+
+		var actualWebsocketEvent services.WeatherRecordResponse
+		err = json.Unmarshal(websocketEvent, &actualWebsocketEvent)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actualWebsocketEvent.Records)
+
+		// an overwrite is not compared against the previous values of its own date
+		res, updated := write(t, app, "PUT", "/weather/2025-06-15", `{"humidity":50,"temperature":31}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Nil(t, json.Unmarshal([]byte(updated), &actual))
+		assert.Equal(t, expected, actual.Records)
+	})
+
+	t.Run("fails when passing invalid parameters", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/extremes?from=2025-01-01",
+			"/weather/extremes?from=2025-01-01&to=2025-01-31&n=0",
+			"/weather/extremes?from=2025-01-01&to=2025-01-31&n=1000",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+}
+
+func TestDegreeDays(t *testing.T) {
+	t.Run("sums heating and cooling degree days per bucket with coverage", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2025-01-30", Humidity: 50, Temperature: 10})
+		db.Create(&models.Weather{RecordedAt: "2025-01-31", Humidity: 50, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2025-02-01", Humidity: 50, Temperature: 15})
+
+		req, _ := http.NewRequest("GET", "/weather/degree-days?from=2025-01-30&to=2025-02-03&base=18&bucket=month", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.DegreeDaysResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		expected := services.DegreeDaysResponse{
+			Base:   18,
+			Unit:   "C",
+			Bucket: "month",
+			Buckets: []services.DegreeDayBucket{
+				{Start: "2025-01-30", End: "2025-01-31", HDD: 8, CDD: 2, CumulativeHDD: 8, CumulativeCDD: 2, DegreeDayCoverage: services.DegreeDayCoverage{ExpectedDays: 2, ObservedDays: 2, MissingDays: 0, Coverage: 1}},
+				{Start: "2025-02-01", End: "2025-02-03", HDD: 3, CDD: 0, CumulativeHDD: 11, CumulativeCDD: 2, DegreeDayCoverage: services.DegreeDayCoverage{ExpectedDays: 3, ObservedDays: 1, MissingDays: 2, Coverage: 1.0 / 3}},
+			},
+			Totals: services.DegreeDayTotals{HDD: 11, CDD: 2, DegreeDayCoverage: services.DegreeDayCoverage{ExpectedDays: 5, ObservedDays: 3, MissingDays: 2, Coverage: 0.6}},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("converts temperatures and the default base to fahrenheit", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+
+		req, _ := http.NewRequest("GET", "/weather/degree-days?from=2025-01-01&to=2025-01-01&unit=F&bucket=day", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.DegreeDaysResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.InDelta(t, 64.4, actual.Base, 0.0001)
+		assert.InDelta(t, 14.4, actual.Totals.HDD, 0.0001)
+	})
+
+	t.Run("fails when passing invalid parameters", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/degree-days?from=2025-01-01",
+			"/weather/degree-days?from=2025-02-01&to=2025-01-01",
+			"/weather/degree-days?from=2025-01-01&to=2025-01-31&unit=K",
+			"/weather/degree-days?from=2025-01-01&to=2025-01-31&bucket=decade",
+			"/weather/degree-days?from=2025-01-01&to=2025-01-31&base=warm",
+			"/weather/degree-days?from=2025-01-01&to=2025-01-31&base=NaN",
+			"/weather/degree-days?from=2025-01-01&to=2025-01-31&base=Inf",
+			"/weather/degree-days?from=2025-01-01&to=2025-01-31&base=-Inf",
+			"/weather/degree-days?from=2000-01-01&to=2025-01-31",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+}
+
+func TestCompare(t *testing.T) {
+	t.Run("aligns periods of unequal length and summarizes the differences", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2023-01-01", Humidity: 50, Temperature: 10})
+		db.Create(&models.Weather{RecordedAt: "2023-01-02", Humidity: 60, Temperature: 20})
+		db.Create(&models.Weather{RecordedAt: "2024-01-01", Humidity: 55, Temperature: 12})
+		db.Create(&models.Weather{RecordedAt: "2024-01-03", Humidity: 70, Temperature: 30})
+
+		req, _ := http.NewRequest("GET", "/weather/compare?a=2023-01-01/2023-01-02&b=2024-01-01/2024-01-03", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.ComparisonResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, services.Period{From: "2023-01-01", To: "2023-01-02", Days: 2}, actual.A)
+		assert.Equal(t, services.Period{From: "2024-01-01", To: "2024-01-03", Days: 3}, actual.B)
+
+		assert.Equal(t, 3, len(actual.Series))
+		assert.Equal(t, "2023-01-01", actual.Series[0].A.Date)
+		assert.Equal(t, "2024-01-01", actual.Series[0].B.Date)
+		assert.Equal(t, &services.RawWeatherRecordUnits{Humidity: 5, Temperature: 2}, actual.Series[0].Delta)
+		// day 2 is only recorded in the first period, day 3 only exists in the second
+		assert.Nil(t, actual.Series[1].B)
+		assert.Nil(t, actual.Series[1].Delta)
+		assert.Nil(t, actual.Series[2].A)
+		assert.Equal(t, "2024-01-03", actual.Series[2].B.Date)
+
+		assert.Equal(t, services.RawWeatherRecordUnits{Humidity: 7.5, Temperature: 6}, actual.Summary.Delta.Mean)
+		assert.Equal(t, services.RawWeatherRecordUnits{Humidity: 5, Temperature: 2}, actual.Summary.Delta.Min)
+		assert.Equal(t, services.RawWeatherRecordUnits{Humidity: 10, Temperature: 10}, actual.Summary.Delta.Max)
+	})
+
+	t.Run("leaves the summary empty for periods without records", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2023-01-01", Humidity: 50, Temperature: 10})
+
+		req, _ := http.NewRequest("GET", "/weather/compare?a=2023-01-01/2023-01-01&b=2024-01-01/2024-01-01", nil)
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.ComparisonResponse
+		json.Unmarshal(body, &actual)
+		assert.NotNil(t, actual.Summary.A)
+		assert.Nil(t, actual.Summary.B)
+		assert.Nil(t, actual.Summary.Delta)
+	})
+
+	t.Run("fails when passing invalid periods", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/compare?a=2023-01-01/2023-03-31",
+			"/weather/compare?a=2023-01-01&b=2024-01-01/2024-03-31",
+			"/weather/compare?a=2023-03-31/2023-01-01&b=2024-01-01/2024-03-31",
+			"/weather/compare?a=2023-01-01/2023-03-31&b=2024-01-01/not-a-date",
+			"/weather/compare?a=0001-01-01/9999-12-31&b=2024-01-01/2024-03-31",
+			"/weather/compare?a=2023-01-01/2023-03-31&b=2000-01-01/2025-01-31",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+}
+
+func TestHistogram(t *testing.T) {
+	seedTemperatures := func(db *gorm.DB) {
+		for day := 1; day <= 10; day++ {
+			db.Create(&models.Weather{RecordedAt: fmt.Sprintf("2025-01-%02d", day), Humidity: 50, Temperature: float64(day)})
+		}
+	}
+
+	t.Run("returns equal width bins and percentiles with units", func(t *testing.T) {
+		app, db := newTestApp(t)
+		seedTemperatures(db)
+
+		req, _ := http.NewRequest("GET", "/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&bins=3", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.HistogramResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "temperature", actual.Field)
+		assert.Equal(t, "°C", actual.Unit)
+		assert.Equal(t, 10, actual.Count)
+		assert.Equal(t, services.HistogramBin{Lower: services.HistogramValue{Raw: 1, Formatted: "1.00°C"}, Upper: services.HistogramValue{Raw: 4, Formatted: "4.00°C"}, Count: 3}, actual.Bins[0])
+		assert.Equal(t, 3, actual.Bins[1].Count)
+		assert.Equal(t, 4, actual.Bins[2].Count)
+		assert.Equal(t, services.HistogramValue{Raw: 5.5, Formatted: "5.50°C"}, actual.Percentiles["p50"])
+		assert.InDelta(t, 1.45, actual.Percentiles["p5"].Raw, 0.0001)
+		assert.InDelta(t, 9.55, actual.Percentiles["p95"].Raw, 0.0001)
+	})
+
+	t.Run("counts values outside of explicit bin edges", func(t *testing.T) {
+		app, db := newTestApp(t)
+		seedTemperatures(db)
+
+		req, _ := http.NewRequest("GET", "/weather/histogram?field=Temperature&from=2025-01-01&to=2025-01-31&edges=2,5,8", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.HistogramResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 2, len(actual.Bins))
+		assert.Equal(t, 3, actual.Bins[0].Count)
+		assert.Equal(t, 4, actual.Bins[1].Count)
+		assert.Equal(t, 1, actual.Below)
+		assert.Equal(t, 2, actual.Above)
+	})
+
+	t.Run("fails when passing invalid parameters", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/histogram?field=pressure&from=2025-01-01&to=2025-01-31",
+			"/weather/histogram?field=date&from=2025-01-01&to=2025-01-31",
+			"/weather/histogram?field=temperature&from=2025-01-01",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&bins=0",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&edges=5,2",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&edges=5",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&edges=1,2&bins=3",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&bins=abc",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&edges=0,NaN",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&edges=-10,Inf",
+			"/weather/histogram?field=temperature&from=2025-01-01&to=2025-01-31&edges=-Inf,0",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+}
+
+func TestCorrelation(t *testing.T) {
+	// temperature follows humidity one day later
+	seedLagged := func(db *gorm.DB) {
+		humidity := []float64{40, 70, 50, 90, 60, 80, 45}
+		for day := 1; day <= len(humidity); day++ {
+			temperature := 0.0
+			if day > 1 {
+				temperature = humidity[day-2] / 2
+			}
+			db.Create(&models.Weather{RecordedAt: fmt.Sprintf("2025-01-%02d", day), Humidity: humidity[day-1], Temperature: temperature})
+		}
+	}
+
+	t.Run("returns coefficients and regression of lagged measurements", func(t *testing.T) {
+		app, db := newTestApp(t)
+		seedLagged(db)
+
+		req, _ := http.NewRequest("GET", "/weather/correlation?x=humidity&y=temperature&from=2025-01-01&to=2025-01-06&lag=1&max_lag=1", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.CorrelationResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "humidity", actual.X)
+		assert.Equal(t, "temperature", actual.Y)
+		assert.Equal(t, 6, actual.N)
+		assert.InDelta(t, 1, *actual.Pearson, 0.000001)
+		assert.InDelta(t, 1, *actual.Spearman, 0.000001)
+		assert.InDelta(t, 0.5, *actual.Slope, 0.000001)
+		assert.InDelta(t, 0, *actual.Intercept, 0.000001)
+		assert.InDelta(t, 1, *actual.RSquared, 0.000001)
+
+		assert.Equal(t, 3, len(actual.CrossCorrelation))
+		assert.Equal(t, -1, actual.CrossCorrelation[0].Lag)
+		assert.Equal(t, 1, actual.CrossCorrelation[2].Lag)
+		assert.InDelta(t, 1, *actual.CrossCorrelation[2].Pearson, 0.000001)
+		assert.Less(t, *actual.CrossCorrelation[1].Pearson, 0.9)
+	})
+
+	t.Run("returns null coefficients when they are undefined", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+
+		req, _ := http.NewRequest("GET", "/weather/correlation?x=humidity&y=temperature&from=2025-01-01&to=2025-01-31", nil)
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.CorrelationResponse
+		json.Unmarshal(body, &actual)
+		assert.Equal(t, 1, actual.N)
+		assert.Nil(t, actual.Pearson)
+		assert.Nil(t, actual.Slope)
+	})
+
+	t.Run("fails when passing invalid parameters", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		for _, url := range []string{
+			"/weather/correlation?x=pressure&y=temperature&from=2025-01-01&to=2025-01-31",
+			"/weather/correlation?x=humidity&from=2025-01-01&to=2025-01-31",
+			"/weather/correlation?x=humidity&y=temperature&from=2025-01-31&to=2025-01-01",
+			"/weather/correlation?x=humidity&y=temperature&from=2025-01-01&to=2025-01-31&lag=1000",
+			"/weather/correlation?x=humidity&y=temperature&from=2025-01-01&to=2025-01-31&max_lag=-1",
+			"/weather/correlation?x=humidity&y=temperature&from=2000-01-01&to=2025-01-31",
+		} {
+			req, _ := http.NewRequest("GET", url, nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 400, res.StatusCode, url)
+		}
+	})
+
+	t.Run("fails when a configured measurement is not stored", func(t *testing.T) {
+		app, db := newTestApp(t, withColumns(func(columns *configs.ColumnsConfig) {
+			columns.Measurements = append(columns.Measurements, configs.Measurement{Name: "Pressure", Unit: "hPa"})
+		}))
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10})
+
+		req, _ := http.NewRequest("GET", "/weather/correlation?x=pressure&y=temperature&from=2025-01-01&to=2025-01-31", nil)
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 500, res.StatusCode)
+	})
+}
+
+func TestConditionalRequests(t *testing.T) {
+	get := func(app *fiber.App, url string, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest("GET", url, nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		return res
+	}
+
+	t.Run("returns 304 when the ETag still matches", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 20})
+
+		for _, url := range []string{"/weather/2025-01-01", "/weather/2025-01-01/2025-01-31"} {
+			res := get(app, url, nil)
+			assert.Equal(t, 200, res.StatusCode, url)
+			etag := res.Header.Get("ETag")
+			assert.NotEmpty(t, etag, url)
+			assert.NotEmpty(t, res.Header.Get("Last-Modified"), url)
+
+			res = get(app, url, map[string]string{"If-None-Match": etag})
+			assert.Equal(t, 304, res.StatusCode, url)
+			body, _ := io.ReadAll(res.Body)
+			assert.Empty(t, body, url)
+
+			res = get(app, url, map[string]string{"If-None-Match": `"outdated"`})
+			assert.Equal(t, 200, res.StatusCode, url)
+		}
+	})
+
+	t.Run("returns 304 when not modified since", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 20})
+
+		res := get(app, "/weather/2025-01-01", nil)
+		lastModified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+		assert.Nil(t, err)
+
+		res = get(app, "/weather/2025-01-01", map[string]string{"If-Modified-Since": lastModified.Add(time.Hour).Format(http.TimeFormat)})
+		assert.Equal(t, 304, res.StatusCode)
+
+		res = get(app, "/weather/2025-01-01", map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)})
+		assert.Equal(t, 200, res.StatusCode)
+	})
+
+	t.Run("changes the ETag after a write", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		res := get(app, "/weather/2025-01-01/2025-01-31", nil)
+		etag := res.Header.Get("ETag")
+
+		res = createWeatherRecord(app, `{"date": "2025-01-02", "humidity": 60, "temperature": 20}`)
+		assert.Equal(t, 201, res.StatusCode)
+
+		res = get(app, "/weather/2025-01-01/2025-01-31", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, 200, res.StatusCode)
+		assert.NotEqual(t, etag, res.Header.Get("ETag"))
+
+		var actual []services.WeatherRecordResponse
+		body, _ := io.ReadAll(res.Body)
+		json.Unmarshal(body, &actual)
+		assert.Equal(t, 1, len(actual))
+	})
+
+	t.Run("returns the writes of other processes once cached results expire", func(t *testing.T) {
+		clock := &testClock{now: time.Date(2025, time.February, 1, 12, 0, 0, 0, time.UTC)}
+		app, db := newTestApp(t, withClock(clock), withConfig(func(conf *configs.Config) {
+			conf.QueryCacheTTL = time.Minute
+		}))
+
+		get(app, "/weather/2025-01-01/2025-01-31", nil)
+		// the records of the ingest and seed commands are written without the server
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 20})
+		var actual []services.WeatherRecordResponse
+		res := get(app, "/weather/2025-01-01/2025-01-31", nil)
+		body, _ := io.ReadAll(res.Body)
+		json.Unmarshal(body, &actual)
+		assert.Empty(t, actual)
+
+		clock.now = clock.now.Add(time.Minute)
+		res = get(app, "/weather/2025-01-01/2025-01-31", nil)
+		body, _ = io.ReadAll(res.Body)
+		json.Unmarshal(body, &actual)
+		assert.Equal(t, 1, len(actual))
+	})
+
+	t.Run("counts cache hits and misses", func(t *testing.T) {
+		app, db := newTestApp(t)
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 20})
+
+		get(app, "/weather/2025-01-01/2025-01-31", nil)
+		get(app, "/weather/2025-01-01/2025-01-31", nil)
+		get(app, "/weather/2025-01-01/2025-01-31?with=departure", nil)
+
+		res := get(app, "/cache/stats", nil)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.CacheStatistics
+		err := json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), actual.Hits)
+		assert.Equal(t, uint64(2), actual.Misses)
+		assert.Equal(t, 2, actual.Size)
+	})
+}
+
+func TestHealth(t *testing.T) {
+	t.Run("reports the process as alive", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+	})
+
+	t.Run("reports readiness with the timing of each check", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.ReadinessResponse
+		err = json.Unmarshal(body, &actual)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "ok", actual.Status)
+		var names []string
+		for _, check := range actual.Checks {
+			names = append(names, check.Name)
+			assert.Equal(t, "ok", check.Status, check.Name)
+			assert.GreaterOrEqual(t, check.DurationMs, 0.0)
+		}
+		assert.Equal(t, []string{"database", "migrations", "columns"}, names)
+	})
+
+	t.Run("is not ready with pending migrations", func(t *testing.T) {
+		app, db := newTestApp(t)
+		migrations.Down(context.Background(), db, 1)
+
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 503, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+
+		var actual services.ReadinessResponse
+		json.Unmarshal(body, &actual)
+		assert.Equal(t, "failed", actual.Status)
+		assert.Equal(t, "failed", actual.Checks[1].Status)
+		assert.NotEmpty(t, actual.Checks[1].Error)
+	})
+
+	t.Run("reports the status of the service", func(t *testing.T) {
+		app, db := newTestApp(t)
+
+		getStatus := func() services.StatusResponse {
+			req, _ := http.NewRequest("GET", "/status", nil)
+			res, err := app.Test(req, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, 200, res.StatusCode)
+			body, _ := io.ReadAll(res.Body)
+
+			var actual services.StatusResponse
+			err = json.Unmarshal(body, &actual)
+			assert.Nil(t, err)
+			return actual
+		}
+
+		actual := getStatus()
+		assert.Equal(t, "dev", actual.Version)
+		assert.Equal(t, "sqlite", actual.DbDialect)
+		assert.Equal(t, 0, actual.WebSocketClients)
+		assert.Greater(t, actual.UptimeSeconds, 0.0)
+		assert.Nil(t, actual.LastIngestion)
+		assert.Equal(t, 4, len(actual.Checks))
+
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 20})
+		actual = getStatus()
+		assert.NotNil(t, actual.LastIngestion)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("exposes request, database and domain metrics", func(t *testing.T) {
+		app, _ := newTestApp(t)
+
+		req, _ := http.NewRequest("GET", "/weather/2025-01-01", nil)
+		app.Test(req, -1)
+		createWeatherRecord(app, `{"date": "2025-0101", "humidity": 60, "temperature": 20}`)
+		res := createWeatherRecord(app, `{"date": "2025-01-01", "humidity": 60, "temperature": 20}`)
+		assert.Equal(t, 201, res.StatusCode)
+
+		req, _ = http.NewRequest("GET", "/metrics", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Contains(t, res.Header.Get("Content-Type"), "text/plain")
+		body, _ := io.ReadAll(res.Body)
+		exposition := string(body)
+
+		for _, expected := range []string{
+			"# TYPE http_requests_total counter",
+			`http_requests_total{method="GET",route="/weather/:from",status="200"}`,
+			`http_requests_total{method="POST",route="/weather",status="400"}`,
+			`http_request_duration_seconds_bucket{method="GET",route="/weather/:from",status="200",le="+Inf"}`,
+			`db_query_duration_seconds_count{operation="create",table="weather"}`,
+			"websocket_connections 0",
+			`websocket_broadcasts_total{event="record"}`,
+			`validation_rejections_total{reason="invalid_date"}`,
+			"weather_records_created_total ",
+		} {
+			assert.Contains(t, exposition, expected)
+		}
+	})
+}
+
+func TestRequestLogging(t *testing.T) {
+	// capture the log output
+	var output strings.Builder
+	logger, _ := logging.New(&output, "json", "debug")
+	original := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(original)
+
+	t.Run("creates a request id and echoes it", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.NotEmpty(t, res.Header.Get("X-Request-ID"))
+	})
+
+	t.Run("propagates the request id into the service layer", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		output.Reset()
+
+		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(`{"date": "2025-01-01", "humidity": 60, "temperature": 20}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", "abcdef")
+		req.Header.Set("X-Request-ID", "test-request")
+		res, err := app.Test(req, -1)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 201, res.StatusCode)
+		assert.Equal(t, "test-request", res.Header.Get("X-Request-ID"))
+
+		var messages []string
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			var entry map[string]any
+			err := json.Unmarshal([]byte(line), &entry)
+			assert.Nil(t, err)
+			assert.Equal(t, "test-request", entry["request_id"], line)
+			messages = append(messages, entry["msg"].(string))
+		}
+		assert.Contains(t, messages, "Received request to create")
+		assert.Contains(t, messages, "Saved weather record")
+		assert.Contains(t, messages, "Broadcasting")
+		assert.Contains(t, messages, "Request")
+	})
+}
+
+func TestTimeouts(t *testing.T) {
+	t.Run("answers requests exceeding the route timeout with 504", func(t *testing.T) {
+		app, db := newTestApp(t, withConfig(func(conf *configs.Config) {
+			conf.RouteTimeouts["/weather/extremes"] = time.Nanosecond
+		}))
+		db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 20})
+
+		req, _ := http.NewRequest("GET", "/weather/extremes?from=2025-01-01&to=2025-01-31", nil)
+		res, err := app.Test(req, -1)
+
+		// Validate response
+		assert.Nil(t, err)
+		assert.Equal(t, 504, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, "Request timed out", string(body))
+
+		// other routes keep the default timeout
+		req, _ = http.NewRequest("GET", "/weather/2025-01-01", nil)
+		res, err = app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("asks WebSocket clients to reconnect", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		go app.Listener(listener)
+		defer app.Shutdown()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/ws/test-user", nil)
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		_, greeting, err := conn.ReadMessage()
+		assert.Nil(t, err)
+		assert.Contains(t, string(greeting), "Hello user: test-user")
+		assert.Equal(t, 1, server.ConnectedClients())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		closed := make(chan error, 1)
+		go func() { closed <- server.CloseWebSockets(ctx) }()
+
+		// the client answers the close frame, which disconnects it
+		_, _, err = conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		assert.True(t, errors.As(err, &closeErr))
+		assert.Equal(t, websocket.CloseServiceRestart, closeErr.Code)
+		assert.Contains(t, closeErr.Text, "reconnect")
+
+		assert.Nil(t, <-closed)
+		assert.Equal(t, 0, server.ConnectedClients())
+	})
+}
+
+func TestApiTokens(t *testing.T) {
+	post := func(app *fiber.App, token string, date string) int {
+		requestBody := fmt.Sprintf(`{"date":"%s","humidity":60,"temperature":20}`, date)
+		req, _ := http.NewRequest("POST", "/weather", strings.NewReader(requestBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", token)
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		return res.StatusCode
+	}
+
+	t.Run("accepts created tokens until they are revoked", func(t *testing.T) {
+		app, db := newTestApp(t)
+		conf, _ := configs.Load()
+		// the revocation time is stored in UTC, whatever the zone of the clock
+		revokedAt := time.Date(2024, time.June, 12, 15, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		service := services.New(db, repository.NewGorm(db), conf, nil, utils.FixedClock{Time: revokedAt})
+
+		token, secret, err := service.CreateToken(context.Background(), "ingestion")
+		assert.Nil(t, err)
+		assert.Equal(t, 201, post(app, secret, "2024-01-01"))
+		assert.Equal(t, 401, post(app, "not-a-token", "2024-01-02"))
+
+		revoked, err := service.RevokeToken(context.Background(), token.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, revokedAt.UTC(), *revoked.RevokedAt)
+		assert.Equal(t, 401, post(app, secret, "2024-01-02"))
+		// the configured token is always accepted
+		assert.Equal(t, 201, post(app, "abcdef", "2024-01-02"))
+
+		_, err = service.RevokeToken(context.Background(), token.ID+1)
+		assert.True(t, errors.Is(err, services.ErrTokenNotFound))
+	})
+
+	t.Run("stores only the hash of the secret", func(t *testing.T) {
+		_, db := newTestApp(t)
+		conf, _ := configs.Load()
+		service := services.New(db, repository.NewGorm(db), conf, nil, utils.SystemClock{})
+
+		_, secret, err := service.CreateToken(context.Background(), "ingestion")
+		assert.Nil(t, err)
+
+		tokens, err := service.ListTokens(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(tokens))
+		assert.NotContains(t, tokens[0].TokenHash, secret)
+		assert.Nil(t, tokens[0].RevokedAt)
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	t.Run("keeps the weather records in memory", func(t *testing.T) {
+		app, db := newTestApp(t, withConfig(func(conf *configs.Config) {
+			conf.DbConnectionString = "memory://"
+		}))
+
+		res, _ := write(t, app, "POST", "/weather", `{"date":"2024-06-01","humidity":60,"temperature":25}`, nil)
+		assert.Equal(t, 201, res.StatusCode)
+		res, _ = write(t, app, "POST", "/weather", `{"date":"2024-06-02","humidity":55,"temperature":22}`, nil)
+		assert.Equal(t, 201, res.StatusCode)
+		res, _ = write(t, app, "PUT", "/weather/2024-06-01", `{"humidity":62,"temperature":25}`, nil)
+		assert.Equal(t, 200, res.StatusCode)
+
+		req, _ := http.NewRequest("GET", "/weather/2024-06-01/2024-06-02", nil)
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		body, _ := io.ReadAll(res.Body)
+		var records []services.WeatherRecordResponse
+		assert.Nil(t, json.Unmarshal(body, &records))
+		assert.Equal(t, 2, len(records))
+		assert.Equal(t, 62.0, records[0].Raw.Humidity)
+
+		req, _ = http.NewRequest("GET", "/weather/2024-06-01/history", nil)
+		res, _ = app.Test(req, -1)
+		body, _ = io.ReadAll(res.Body)
+		var history []services.RevisionResponse
+		assert.Nil(t, json.Unmarshal(body, &history))
+		assert.Equal(t, 2, len(history))
+
+		req, _ = http.NewRequest("GET", "/status", nil)
+		res, _ = app.Test(req, -1)
+		body, _ = io.ReadAll(res.Body)
+		var status services.StatusResponse
+		assert.Nil(t, json.Unmarshal(body, &status))
+		assert.NotNil(t, status.LastIngestion)
+
+		// the database only holds the other tables
+		var count int64
+		db.Model(&models.Weather{}).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
 }
@@ -0,0 +1,278 @@
+// Package metrics is a minimal implementation of Prometheus counters, gauges and histograms
+// with the text exposition format, see https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds used for latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer, name string)
+	kind() string
+}
+
+type registered struct {
+	help   string
+	metric metric
+}
+
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]registered
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]registered{}}
+}
+
+// Default is the registry exposed on /metrics
+var Default = NewRegistry()
+
+// register stores the metric under its name. Registering a name again returns the metric registered first,
+// so setup code may run more than once, e.g. in tests.
+func (r *Registry) register(name string, help string, m metric) metric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, ok := r.metrics[name]; ok {
+		if existing.metric.kind() != m.kind() {
+			panic(fmt.Sprintf("metric %s is already registered as a %s", name, existing.metric.kind()))
+		}
+		return existing.metric
+	}
+	r.metrics[name] = registered{help: help, metric: m}
+	return m
+}
+
+func (r *Registry) Counter(name string, help string, labels ...string) *CounterVec {
+	return r.register(name, help, &CounterVec{labels: labels, values: map[string]*labeledValue{}}).(*CounterVec)
+}
+
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return r.register(name, help, &HistogramVec{labels: labels, buckets: buckets, values: map[string]*histogramValue{}}).(*HistogramVec)
+}
+
+// GaugeFunc registers a gauge whose value is read from the function on every scrape
+func (r *Registry) GaugeFunc(name string, help string, value func() float64) {
+	r.register(name, help, gaugeFunc(value))
+}
+
+// Write renders all metrics sorted by name in the text exposition format
+func (r *Registry) Write(w io.Writer) {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make(map[string]registered, len(r.metrics))
+	for name, m := range r.metrics {
+		metrics[name] = m
+	}
+	r.mutex.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(metrics[name].help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, metrics[name].metric.kind())
+		metrics[name].metric.write(w, name)
+	}
+}
+
+func Counter(name string, help string, labels ...string) *CounterVec {
+	return Default.Counter(name, help, labels...)
+}
+
+func Histogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.Histogram(name, help, buckets, labels...)
+}
+
+func GaugeFunc(name string, help string, value func() float64) {
+	Default.GaugeFunc(name, help, value)
+}
+
+type labeledValue struct {
+	labelValues []string
+	value       float64
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	mutex  sync.Mutex
+	labels []string
+	values map[string]*labeledValue
+}
+
+// Inc increments the counter of the given label values, which must match the labels in number and order
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	checkLabels(c.labels, labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := c.values[key]; !ok {
+		c.values[key] = &labeledValue{labelValues: labelValues}
+	}
+	c.values[key].value += delta
+}
+
+// Value returns the current count of the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if value, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return value.value
+	}
+	return 0
+}
+
+func (c *CounterVec) kind() string {
+	return "counter"
+}
+
+func (c *CounterVec) write(w io.Writer, name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range sortedKeys(c.values) {
+		value := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(c.labels, value.labelValues, "", ""), formatValue(value.value))
+	}
+}
+
+type histogramValue struct {
+	labelValues []string
+	// cumulative counts per bucket upper bound
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	mutex   sync.Mutex
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+// Observe records a value, e.g. a duration in seconds, for the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	checkLabels(h.labels, labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := h.values[key]; !ok {
+		h.values[key] = &histogramValue{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+	}
+	histogram := h.values[key]
+	for i, bound := range h.buckets {
+		if value <= bound {
+			histogram.counts[i]++
+		}
+	}
+	histogram.count++
+	histogram.sum += value
+}
+
+// Count returns the number of observations of the given label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if value, ok := h.values[strings.Join(labelValues, "\xff")]; ok {
+		return value.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) kind() string {
+	return "histogram"
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(h.labels, value.labelValues, "le", formatValue(bound)), value.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(h.labels, value.labelValues, "le", "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(h.labels, value.labelValues, "", ""), formatValue(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(h.labels, value.labelValues, "", ""), value.count)
+	}
+}
+
+type gaugeFunc func() float64
+
+func (g gaugeFunc) kind() string {
+	return "gauge"
+}
+
+func (g gaugeFunc) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatValue(g()))
+}
+
+func checkLabels(labels []string, labelValues []string) {
+	if len(labels) != len(labelValues) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(labels), len(labelValues)))
+	}
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders {name="value",...}, with an optional extra label such as the bucket bound
+func formatLabels(labels []string, labelValues []string, extraLabel string, extraValue string) string {
+	var pairs []string
+	for i, label := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escapeLabelValue(labelValues[i])))
+	}
+	if extraLabel != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraLabel, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
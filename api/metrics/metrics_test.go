@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func render(registry *Registry) string {
+	var builder strings.Builder
+	registry.Write(&builder)
+	return builder.String()
+}
+
+func TestCounter(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.Counter("requests_total", "Number of requests", "route", "status")
+	counter.Inc("/ping", "200")
+	counter.Inc("/ping", "200")
+	counter.Add(3, "/weather/:from", "400")
+
+	assert.Equal(t, 2.0, counter.Value("/ping", "200"))
+	assert.Equal(t, 0.0, counter.Value("/ping", "500"))
+	assert.Equal(t, `# HELP requests_total Number of requests
+# TYPE requests_total counter
+requests_total{route="/ping",status="200"} 2
+requests_total{route="/weather/:from",status="400"} 3
+`, render(registry))
+}
+
+func TestRegisteringTwiceReturnsTheSameMetric(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("created_total", "Number of created records").Inc()
+	registry.Counter("created_total", "Number of created records").Inc()
+
+	assert.Equal(t, 2.0, registry.Counter("created_total", "").Value())
+	assert.Panics(t, func() { registry.Histogram("created_total", "", DefaultBuckets) })
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.Histogram("duration_seconds", "Duration", []float64{0.1, 1}, "route")
+	histogram.Observe(0.05, "/ping")
+	histogram.Observe(0.5, "/ping")
+	histogram.Observe(2, "/ping")
+
+	assert.Equal(t, uint64(3), histogram.Count("/ping"))
+	assert.Equal(t, `# HELP duration_seconds Duration
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/ping",le="0.1"} 1
+duration_seconds_bucket{route="/ping",le="1"} 2
+duration_seconds_bucket{route="/ping",le="+Inf"} 3
+duration_seconds_sum{route="/ping"} 2.55
+duration_seconds_count{route="/ping"} 3
+`, render(registry))
+}
+
+func TestGaugeFuncAndEscaping(t *testing.T) {
+	registry := NewRegistry()
+	registry.GaugeFunc("connections", "Open\nconnections", func() float64 { return 4 })
+	registry.Counter("rejections_total", "Rejections", "reason").Inc(`say "no"`)
+
+	assert.Equal(t, `# HELP connections Open\nconnections
+# TYPE connections gauge
+connections 4
+# HELP rejections_total Rejections
+# TYPE rejections_total counter
+rejections_total{reason="say \"no\""} 1
+`, render(registry))
+}
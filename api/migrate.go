@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"weatherapi/migrations"
+	"weatherapi/server"
+)
+
+// runMigrate applies, reverts or lists the schema migrations
+func runMigrate(args []string) int {
+	flags, env := newFlagSet("migrate", "migrate [flags] up|down [steps]|status")
+	args, code, ok := parseFlags(flags, args)
+	if !ok {
+		return code
+	}
+	if len(args) == 0 || len(args) > 2 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return usageError(flags, "expected up, down or status")
+	}
+
+	steps := 1
+	if len(args) == 2 {
+		parsed, err := strconv.Atoi(args[1])
+		if args[0] != "down" || err != nil || parsed < 1 {
+			return usageError(flags, "only down takes a number of steps, which has to be positive")
+		}
+		steps = parsed
+	}
+
+	conf, _, code := loadConfig(env)
+	if code != exitOK {
+		return code
+	}
+	db, code := openDb(conf, false)
+	if code != exitOK {
+		return code
+	}
+	defer server.CloseDb(db)
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		reverted, err := migrations.Down(ctx, db, steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+	case "status":
+		statuses, err := migrations.GetStatus(ctx, db)
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		writer.Flush()
+	}
+	return exitOK
+}
@@ -0,0 +1,320 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// files holds the migrations as <version>_<name>.up.sql and <version>_<name>.down.sql, written once for every dialect
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// the only difference between the dialects, the auto-incrementing primary key
+var primaryKeys = map[string]string{
+	"postgres": "SERIAL PRIMARY KEY",
+	"sqlite":   "INTEGER PRIMARY KEY AUTOINCREMENT",
+}
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+var (
+	ErrNotMigrated = errors.New("database is not migrated")
+	ErrUnknown     = errors.New("database has migrations this version does not know")
+
+	filenamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it is applied to the database
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// SchemaMigration is a row of the migrations table
+type SchemaMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Load returns the embedded migrations ordered by version, every migration needs both an up and a down file
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := filenamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration filename: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(files, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// render fills the dialect specific parts of a migration
+func render(db *gorm.DB, statements string) (string, error) {
+	primaryKey, ok := primaryKeys[db.Dialector.Name()]
+	if !ok {
+		return "", fmt.Errorf("unsupported database dialect: %s", db.Dialector.Name())
+	}
+	return strings.ReplaceAll(statements, "{{primary_key}}", primaryKey), nil
+}
+
+// applied returns the rows of the migrations table by version, creating the table when it does not exist
+func applied(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.Exec(createTable).Error; err != nil {
+		return nil, fmt.Errorf("error creating migrations table: %v", err)
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("error loading applied migrations: %v", err)
+	}
+	byVersion := map[int]SchemaMigration{}
+	for _, row := range rows {
+		byVersion[row.Version] = row
+	}
+	return byVersion, nil
+}
+
+// legacyStatements bring the weather table of a database created by db/seed.sql, before migrations were introduced,
+// to the schema of 0001_create_weather. Older versions of the file did not have the anomaly columns yet.
+var legacyStatements = map[string][]string{
+	"postgres": {
+		"ALTER TABLE weather DROP CONSTRAINT IF EXISTS weather_recorded_at_key",
+		"ALTER TABLE weather ALTER COLUMN recorded_at TYPE TEXT USING to_char(recorded_at::date, 'YYYY-MM-DD')",
+		"ALTER TABLE weather ADD COLUMN IF NOT EXISTS anomaly BOOLEAN NOT NULL DEFAULT FALSE",
+		"ALTER TABLE weather ADD COLUMN IF NOT EXISTS anomaly_fields TEXT NOT NULL DEFAULT ''",
+		"DROP INDEX IF EXISTS idx_weather_recorded_at",
+		"CREATE UNIQUE INDEX idx_weather_recorded_at ON weather (recorded_at) WHERE deleted_at IS NULL",
+		"CREATE INDEX IF NOT EXISTS idx_weather_deleted_at ON weather (deleted_at)",
+	},
+}
+
+// baseline adopts a database whose tables were created by db/seed.sql, which has a weather table but no applied migrations.
+// The legacy schema is altered in place and the migrations it corresponds to are marked applied, the weather_normals table
+// of the file already matches 0002_create_weather_normals. Other databases are left untouched.
+func baseline(db *gorm.DB, migrations []Migration, done map[int]SchemaMigration) ([]Migration, error) {
+	if len(done) > 0 || !db.Migrator().HasTable("weather") {
+		return nil, nil
+	}
+	statements, ok := legacyStatements[db.Dialector.Name()]
+	if !ok {
+		return nil, fmt.Errorf("database has a weather table without applied migrations, which can only be adopted on postgres")
+	}
+	adopted := map[string]bool{
+		"create_weather":         true,
+		"create_weather_normals": db.Migrator().HasTable("weather_normals"),
+	}
+
+	var result []Migration
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		for _, migration := range migrations {
+			if !adopted[migration.Name] {
+				continue
+			}
+			if err := tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+			done[migration.Version] = SchemaMigration{Version: migration.Version, Name: migration.Name}
+			result = append(result, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error adopting the existing schema: %v", err)
+	}
+	return result, nil
+}
+
+// Up applies every pending migration in order, each in its own transaction, and returns the applied migrations.
+// The migrations a legacy schema is adopted as (see baseline) are returned as applied.
+func Up(ctx context.Context, db *gorm.DB) ([]Migration, error) {
+	db = db.WithContext(ctx)
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	result, err := baseline(db, migrations, done)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		if _, ok := done[migration.Version]; ok {
+			continue
+		}
+		statements, err := render(db, migration.Up)
+		if err != nil {
+			return result, err
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(statements).Error; err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return result, fmt.Errorf("error applying migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+// Down reverts the given number of most recently applied migrations, and returns the reverted migrations
+func Down(ctx context.Context, db *gorm.DB, steps int) ([]Migration, error) {
+	db = db.WithContext(ctx)
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Migration
+	for i := len(migrations) - 1; i >= 0 && len(result) < steps; i-- {
+		migration := migrations[i]
+		if _, ok := done[migration.Version]; !ok {
+			continue
+		}
+		statements, err := render(db, migration.Down)
+		if err != nil {
+			return result, err
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(statements).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, "version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return result, fmt.Errorf("error reverting migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+// GetStatus lists every known migration and when it was applied
+func GetStatus(ctx context.Context, db *gorm.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if row, ok := done[migration.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Check returns ErrNotMigrated when a migration is pending, and ErrUnknown when the database was migrated by a newer version.
+// It only reads the migrations table, which is not created when it does not exist.
+func Check(ctx context.Context, db *gorm.DB) error {
+	db = db.WithContext(ctx)
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return fmt.Errorf("%w: %d pending migrations", ErrNotMigrated, len(migrations))
+	}
+
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return fmt.Errorf("error loading applied migrations: %v", err)
+	}
+	known := map[int]bool{}
+	for _, migration := range migrations {
+		known[migration.Version] = true
+	}
+	for _, row := range rows {
+		if !known[row.Version] {
+			return fmt.Errorf("%w: %d_%s", ErrUnknown, row.Version, row.Name)
+		}
+	}
+	if pending := len(migrations) - len(rows); pending > 0 {
+		return fmt.Errorf("%w: %d pending migrations", ErrNotMigrated, pending)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+	"weatherapi/models"
+	"weatherapi/server"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var testDatabases atomic.Int64
+
+func openTestDb(t *testing.T) *gorm.DB {
+	db, err := server.OpenDb(fmt.Sprintf("sqlite://file:migrations-%d?mode=memory&cache=shared", testDatabases.Add(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.CloseDb(db) })
+	return db
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	assert.Nil(t, err)
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version)
+		assert.NotEmpty(t, migration.Up, migration.Name)
+		assert.NotEmpty(t, migration.Down, migration.Name)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("applies pending migrations once", func(t *testing.T) {
+		db := openTestDb(t)
+		migrations, _ := Load()
+		assert.True(t, errors.Is(Check(ctx, db), ErrNotMigrated))
+
+		applied, err := Up(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, len(migrations), len(applied))
+		assert.Nil(t, Check(ctx, db))
+		assert.True(t, db.Migrator().HasTable(&models.Weather{}))
+		assert.True(t, db.Migrator().HasTable(&models.WeatherNormal{}))
+
+		applied, err = Up(ctx, db)
+		assert.Nil(t, err)
+		assert.Empty(t, applied)
+
+		statuses, err := GetStatus(ctx, db)
+		assert.Nil(t, err)
+		assert.Equal(t, len(migrations), len(statuses))
+		for _, status := range statuses {
+			assert.NotNil(t, status.AppliedAt, status.Name)
+		}
+	})
+
+	t.Run("reverts the latest migrations", func(t *testing.T) {
+		db := openTestDb(t)
+		Up(ctx, db)
+
+		migrations, _ := Load()
+		latest := len(migrations) - 1
+
+		reverted, err := Down(ctx, db, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(reverted))
+		assert.Equal(t, migrations[latest].Name, reverted[0].Name)
+		assert.True(t, errors.Is(Check(ctx, db), ErrNotMigrated))
+
+		statuses, _ := GetStatus(ctx, db)
+		assert.NotNil(t, statuses[latest-1].AppliedAt)
+		assert.Nil(t, statuses[latest].AppliedAt)
+
+		reverted, err = Down(ctx, db, 100)
+		assert.Nil(t, err)
+		assert.Equal(t, latest, len(reverted))
+		assert.False(t, db.Migrator().HasTable(&models.WeatherNormal{}))
+		assert.False(t, db.Migrator().HasTable(&models.Weather{}))
+
+		// everything can be applied again
+		_, err = Up(ctx, db)
+		assert.Nil(t, err)
+		assert.Nil(t, Check(ctx, db))
+	})
+
+	t.Run("refuses databases migrated by a newer version", func(t *testing.T) {
+		db := openTestDb(t)
+		Up(ctx, db)
+		db.Create(&SchemaMigration{Version: 9999, Name: "from_the_future", AppliedAt: time.Now()})
+
+		assert.True(t, errors.Is(Check(ctx, db), ErrUnknown))
+	})
+
+	t.Run("allows one record per day", func(t *testing.T) {
+		db := openTestDb(t)
+		Up(ctx, db)
+
+		first := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		assert.Nil(t, db.Create(&first).Error)
+		assert.NotNil(t, db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}).Error)
+
+		// soft deleted records do not count
+		assert.Nil(t, db.Delete(&first).Error)
+		assert.Nil(t, db.Create(&models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}).Error)
+	})
+
+	t.Run("starts the history of existing records", func(t *testing.T) {
+		db := openTestDb(t)
+		Up(ctx, db)
+		Down(ctx, db, 100)
+		migrations, _ := Load()
+		for _, migration := range migrations {
+			if migration.Name == "create_weather_revisions" {
+				break
+			}
+			statements, _ := render(db, migration.Up)
+			assert.Nil(t, db.Exec(statements).Error)
+			db.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()})
+		}
+
+		kept := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		deleted := models.Weather{RecordedAt: "2025-01-02", Humidity: 60, Temperature: 12}
+		// the columns of later migrations do not exist yet
+		older := db.Omit("source", "batch_id", "instrument", "quality")
+		older.Create(&kept)
+		older.Create(&deleted)
+		db.Delete(&deleted)
+		_, err := Up(ctx, db)
+		assert.Nil(t, err)
+
+		var revisions []models.WeatherRevision
+		db.Find(&revisions)
+		assert.Equal(t, 1, len(revisions))
+		assert.Equal(t, kept.ID, revisions[0].WeatherID)
+		assert.Equal(t, "create", revisions[0].Operation)
+		assert.Equal(t, 50.0, *revisions[0].AfterHumidity)
+	})
+
+	t.Run("adopts only legacy schemas it knows how to alter", func(t *testing.T) {
+		db := openTestDb(t)
+		// the legacy schema of db/seed.sql is adopted on postgres
+		assert.Nil(t, db.Exec("CREATE TABLE weather (id INTEGER PRIMARY KEY, recorded_at DATE NOT NULL UNIQUE)").Error)
+
+		applied, err := Up(ctx, db)
+		assert.NotNil(t, err)
+		assert.Empty(t, applied)
+		statuses, _ := GetStatus(ctx, db)
+		for _, status := range statuses {
+			assert.Nil(t, status.AppliedAt, status.Name)
+		}
+	})
+
+	t.Run("requires every value", func(t *testing.T) {
+		db := openTestDb(t)
+		Up(ctx, db)
+
+		err := db.Exec("INSERT INTO weather (recorded_at, humidity, created_at, updated_at) VALUES (?, ?, ?, ?)", "2025-01-01", 50, time.Now(), time.Now()).Error
+		assert.NotNil(t, err)
+		err = db.Create(&models.WeatherNormal{DayOfYear: 1, Field: "humidity", UpdatedAt: time.Now()}).Error
+		assert.Nil(t, err)
+		err = db.Create(&models.WeatherNormal{DayOfYear: 1, Field: "humidity", UpdatedAt: time.Now()}).Error
+		assert.NotNil(t, err)
+	})
+}
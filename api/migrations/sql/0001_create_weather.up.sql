@@ -0,0 +1,15 @@
+CREATE TABLE weather (
+    id {{primary_key}},
+    recorded_at TEXT NOT NULL,
+    humidity FLOAT NOT NULL,
+    temperature FLOAT NOT NULL,
+    anomaly BOOLEAN NOT NULL DEFAULT FALSE,
+    anomaly_fields TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    deleted_at TIMESTAMP
+);
+
+-- one record per day, soft deleted records do not block a new record of their day
+CREATE UNIQUE INDEX idx_weather_recorded_at ON weather (recorded_at) WHERE deleted_at IS NULL;
+CREATE INDEX idx_weather_deleted_at ON weather (deleted_at);
@@ -0,0 +1,15 @@
+CREATE TABLE weather_normals (
+    id {{primary_key}},
+    day_of_year INTEGER NOT NULL,
+    field TEXT NOT NULL,
+    count INTEGER NOT NULL,
+    mean FLOAT NOT NULL,
+    p10 FLOAT NOT NULL,
+    p25 FLOAT NOT NULL,
+    p50 FLOAT NOT NULL,
+    p75 FLOAT NOT NULL,
+    p90 FLOAT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_weather_normals_day_field ON weather_normals (day_of_year, field);
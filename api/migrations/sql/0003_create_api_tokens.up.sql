@@ -0,0 +1,9 @@
+CREATE TABLE api_tokens (
+    id {{primary_key}},
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_api_tokens_token_hash ON api_tokens (token_hash);
@@ -0,0 +1,19 @@
+CREATE TABLE weather_revisions (
+    id {{primary_key}},
+    weather_id INTEGER NOT NULL,
+    recorded_at TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    before_humidity FLOAT,
+    before_temperature FLOAT,
+    after_humidity FLOAT,
+    after_temperature FLOAT,
+    actor TEXT NOT NULL,
+    source TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_weather_revisions_recorded_at ON weather_revisions (recorded_at, created_at);
+
+-- the history of existing records starts with their current values, at the time they were created
+INSERT INTO weather_revisions (weather_id, recorded_at, operation, after_humidity, after_temperature, actor, source, created_at)
+SELECT id, recorded_at, 'create', humidity, temperature, 'migration', 'admin', created_at FROM weather WHERE deleted_at IS NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE weather_revisions DROP COLUMN after_quality;
+ALTER TABLE weather_revisions DROP COLUMN before_quality;
+
+ALTER TABLE weather DROP COLUMN quality;
+ALTER TABLE weather DROP COLUMN instrument;
+ALTER TABLE weather DROP COLUMN batch_id;
+ALTER TABLE weather DROP COLUMN source;
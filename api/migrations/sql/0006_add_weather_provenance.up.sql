@@ -0,0 +1,11 @@
+ALTER TABLE weather ADD COLUMN source TEXT NOT NULL DEFAULT '';
+ALTER TABLE weather ADD COLUMN batch_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE weather ADD COLUMN instrument TEXT NOT NULL DEFAULT '';
+ALTER TABLE weather ADD COLUMN quality TEXT NOT NULL DEFAULT 'raw';
+
+ALTER TABLE weather_revisions ADD COLUMN before_quality TEXT;
+ALTER TABLE weather_revisions ADD COLUMN after_quality TEXT;
+
+-- every record was raw before quality states were introduced
+UPDATE weather_revisions SET before_quality = 'raw' WHERE operation <> 'create';
+UPDATE weather_revisions SET after_quality = 'raw' WHERE operation <> 'delete';
@@ -0,0 +1,3 @@
+CREATE INDEX idx_weather_deleted_at ON weather (deleted_at);
+
+DROP INDEX idx_weather_month_day;
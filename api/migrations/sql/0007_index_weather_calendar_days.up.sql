@@ -0,0 +1,5 @@
+-- the calendar day (MM-DD) of the dates, which selects the records of the same days across years
+CREATE INDEX idx_weather_month_day ON weather ((SUBSTR(recorded_at, 6, 5))) WHERE deleted_at IS NULL;
+
+-- nearly every record matches deleted_at IS NULL, without statistics SQLite preferred this index to those of the dates
+DROP INDEX idx_weather_deleted_at;
@@ -0,0 +1,6 @@
+ALTER TABLE weather_revisions DROP COLUMN after_instrument;
+ALTER TABLE weather_revisions DROP COLUMN after_batch_id;
+ALTER TABLE weather_revisions DROP COLUMN after_source;
+ALTER TABLE weather_revisions DROP COLUMN before_instrument;
+ALTER TABLE weather_revisions DROP COLUMN before_batch_id;
+ALTER TABLE weather_revisions DROP COLUMN before_source;
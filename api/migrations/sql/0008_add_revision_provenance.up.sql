@@ -0,0 +1,13 @@
+ALTER TABLE weather_revisions ADD COLUMN before_source TEXT;
+ALTER TABLE weather_revisions ADD COLUMN before_batch_id TEXT;
+ALTER TABLE weather_revisions ADD COLUMN before_instrument TEXT;
+ALTER TABLE weather_revisions ADD COLUMN after_source TEXT;
+ALTER TABLE weather_revisions ADD COLUMN after_batch_id TEXT;
+ALTER TABLE weather_revisions ADD COLUMN after_instrument TEXT;
+
+-- the provenance of earlier changes was not recorded, the latest revision of every record holds its current provenance
+UPDATE weather_revisions SET
+    after_source = (SELECT source FROM weather WHERE weather.id = weather_revisions.weather_id),
+    after_batch_id = (SELECT batch_id FROM weather WHERE weather.id = weather_revisions.weather_id),
+    after_instrument = (SELECT instrument FROM weather WHERE weather.id = weather_revisions.weather_id)
+WHERE operation <> 'delete' AND id IN (SELECT MAX(id) FROM weather_revisions GROUP BY weather_id);
@@ -0,0 +1,17 @@
+-- keys are scoped to the token that sent them, so tokens can choose the same key. The stored keys do not name their token,
+-- and are only kept for IDEMPOTENCY_TTL anyway, so they are dropped.
+DROP TABLE idempotency_keys;
+
+CREATE TABLE idempotency_keys (
+    actor TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status INTEGER,
+    content_type TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (actor, idempotency_key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// IdempotencyKey is claimed by the first write request with the key, and holds its response once it completed
+type IdempotencyKey struct {
+	// keys are scoped to the token that sent them, see repository.Author
+	Actor          string `gorm:"primarykey"`
+	IdempotencyKey string `gorm:"primarykey"`
+	// hash of the method, path and body of the first request
+	Fingerprint string
+	// nil while the first request is handled
+	Status      *int
+	ContentType string
+	Body        string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (k IdempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
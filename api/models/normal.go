@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// WeatherNormal is the climatological baseline of a single measurement for a day of the year
+type WeatherNormal struct {
+	ID        uint   `gorm:"primarykey"`
+	DayOfYear int    `gorm:"uniqueIndex:idx_weather_normals_day_field"`
+	Field     string `gorm:"uniqueIndex:idx_weather_normals_day_field"`
+	Count     int
+	Mean      float64
+	P10       float64
+	P25       float64
+	P50       float64
+	P75       float64
+	P90       float64
+	UpdatedAt time.Time
+}
+
+func (w WeatherNormal) TableName() string {
+	return "weather_normals"
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// WeatherRevision records a change of a weather record. The values before the change are nil for creates, and the values
+// after the change are nil for deletes.
+type WeatherRevision struct {
+	ID         uint `gorm:"primarykey"`
+	WeatherID  uint
+	RecordedAt string
+	// create, update or delete
+	Operation         string
+	BeforeHumidity    *float64
+	BeforeTemperature *float64
+	AfterHumidity     *float64
+	AfterTemperature  *float64
+	BeforeQuality     *string
+	AfterQuality      *string
+	// provenance of the record before and after the change, nil for revisions made before it was recorded
+	BeforeSource     *string
+	BeforeBatchID    *string
+	BeforeInstrument *string
+	AfterSource      *string
+	AfterBatchID     *string
+	AfterInstrument  *string
+	// who made the change, e.g. the id of an API token, and through what: api, ingest or admin
+	Actor     string
+	Source    string
+	CreatedAt time.Time
+}
+
+func (r WeatherRevision) TableName() string {
+	return "weather_revisions"
+}
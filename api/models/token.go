@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ApiToken is an API token created with `weatherapi tokens create`, only the SHA-256 hash of the secret is stored
+type ApiToken struct {
+	ID        uint `gorm:"primarykey"`
+	Name      string
+	TokenHash string
+	CreatedAt time.Time
+	// set when the token was revoked, revoked tokens are kept for auditing
+	RevokedAt *time.Time
+}
+
+func (t ApiToken) TableName() string {
+	return "api_tokens"
+}
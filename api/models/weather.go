@@ -1,12 +1,61 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
 
 type Weather struct {
 	gorm.Model
 	RecordedAt  string
 	Humidity    float64
 	Temperature float64
+	// set on records generated by gap filling, never persisted
+	Synthetic bool `gorm:"-"`
+	// whether the record was flagged by anomaly detection on ingest, and for which measurements (comma separated)
+	Anomaly       bool
+	AnomalyFields string
+	// where the values came from: the name of the source, the ingestion batch and the instrument, empty when unknown
+	Source     string
+	BatchID    string
+	Instrument string
+	// how far the values can be trusted, one of the Quality constants
+	Quality string
+}
+
+// Quality states of a record, records start as raw unless the writer says otherwise
+const (
+	QualityRaw       = "raw"
+	QualityValidated = "validated"
+	QualitySuspect   = "suspect"
+	QualityCorrected = "corrected"
+	QualityEstimated = "estimated"
+)
+
+// IsQuality reports whether the name is a quality state
+func IsQuality(name string) bool {
+	switch name {
+	case QualityRaw, QualityValidated, QualitySuspect, QualityCorrected, QualityEstimated:
+		return true
+	}
+	return false
+}
+
+// Measurement returns the value of the measurement with the given name (as configured in columns.yaml)
+func (w Weather) Measurement(name string) (float64, bool) {
+	field := reflect.ValueOf(w).FieldByName(name)
+	if !field.IsValid() || field.Kind() != reflect.Float64 {
+		return 0, false
+	}
+	return field.Float(), true
+}
+
+// SetMeasurement sets the value of the measurement with the given name, like Measurement reads it
+func (w *Weather) SetMeasurement(name string, value float64) {
+	if field := reflect.ValueOf(w).Elem().FieldByName(name); field.IsValid() && field.Kind() == reflect.Float64 {
+		field.SetFloat(value)
+	}
 }
 
 func (w Weather) TableName() string {
@@ -0,0 +1,321 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"weatherapi/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormWeatherRepository stores weather records in a Postgres or SQLite database.
+// Deleted records are soft deleted, and no longer returned by any query.
+type GormWeatherRepository struct {
+	db *gorm.DB
+}
+
+func NewGorm(db *gorm.DB) *GormWeatherRepository {
+	return &GormWeatherRepository{db: db}
+}
+
+// inRange restricts the query to the dates from and to the given bounds, empty bounds are left open
+func inRange(query *gorm.DB, from string, to string) *gorm.DB {
+	if from != "" {
+		query = query.Where("recorded_at >= ?", from)
+	}
+	if to != "" {
+		query = query.Where("recorded_at <= ?", to)
+	}
+	return query
+}
+
+func (r *GormWeatherRepository) GetByDate(ctx context.Context, date string) ([]models.Weather, error) {
+	var records []models.Weather
+	if err := r.db.WithContext(ctx).Where("recorded_at = ?", date).Order("id").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("error loading records: %v", err)
+	}
+	return records, nil
+}
+
+func (r *GormWeatherRepository) GetRange(ctx context.Context, from string, to string) ([]models.Weather, error) {
+	var records []models.Weather
+	if err := inRange(r.db.WithContext(ctx), from, to).Order("recorded_at").Order("id").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("error loading records: %v", err)
+	}
+	return records, nil
+}
+
+func (r *GormWeatherRepository) GetLastCreatedAt(ctx context.Context) (*time.Time, error) {
+	var records []models.Weather
+	if err := r.db.WithContext(ctx).Order("created_at DESC").Limit(1).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("error loading latest record: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0].CreatedAt, nil
+}
+
+func (r *GormWeatherRepository) GetByMonthDays(ctx context.Context, monthDays []string) ([]models.Weather, error) {
+	records := []models.Weather{}
+	if len(monthDays) == 0 {
+		return records, nil
+	}
+	// the calendar day is the MM-DD part of YYYY-MM-DD, SUBSTR counts from 1 in Postgres and SQLite
+	query := r.db.WithContext(ctx).Where("SUBSTR(recorded_at, 6, 5) IN ?", monthDays)
+	if err := query.Order("recorded_at").Order("id").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("error loading records: %v", err)
+	}
+	return records, nil
+}
+
+// onDateConflict targets the unique index of the dates of records that are not deleted
+var onDateConflict = clause.OnConflict{
+	Columns:     []clause.Column{{Name: "recorded_at"}},
+	TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "deleted_at IS NULL"}}},
+	DoNothing:   true,
+}
+
+// Create inserts the record unless its date is taken. The unique index detects the conflict, without an error that would abort
+// the surrounding transaction on Postgres.
+func (r *GormWeatherRepository) Create(ctx context.Context, record *models.Weather) error {
+	withDefaults(record)
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(onDateConflict).Create(record)
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return ErrDuplicateDate
+		} else if result.Error != nil {
+			return fmt.Errorf("error creating record: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrDuplicateDate
+		}
+		return addRevision(tx, newRevision(ctx, OperationCreate, nil, record))
+	})
+}
+
+// addRevision stores the revision of a change within the transaction of the change
+func addRevision(tx *gorm.DB, revision models.WeatherRevision) error {
+	if err := tx.Create(&revision).Error; err != nil {
+		return fmt.Errorf("error recording revision: %v", err)
+	}
+	return nil
+}
+
+// upsertColumns are the columns an upsert always replaces, the update time is that of the inserted record
+var upsertColumns = []string{"humidity", "temperature", "anomaly", "anomaly_fields", "updated_at"}
+
+// provenanceSet returns the provenance columns the record sets. An upsert only replaces those, and keeps the stored values of the others.
+func provenanceSet(record *models.Weather) []string {
+	var columns []string
+	fields := []struct{ column, value string }{{"source", record.Source}, {"batch_id", record.BatchID}, {"instrument", record.Instrument}}
+	for _, field := range fields {
+		if field.value != "" {
+			columns = append(columns, field.column)
+		}
+	}
+	return columns
+}
+
+// valuesChanged compares the measurements of the stored record with the inserted ones, excluded holds the inserted values
+const valuesChanged = "(weather.humidity <> excluded.humidity OR weather.temperature <> excluded.temperature)"
+
+// recordChanged keeps an upsert from updating a record to the values it already has.
+// The provenance only counts when the record sets it, a new batch alone is no change.
+func recordChanged(provenance []string) string {
+	conditions := []string{valuesChanged}
+	for _, column := range provenance {
+		if column != "batch_id" {
+			conditions = append(conditions, fmt.Sprintf("weather.%[1]s <> excluded.%[1]s", column))
+		}
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// onDateUpsert updates the record of the date under the given condition instead of inserting a second one.
+// New values are unreviewed, they get the quality of the write (raw unless it sets one), the quality of the stored values
+// is only changed by reviews.
+func onDateUpsert(condition string, provenance []string) clause.OnConflict {
+	assignments := clause.AssignmentColumns(append(slices.Clone(upsertColumns), provenance...))
+	assignments = append(assignments, clause.Assignment{
+		Column: clause.Column{Name: "quality"},
+		Value:  clause.Expr{SQL: "CASE WHEN " + valuesChanged + " THEN excluded.quality ELSE weather.quality END"},
+	})
+	return clause.OnConflict{
+		Columns:     onDateConflict.Columns,
+		TargetWhere: onDateConflict.TargetWhere,
+		DoUpdates:   assignments,
+		Where:       clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: condition}}},
+	}
+}
+
+// Upsert writes the record with a single INSERT ... ON CONFLICT statement. The record of the date is looked up beforehand within
+// the same transaction, which tells an insert from an update as both affect a single row.
+func (r *GormWeatherRepository) Upsert(ctx context.Context, record *models.Weather, strategy ConflictStrategy) (UpsertOutcome, error) {
+	provenance := provenanceSet(record)
+	withDefaults(record)
+
+	var conflict clause.OnConflict
+	switch strategy {
+	case ConflictError, ConflictSkip:
+		conflict = onDateConflict
+	case ConflictOverwrite:
+		conflict = onDateUpsert(recordChanged(provenance), provenance)
+	case ConflictKeepNewer:
+		conflict = onDateUpsert("weather.updated_at < excluded.updated_at AND "+recordChanged(provenance), provenance)
+	default:
+		return "", fmt.Errorf("unknown conflict strategy: %q", strategy)
+	}
+
+	var outcome UpsertOutcome
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var stored []models.Weather
+		if err := tx.Where("recorded_at = ?", record.RecordedAt).Limit(1).Find(&stored).Error; err != nil {
+			return fmt.Errorf("error loading record: %v", err)
+		}
+
+		result := tx.Clauses(conflict).Create(record)
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return ErrDuplicateDate
+		} else if result.Error != nil {
+			return fmt.Errorf("error upserting record: %v", result.Error)
+		}
+		if len(stored) == 0 {
+			// RowsAffected is 0 when a concurrent insert took the date since
+			if result.RowsAffected == 0 {
+				return ErrDuplicateDate
+			}
+			outcome = Inserted
+			return addRevision(tx, newRevision(ctx, OperationCreate, nil, record))
+		}
+		if result.RowsAffected == 0 {
+			if strategy == ConflictError {
+				return ErrDuplicateDate
+			}
+			outcome = Unchanged
+			*record = stored[0]
+			return nil
+		}
+
+		// the creation time is not part of the update
+		var updated models.Weather
+		if err := tx.First(&updated, stored[0].ID).Error; err != nil {
+			return fmt.Errorf("error loading record: %v", err)
+		}
+		outcome = Updated
+		*record = updated
+		return addRevision(tx, newRevision(ctx, OperationUpdate, &stored[0], record))
+	})
+	if err != nil {
+		return "", err
+	}
+	return outcome, nil
+}
+
+func (r *GormWeatherRepository) Update(ctx context.Context, record *models.Weather) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var before []models.Weather
+		if err := tx.Limit(1).Find(&before, record.ID).Error; err != nil {
+			return fmt.Errorf("error loading record: %v", err)
+		}
+		if len(before) == 0 {
+			return ErrNotFound
+		}
+
+		result := tx.Model(record).Select("*").Omit("id", "created_at", "deleted_at").Updates(record)
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return ErrDuplicateDate
+		} else if result.Error != nil {
+			return fmt.Errorf("error updating record: %v", result.Error)
+		}
+		// the creation time is not part of the update
+		if err := tx.First(record, record.ID).Error; err != nil {
+			return fmt.Errorf("error loading record: %v", err)
+		}
+		return addRevision(tx, newRevision(ctx, OperationUpdate, &before[0], record))
+	})
+}
+
+func (r *GormWeatherRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var before []models.Weather
+		if err := tx.Limit(1).Find(&before, id).Error; err != nil {
+			return fmt.Errorf("error loading record: %v", err)
+		}
+		if len(before) == 0 {
+			return ErrNotFound
+		}
+		if err := tx.Delete(&models.Weather{}, id).Error; err != nil {
+			return fmt.Errorf("error deleting record: %v", err)
+		}
+		return addRevision(tx, newRevision(ctx, OperationDelete, &before[0], nil))
+	})
+}
+
+func (r *GormWeatherRepository) GetRevisions(ctx context.Context, date string) ([]models.WeatherRevision, error) {
+	var revisions []models.WeatherRevision
+	// the ids follow the order of the changes, unlike the timestamps SQLite compares as text (see GetRangeAsOf)
+	if err := r.db.WithContext(ctx).Where("recorded_at = ?", date).Order("id").Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("error loading revisions: %v", err)
+	}
+	return revisions, nil
+}
+
+func (r *GormWeatherRepository) GetRangeAsOf(ctx context.Context, from string, to string, asOf time.Time) ([]models.Weather, error) {
+	var revisions []models.WeatherRevision
+	// the ids follow the order of the changes. SQLite compares timestamps as text, which differs from their order when
+	// they were stored with different offsets (e.g. records created before timestamps were stored in UTC), so the parsed
+	// times of the revisions are compared instead
+	query := inRange(r.db.WithContext(ctx), from, to)
+	if err := query.Order("recorded_at").Order("id").Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("error loading revisions: %v", err)
+	}
+	revisions = slices.DeleteFunc(revisions, func(revision models.WeatherRevision) bool {
+		return revision.CreatedAt.After(asOf)
+	})
+	return replayRevisions(revisions), nil
+}
+
+func (r *GormWeatherRepository) Aggregate(ctx context.Context, measurement string, from string, to string) (Aggregate, error) {
+	return r.aggregate(ctx, measurement, func(query *gorm.DB) *gorm.DB {
+		return inRange(query, from, to)
+	})
+}
+
+func (r *GormWeatherRepository) AggregateMonthDays(ctx context.Context, measurement string, monthDays []string, exclude string) (Aggregate, error) {
+	// gorm matches an empty list with IN (NULL), which selects no records
+	return r.aggregate(ctx, measurement, func(query *gorm.DB) *gorm.DB {
+		return query.Where("SUBSTR(recorded_at, 6, 5) IN ?", monthDays).Where("recorded_at <> ?", exclude)
+	})
+}
+
+// aggregate summarizes the measurement over the records selected by the scope
+func (r *GormWeatherRepository) aggregate(ctx context.Context, measurement string, scope func(query *gorm.DB) *gorm.DB) (Aggregate, error) {
+	if !isMeasurement(measurement) {
+		return Aggregate{}, fmt.Errorf("%w: %s", ErrUnknownMeasurement, measurement)
+	}
+	db := r.db.WithContext(ctx)
+	column := db.NamingStrategy.ColumnName("", measurement)
+
+	var count int
+	var minimum, maximum, mean, sum sql.NullFloat64
+	row := scope(db.Model(&models.Weather{})).
+		Select(fmt.Sprintf("COUNT(%[1]s), MIN(%[1]s), MAX(%[1]s), AVG(%[1]s), SUM(%[1]s)", column)).
+		Row()
+	if err := row.Scan(&count, &minimum, &maximum, &mean, &sum); err != nil {
+		return Aggregate{}, fmt.Errorf("error aggregating records: %v", err)
+	}
+	return Aggregate{Count: count, Min: minimum.Float64, Max: maximum.Float64, Mean: mean.Float64, Sum: sum.Float64}, nil
+}
+
+func (r *GormWeatherRepository) Transaction(ctx context.Context, fn func(repo WeatherRepository) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&GormWeatherRepository{db: tx})
+	})
+}
@@ -0,0 +1,417 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"weatherapi/models"
+	"weatherapi/utils"
+)
+
+// memoryStore holds the records of a MemoryWeatherRepository, its methods expect the caller to hold the lock
+type memoryStore struct {
+	records map[uint]models.Weather
+	lastId  uint
+	// ordered by the time of the change, only ever appended to
+	revisions []models.WeatherRevision
+	clock     utils.Clock
+}
+
+// now is the time of the timestamps the store sets, in UTC like the timestamps gorm sets
+func (s *memoryStore) now() time.Time {
+	return s.clock.Now().UTC()
+}
+
+// addRevision records a change, revisions are numbered in order
+func (s *memoryStore) addRevision(revision models.WeatherRevision) {
+	revision.ID = uint(len(s.revisions) + 1)
+	revision.CreatedAt = s.now()
+	s.revisions = append(s.revisions, revision)
+}
+
+func (s *memoryStore) getByDate(date string) []models.Weather {
+	records := []models.Weather{}
+	for _, record := range s.records {
+		if record.RecordedAt == date {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+func (s *memoryStore) getRange(from string, to string) []models.Weather {
+	records := []models.Weather{}
+	for _, record := range s.records {
+		if (from == "" || record.RecordedAt >= from) && (to == "" || record.RecordedAt <= to) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].RecordedAt != records[j].RecordedAt {
+			return records[i].RecordedAt < records[j].RecordedAt
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+func (s *memoryStore) getLastCreatedAt() *time.Time {
+	var last *time.Time
+	for _, record := range s.records {
+		if last == nil || record.CreatedAt.After(*last) {
+			createdAt := record.CreatedAt
+			last = &createdAt
+		}
+	}
+	return last
+}
+
+func (s *memoryStore) getByMonthDays(monthDays []string) []models.Weather {
+	records := []models.Weather{}
+	for _, record := range s.getRange("", "") {
+		if len(record.RecordedAt) >= 10 && slices.Contains(monthDays, record.RecordedAt[5:10]) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// hasDate reports whether a record other than the one with the given id exists for the date
+func (s *memoryStore) hasDate(date string, id uint) bool {
+	for _, record := range s.records {
+		if record.RecordedAt == date && record.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) create(ctx context.Context, record *models.Weather) error {
+	if s.hasDate(record.RecordedAt, 0) {
+		return ErrDuplicateDate
+	}
+	withDefaults(record)
+	s.lastId++
+	now := s.now()
+	record.ID = s.lastId
+	record.CreatedAt = now
+	// like gorm, an update time set by the caller is kept
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = now
+	}
+	s.records[record.ID] = *record
+	s.addRevision(newRevision(ctx, OperationCreate, nil, record))
+	return nil
+}
+
+func (s *memoryStore) upsert(ctx context.Context, record *models.Weather, strategy ConflictStrategy) (UpsertOutcome, error) {
+	switch strategy {
+	case ConflictError, ConflictSkip, ConflictOverwrite, ConflictKeepNewer:
+	default:
+		return "", fmt.Errorf("unknown conflict strategy: %q", strategy)
+	}
+
+	stored := s.getByDate(record.RecordedAt)
+	if len(stored) == 0 {
+		if err := s.create(ctx, record); err != nil {
+			return "", err
+		}
+		return Inserted, nil
+	}
+
+	existing := stored[0]
+	if strategy == ConflictError {
+		return "", ErrDuplicateDate
+	}
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = s.now()
+	}
+	// like recordChanged, the provenance only counts when the record sets it
+	valuesChanged := existing.Humidity != record.Humidity || existing.Temperature != record.Temperature
+	unchanged := !valuesChanged &&
+		(record.Source == "" || record.Source == existing.Source) &&
+		(record.Instrument == "" || record.Instrument == existing.Instrument)
+	if strategy == ConflictSkip || unchanged || (strategy == ConflictKeepNewer && !existing.UpdatedAt.Before(record.UpdatedAt)) {
+		*record = existing
+		return Unchanged, nil
+	}
+
+	// like provenanceSet, the provenance is only replaced where the record sets it
+	updated := existing
+	updated.Humidity = record.Humidity
+	updated.Temperature = record.Temperature
+	updated.Anomaly = record.Anomaly
+	updated.AnomalyFields = record.AnomalyFields
+	updated.UpdatedAt = record.UpdatedAt
+	for _, field := range []struct{ stored, written *string }{
+		{&updated.Source, &record.Source}, {&updated.BatchID, &record.BatchID}, {&updated.Instrument, &record.Instrument},
+	} {
+		if *field.written != "" {
+			*field.stored = *field.written
+		}
+	}
+	// like onDateUpsert, new values get the quality of the write
+	if valuesChanged {
+		updated.Quality = record.Quality
+		withDefaults(&updated)
+	}
+	s.records[updated.ID] = updated
+	s.addRevision(newRevision(ctx, OperationUpdate, &existing, &updated))
+	*record = updated
+	return Updated, nil
+}
+
+func (s *memoryStore) update(ctx context.Context, record *models.Weather) error {
+	existing, ok := s.records[record.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.hasDate(record.RecordedAt, record.ID) {
+		return ErrDuplicateDate
+	}
+	record.CreatedAt = existing.CreatedAt
+	record.UpdatedAt = s.now()
+	s.records[record.ID] = *record
+	s.addRevision(newRevision(ctx, OperationUpdate, &existing, record))
+	return nil
+}
+
+func (s *memoryStore) delete(ctx context.Context, id uint) error {
+	existing, ok := s.records[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.records, id)
+	s.addRevision(newRevision(ctx, OperationDelete, &existing, nil))
+	return nil
+}
+
+func (s *memoryStore) getRevisions(date string) []models.WeatherRevision {
+	revisions := []models.WeatherRevision{}
+	for _, revision := range s.revisions {
+		if revision.RecordedAt == date {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions
+}
+
+func (s *memoryStore) getRangeAsOf(from string, to string, asOf time.Time) []models.Weather {
+	var revisions []models.WeatherRevision
+	for _, revision := range s.revisions {
+		if (from == "" || revision.RecordedAt >= from) && (to == "" || revision.RecordedAt <= to) && !revision.CreatedAt.After(asOf) {
+			revisions = append(revisions, revision)
+		}
+	}
+	// the revisions of a date stay in the order of their changes
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].RecordedAt < revisions[j].RecordedAt
+	})
+	return replayRevisions(revisions)
+}
+
+// aggregate summarizes the measurement over the records
+func aggregate(measurement string, records []models.Weather) (Aggregate, error) {
+	if !isMeasurement(measurement) {
+		return Aggregate{}, fmt.Errorf("%w: %s", ErrUnknownMeasurement, measurement)
+	}
+
+	var aggregate Aggregate
+	for _, record := range records {
+		value, _ := record.Measurement(measurement)
+		if aggregate.Count == 0 {
+			aggregate.Min, aggregate.Max = value, value
+		}
+		aggregate.Count++
+		aggregate.Min = min(aggregate.Min, value)
+		aggregate.Max = max(aggregate.Max, value)
+		aggregate.Sum += value
+	}
+	if aggregate.Count > 0 {
+		aggregate.Mean = aggregate.Sum / float64(aggregate.Count)
+	}
+	return aggregate, nil
+}
+
+func (s *memoryStore) aggregate(measurement string, from string, to string) (Aggregate, error) {
+	return aggregate(measurement, s.getRange(from, to))
+}
+
+func (s *memoryStore) aggregateMonthDays(measurement string, monthDays []string, exclude string) (Aggregate, error) {
+	var records []models.Weather
+	for _, record := range s.getByMonthDays(monthDays) {
+		if record.RecordedAt != exclude {
+			records = append(records, record)
+		}
+	}
+	return aggregate(measurement, records)
+}
+
+// transaction runs fn on the store, and restores the records it had before when fn fails
+func (s *memoryStore) transaction(fn func(repo WeatherRepository) error) error {
+	records := maps.Clone(s.records)
+	lastId := s.lastId
+	revisions := len(s.revisions)
+	if err := fn(&memoryTransaction{store: s}); err != nil {
+		s.records = records
+		s.lastId = lastId
+		s.revisions = s.revisions[:revisions]
+		return err
+	}
+	return nil
+}
+
+// MemoryWeatherRepository keeps weather records in memory, for tests and ephemeral deployments.
+// It is safe for concurrent use, transactions hold the lock until they complete.
+type MemoryWeatherRepository struct {
+	mu    sync.RWMutex
+	store memoryStore
+}
+
+// NewMemory creates an empty repository, whose timestamps are taken from the clock
+func NewMemory(clock utils.Clock) *MemoryWeatherRepository {
+	return &MemoryWeatherRepository{store: memoryStore{records: map[uint]models.Weather{}, clock: clock}}
+}
+
+func (r *MemoryWeatherRepository) GetByDate(ctx context.Context, date string) ([]models.Weather, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.getByDate(date), nil
+}
+
+func (r *MemoryWeatherRepository) GetRange(ctx context.Context, from string, to string) ([]models.Weather, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.getRange(from, to), nil
+}
+
+func (r *MemoryWeatherRepository) GetLastCreatedAt(ctx context.Context) (*time.Time, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.getLastCreatedAt(), nil
+}
+
+func (r *MemoryWeatherRepository) GetByMonthDays(ctx context.Context, monthDays []string) ([]models.Weather, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.getByMonthDays(monthDays), nil
+}
+
+func (r *MemoryWeatherRepository) Create(ctx context.Context, record *models.Weather) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store.create(ctx, record)
+}
+
+func (r *MemoryWeatherRepository) Upsert(ctx context.Context, record *models.Weather, strategy ConflictStrategy) (UpsertOutcome, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store.upsert(ctx, record, strategy)
+}
+
+func (r *MemoryWeatherRepository) Update(ctx context.Context, record *models.Weather) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store.update(ctx, record)
+}
+
+func (r *MemoryWeatherRepository) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store.delete(ctx, id)
+}
+
+func (r *MemoryWeatherRepository) GetRevisions(ctx context.Context, date string) ([]models.WeatherRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.getRevisions(date), nil
+}
+
+func (r *MemoryWeatherRepository) GetRangeAsOf(ctx context.Context, from string, to string, asOf time.Time) ([]models.Weather, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.getRangeAsOf(from, to, asOf), nil
+}
+
+func (r *MemoryWeatherRepository) Aggregate(ctx context.Context, measurement string, from string, to string) (Aggregate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.aggregate(measurement, from, to)
+}
+
+func (r *MemoryWeatherRepository) AggregateMonthDays(ctx context.Context, measurement string, monthDays []string, exclude string) (Aggregate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.store.aggregateMonthDays(measurement, monthDays, exclude)
+}
+
+func (r *MemoryWeatherRepository) Transaction(ctx context.Context, fn func(repo WeatherRepository) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store.transaction(fn)
+}
+
+// memoryTransaction is the repository passed to the function of a transaction, which already holds the lock
+type memoryTransaction struct {
+	store *memoryStore
+}
+
+func (t *memoryTransaction) GetByDate(ctx context.Context, date string) ([]models.Weather, error) {
+	return t.store.getByDate(date), nil
+}
+
+func (t *memoryTransaction) GetRange(ctx context.Context, from string, to string) ([]models.Weather, error) {
+	return t.store.getRange(from, to), nil
+}
+
+func (t *memoryTransaction) GetLastCreatedAt(ctx context.Context) (*time.Time, error) {
+	return t.store.getLastCreatedAt(), nil
+}
+
+func (t *memoryTransaction) GetByMonthDays(ctx context.Context, monthDays []string) ([]models.Weather, error) {
+	return t.store.getByMonthDays(monthDays), nil
+}
+
+func (t *memoryTransaction) Create(ctx context.Context, record *models.Weather) error {
+	return t.store.create(ctx, record)
+}
+
+func (t *memoryTransaction) Upsert(ctx context.Context, record *models.Weather, strategy ConflictStrategy) (UpsertOutcome, error) {
+	return t.store.upsert(ctx, record, strategy)
+}
+
+func (t *memoryTransaction) Update(ctx context.Context, record *models.Weather) error {
+	return t.store.update(ctx, record)
+}
+
+func (t *memoryTransaction) Delete(ctx context.Context, id uint) error {
+	return t.store.delete(ctx, id)
+}
+
+func (t *memoryTransaction) GetRevisions(ctx context.Context, date string) ([]models.WeatherRevision, error) {
+	return t.store.getRevisions(date), nil
+}
+
+func (t *memoryTransaction) GetRangeAsOf(ctx context.Context, from string, to string, asOf time.Time) ([]models.Weather, error) {
+	return t.store.getRangeAsOf(from, to, asOf), nil
+}
+
+func (t *memoryTransaction) Aggregate(ctx context.Context, measurement string, from string, to string) (Aggregate, error) {
+	return t.store.aggregate(measurement, from, to)
+}
+
+func (t *memoryTransaction) AggregateMonthDays(ctx context.Context, measurement string, monthDays []string, exclude string) (Aggregate, error) {
+	return t.store.aggregateMonthDays(measurement, monthDays, exclude)
+}
+
+// Transaction nests within the surrounding transaction, only its own changes are undone when fn fails
+func (t *memoryTransaction) Transaction(ctx context.Context, fn func(repo WeatherRepository) error) error {
+	return t.store.transaction(fn)
+}
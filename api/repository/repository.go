@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"weatherapi/models"
+)
+
+var (
+	ErrNotFound           = errors.New("record not found")
+	ErrDuplicateDate      = errors.New("record already exists for date")
+	ErrUnknownMeasurement = errors.New("unknown measurement")
+)
+
+// Aggregate summarizes the values of a measurement, all values are 0 when Count is 0
+type Aggregate struct {
+	Count int
+	Min   float64
+	Max   float64
+	Mean  float64
+	Sum   float64
+}
+
+// ConflictStrategy decides how a record is written when its date already has one
+type ConflictStrategy string
+
+const (
+	// ConflictError fails with ErrDuplicateDate
+	ConflictError ConflictStrategy = "error"
+	// ConflictSkip keeps the stored record
+	ConflictSkip ConflictStrategy = "skip"
+	// ConflictOverwrite replaces the measurements of the stored record
+	ConflictOverwrite ConflictStrategy = "overwrite"
+	// ConflictKeepNewer replaces the measurements when the record was updated after the stored record
+	ConflictKeepNewer ConflictStrategy = "keep_newer"
+)
+
+// ParseConflictStrategy returns the strategy of the name, the empty name is ConflictError
+func ParseConflictStrategy(name string) (ConflictStrategy, bool) {
+	switch strategy := ConflictStrategy(name); strategy {
+	case "":
+		return ConflictError, true
+	case ConflictError, ConflictSkip, ConflictOverwrite, ConflictKeepNewer:
+		return strategy, true
+	}
+	return "", false
+}
+
+// UpsertOutcome tells what an upsert did to the stored records
+type UpsertOutcome string
+
+const (
+	Inserted  UpsertOutcome = "inserted"
+	Updated   UpsertOutcome = "updated"
+	Unchanged UpsertOutcome = "unchanged"
+)
+
+// WeatherRepository stores weather records. Dates are compared as strings, which orders them chronologically
+// in the YYYY-MM-DD format of columns.yaml. Measurements are named like the fields of models.Weather (e.g. Temperature).
+// Every create, update and delete is recorded as a revision of the record, by the Author of the context.
+type WeatherRepository interface {
+	// GetByDate returns the record of the date, or no records when there is none
+	GetByDate(ctx context.Context, date string) ([]models.Weather, error)
+	// GetRange returns the records from and to the given dates inclusive, ordered by date and id.
+	// An empty bound leaves the range open on that side.
+	GetRange(ctx context.Context, from string, to string) ([]models.Weather, error)
+	// GetLastCreatedAt returns the creation time of the record created last, nil when there are no records
+	GetLastCreatedAt(ctx context.Context) (*time.Time, error)
+	// GetByMonthDays returns the records of every year whose date falls on one of the calendar days, given as MM-DD,
+	// ordered like GetRange
+	GetByMonthDays(ctx context.Context, monthDays []string) ([]models.Weather, error)
+	// Create stores the record, and sets its id and timestamps. Records without a quality are stored as models.QualityRaw.
+	// Every date has at most one record, ErrDuplicateDate is returned when the date already has one.
+	Create(ctx context.Context, record *models.Weather) error
+	// Upsert creates the record, or resolves the conflict with the record of its date by the strategy. An update replaces the
+	// measurements, anomaly flags, provenance and update time. It is skipped unless the measurements change, or a source or
+	// instrument the record sets differs from the stored one. New measurements get the quality of the record, or
+	// models.QualityRaw, the quality of unchanged measurements is kept. A zero update time of the record is set to the current
+	// time. Afterwards the record holds the stored values.
+	Upsert(ctx context.Context, record *models.Weather, strategy ConflictStrategy) (UpsertOutcome, error)
+	// Update replaces the values of the stored record with the same id, and sets the timestamps of the record
+	Update(ctx context.Context, record *models.Weather) error
+	// Delete removes the record with the given id
+	Delete(ctx context.Context, id uint) error
+	// GetRevisions returns the revisions of the records of the date, oldest first
+	GetRevisions(ctx context.Context, date string) ([]models.WeatherRevision, error)
+	// GetRangeAsOf returns the records of the range like GetRange, with the values they had at the given time.
+	// The records are reconstructed from their revisions, their update time is that of the revision.
+	GetRangeAsOf(ctx context.Context, from string, to string, asOf time.Time) ([]models.Weather, error)
+	// Aggregate summarizes the measurement over the records from and to the given dates, with the same bounds as GetRange
+	Aggregate(ctx context.Context, measurement string, from string, to string) (Aggregate, error)
+	// AggregateMonthDays summarizes the measurement over the records GetByMonthDays returns, leaving out the record of the
+	// excluded date
+	AggregateMonthDays(ctx context.Context, measurement string, monthDays []string, exclude string) (Aggregate, error)
+	// Transaction runs fn on a repository whose changes are only kept when fn returns nil
+	Transaction(ctx context.Context, fn func(repo WeatherRepository) error) error
+}
+
+// Merge returns the aggregate of the records of both aggregates
+func (a Aggregate) Merge(other Aggregate) Aggregate {
+	if a.Count == 0 {
+		return other
+	}
+	if other.Count == 0 {
+		return a
+	}
+	merged := Aggregate{Count: a.Count + other.Count, Min: min(a.Min, other.Min), Max: max(a.Max, other.Max), Sum: a.Sum + other.Sum}
+	merged.Mean = merged.Sum / float64(merged.Count)
+	return merged
+}
+
+// withDefaults sets the quality of a record that has none
+func withDefaults(record *models.Weather) {
+	if record.Quality == "" {
+		record.Quality = models.QualityRaw
+	}
+}
+
+// isMeasurement reports whether the name is a measurement field of models.Weather
+func isMeasurement(name string) bool {
+	_, ok := models.Weather{}.Measurement(name)
+	return ok
+}
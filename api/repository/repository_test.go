@@ -0,0 +1,604 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"weatherapi/migrations"
+	"weatherapi/models"
+	"weatherapi/server"
+	"weatherapi/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDatabases atomic.Int64
+
+func TestGormWeatherRepository(t *testing.T) {
+	testWeatherRepository(t, func(t *testing.T) WeatherRepository {
+		db, err := server.OpenDb(fmt.Sprintf("sqlite://file:repository-%d?mode=memory&cache=shared", testDatabases.Add(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { server.CloseDb(db) })
+		// SQLite locks the tables of a shared in-memory database for concurrent writers
+		sqlDb, _ := db.DB()
+		sqlDb.SetMaxOpenConns(1)
+		if _, err := migrations.Up(context.Background(), db); err != nil {
+			t.Fatal(err)
+		}
+		return NewGorm(db)
+	})
+}
+
+func TestGormRevisionTimes(t *testing.T) {
+	ctx := context.Background()
+	db, err := server.OpenDb(fmt.Sprintf("sqlite://file:repository-%d?mode=memory&cache=shared", testDatabases.Add(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.CloseDb(db) })
+	if _, err := migrations.Up(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewGorm(db)
+
+	t.Run("reconstructs records whose revisions were stored with another offset", func(t *testing.T) {
+		assert.Nil(t, repo.Create(ctx, &models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}))
+		// 10:00 UTC, which SQLite stores as text that sorts after 11:00 UTC
+		created := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		assert.Nil(t, db.Model(&models.WeatherRevision{}).Where("recorded_at = ?", "2025-01-01").Update("created_at", created).Error)
+
+		records, err := repo.GetRangeAsOf(ctx, "", "", time.Date(2025, time.January, 1, 11, 0, 0, 0, time.UTC))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(records))
+		records, err = repo.GetRangeAsOf(ctx, "", "", time.Date(2025, time.January, 1, 9, 0, 0, 0, time.UTC))
+		assert.Nil(t, err)
+		assert.Empty(t, records)
+	})
+}
+
+func TestMemoryWeatherRepository(t *testing.T) {
+	testWeatherRepository(t, func(t *testing.T) WeatherRepository {
+		return NewMemory(utils.SystemClock{})
+	})
+}
+
+func TestMemoryTimestamps(t *testing.T) {
+	// like gorm, the timestamps are taken from the clock in UTC
+	now := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.FixedZone("CET", 60*60))
+	repo := NewMemory(utils.FixedClock{Time: now})
+	ctx := context.Background()
+
+	record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+	assert.Nil(t, repo.Create(ctx, &record))
+	assert.Equal(t, now.UTC(), record.CreatedAt)
+	assert.Equal(t, time.UTC, record.UpdatedAt.Location())
+
+	revisions, err := repo.GetRevisions(ctx, "2025-01-01")
+	assert.Nil(t, err)
+	assert.Equal(t, now.UTC(), revisions[0].CreatedAt)
+
+	records, err := repo.GetRangeAsOf(ctx, "", "", now.Add(-time.Second))
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+	records, err = repo.GetRangeAsOf(ctx, "", "", now)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+}
+
+// testWeatherRepository is the conformance suite every WeatherRepository needs to pass
+func testWeatherRepository(t *testing.T, newRepository func(t *testing.T) WeatherRepository) {
+	ctx := context.Background()
+
+	seed := func(t *testing.T, repo WeatherRepository) {
+		for _, record := range []models.Weather{
+			{RecordedAt: "2025-01-03", Humidity: 70, Temperature: 14},
+			{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10},
+			{RecordedAt: "2025-01-04", Humidity: 55, Temperature: 11},
+			{RecordedAt: "2025-01-02", Humidity: 60, Temperature: 12},
+		} {
+			assert.Nil(t, repo.Create(ctx, &record))
+		}
+	}
+	dates := func(records []models.Weather) []string {
+		dates := []string{}
+		for _, record := range records {
+			dates = append(dates, record.RecordedAt)
+		}
+		return dates
+	}
+
+	t.Run("creates records with an id and timestamps", func(t *testing.T) {
+		repo := newRepository(t)
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		assert.Nil(t, repo.Create(ctx, &record))
+		assert.NotZero(t, record.ID)
+		assert.False(t, record.CreatedAt.IsZero())
+		assert.False(t, record.UpdatedAt.IsZero())
+
+		other := models.Weather{RecordedAt: "2025-01-02", Humidity: 60, Temperature: 12}
+		assert.Nil(t, repo.Create(ctx, &other))
+		assert.NotEqual(t, record.ID, other.ID)
+	})
+
+	t.Run("returns the creation time of the record created last", func(t *testing.T) {
+		repo := newRepository(t)
+
+		last, err := repo.GetLastCreatedAt(ctx)
+		assert.Nil(t, err)
+		assert.Nil(t, last)
+
+		first := models.Weather{RecordedAt: "2025-01-02", Humidity: 50, Temperature: 10}
+		assert.Nil(t, repo.Create(ctx, &first))
+		time.Sleep(time.Millisecond)
+		second := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}
+		assert.Nil(t, repo.Create(ctx, &second))
+
+		last, err = repo.GetLastCreatedAt(ctx)
+		assert.Nil(t, err)
+		assert.True(t, second.CreatedAt.Equal(*last))
+
+		// deleted records do not count
+		assert.Nil(t, repo.Delete(ctx, second.ID))
+		last, err = repo.GetLastCreatedAt(ctx)
+		assert.Nil(t, err)
+		assert.True(t, first.CreatedAt.Equal(*last))
+	})
+
+	t.Run("rejects a second record of a date", func(t *testing.T) {
+		repo := newRepository(t)
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		assert.Nil(t, repo.Create(ctx, &record))
+		err := repo.Create(ctx, &models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12})
+		assert.True(t, errors.Is(err, ErrDuplicateDate))
+
+		other := models.Weather{RecordedAt: "2025-01-02", Humidity: 60, Temperature: 12}
+		assert.Nil(t, repo.Create(ctx, &other))
+		other.RecordedAt = "2025-01-01"
+		assert.True(t, errors.Is(repo.Update(ctx, &other), ErrDuplicateDate))
+
+		// a deleted record frees its date
+		assert.Nil(t, repo.Delete(ctx, record.ID))
+		assert.Nil(t, repo.Update(ctx, &other))
+	})
+
+	t.Run("gets the record of a date", func(t *testing.T) {
+		repo := newRepository(t)
+		seed(t, repo)
+
+		records, err := repo.GetByDate(ctx, "2025-01-01")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(records))
+		assert.Equal(t, 50.0, records[0].Humidity)
+
+		records, err = repo.GetByDate(ctx, "2024-12-31")
+		assert.Nil(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("gets the records of an inclusive range ordered by date", func(t *testing.T) {
+		repo := newRepository(t)
+		seed(t, repo)
+
+		records, err := repo.GetRange(ctx, "2025-01-02", "2025-01-03")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2025-01-02", "2025-01-03"}, dates(records))
+
+		records, err = repo.GetRange(ctx, "", "2025-01-02")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2025-01-01", "2025-01-02"}, dates(records))
+
+		records, err = repo.GetRange(ctx, "2025-01-03", "")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2025-01-03", "2025-01-04"}, dates(records))
+
+		records, err = repo.GetRange(ctx, "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(records))
+
+		records, err = repo.GetRange(ctx, "2025-02-01", "2025-02-28")
+		assert.Nil(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("gets the records of calendar days across years", func(t *testing.T) {
+		repo := newRepository(t)
+		seed(t, repo)
+		assert.Nil(t, repo.Create(ctx, &models.Weather{RecordedAt: "2024-01-02", Humidity: 40, Temperature: 8}))
+
+		records, err := repo.GetByMonthDays(ctx, []string{"01-02", "01-04"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2024-01-02", "2025-01-02", "2025-01-04"}, dates(records))
+
+		records, err = repo.GetByMonthDays(ctx, nil)
+		assert.Nil(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("updates records by id", func(t *testing.T) {
+		repo := newRepository(t)
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		assert.Nil(t, repo.Create(ctx, &record))
+		createdAt := record.CreatedAt
+
+		update := models.Weather{RecordedAt: "2025-01-01", Humidity: 65, Temperature: 0, Anomaly: true, AnomalyFields: "humidity"}
+		update.ID = record.ID
+		assert.Nil(t, repo.Update(ctx, &update))
+		assert.True(t, createdAt.Equal(update.CreatedAt))
+		assert.False(t, update.UpdatedAt.Before(update.CreatedAt))
+
+		records, err := repo.GetByDate(ctx, "2025-01-01")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(records))
+		assert.Equal(t, 65.0, records[0].Humidity)
+		// zero values are stored too
+		assert.Equal(t, 0.0, records[0].Temperature)
+		assert.Equal(t, "humidity", records[0].AnomalyFields)
+
+		missing := models.Weather{RecordedAt: "2025-01-01"}
+		missing.ID = record.ID + 100
+		assert.True(t, errors.Is(repo.Update(ctx, &missing), ErrNotFound))
+	})
+
+	t.Run("upserts records by the conflict strategy", func(t *testing.T) {
+		repo := newRepository(t)
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		outcome, err := repo.Upsert(ctx, &record, ConflictError)
+		assert.Nil(t, err)
+		assert.Equal(t, Inserted, outcome)
+		assert.NotZero(t, record.ID)
+
+		_, err = repo.Upsert(ctx, &models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}, ConflictError)
+		assert.True(t, errors.Is(err, ErrDuplicateDate))
+
+		skipped := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}
+		outcome, err = repo.Upsert(ctx, &skipped, ConflictSkip)
+		assert.Nil(t, err)
+		assert.Equal(t, Unchanged, outcome)
+		assert.Equal(t, record.ID, skipped.ID)
+		assert.Equal(t, 50.0, skipped.Humidity)
+
+		overwrite := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12, Anomaly: true, AnomalyFields: "humidity"}
+		outcome, err = repo.Upsert(ctx, &overwrite, ConflictOverwrite)
+		assert.Nil(t, err)
+		assert.Equal(t, Updated, outcome)
+		assert.Equal(t, record.ID, overwrite.ID)
+		assert.True(t, record.CreatedAt.Equal(overwrite.CreatedAt))
+
+		// the same measurements are no change
+		same := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}
+		outcome, err = repo.Upsert(ctx, &same, ConflictOverwrite)
+		assert.Nil(t, err)
+		assert.Equal(t, Unchanged, outcome)
+		assert.Equal(t, "humidity", same.AnomalyFields)
+
+		records, err := repo.GetRange(ctx, "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(records))
+		assert.Equal(t, 12.0, records[0].Temperature)
+		assert.Equal(t, "humidity", records[0].AnomalyFields)
+	})
+
+	t.Run("upserts only newer records with keep_newer", func(t *testing.T) {
+		repo := newRepository(t)
+		updatedAt := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		record.UpdatedAt = updatedAt
+		_, err := repo.Upsert(ctx, &record, ConflictKeepNewer)
+		assert.Nil(t, err)
+		assert.True(t, updatedAt.Equal(record.UpdatedAt))
+
+		older := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}
+		older.UpdatedAt = updatedAt.Add(-time.Second)
+		outcome, err := repo.Upsert(ctx, &older, ConflictKeepNewer)
+		assert.Nil(t, err)
+		assert.Equal(t, Unchanged, outcome)
+		assert.Equal(t, 50.0, older.Humidity)
+
+		newer := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 12}
+		newer.UpdatedAt = updatedAt.Add(time.Second)
+		outcome, err = repo.Upsert(ctx, &newer, ConflictKeepNewer)
+		assert.Nil(t, err)
+		assert.Equal(t, Updated, outcome)
+		assert.Equal(t, 60.0, newer.Humidity)
+		assert.True(t, newer.UpdatedAt.Equal(updatedAt.Add(time.Second)))
+
+		// a record without an update time is newer than any stored one
+		current := models.Weather{RecordedAt: "2025-01-01", Humidity: 70, Temperature: 14}
+		outcome, err = repo.Upsert(ctx, &current, ConflictKeepNewer)
+		assert.Nil(t, err)
+		assert.Equal(t, Updated, outcome)
+	})
+
+	t.Run("keeps the provenance and quality of records", func(t *testing.T) {
+		repo := newRepository(t)
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10, Source: "station", BatchID: "a", Instrument: "hygrometer"}
+		_, err := repo.Upsert(ctx, &record, ConflictError)
+		assert.Nil(t, err)
+		assert.Equal(t, models.QualityRaw, record.Quality)
+
+		// a different batch or quality of the same values is no change, a different source is
+		same := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10, BatchID: "b", Quality: models.QualityEstimated}
+		outcome, err := repo.Upsert(ctx, &same, ConflictOverwrite)
+		assert.Nil(t, err)
+		assert.Equal(t, Unchanged, outcome)
+		assert.Equal(t, "a", same.BatchID)
+		assert.Equal(t, models.QualityRaw, same.Quality)
+
+		moved := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10, Quality: models.QualityEstimated, Source: "import", BatchID: "c"}
+		outcome, err = repo.Upsert(ctx, &moved, ConflictOverwrite)
+		assert.Nil(t, err)
+		assert.Equal(t, Updated, outcome)
+
+		records, err := repo.GetByDate(ctx, "2025-01-01")
+		assert.Nil(t, err)
+		assert.Equal(t, models.QualityRaw, records[0].Quality)
+		assert.Equal(t, "import", records[0].Source)
+		assert.Equal(t, "c", records[0].BatchID)
+
+		// new values keep the provenance the writer does not set, and get the quality it sets
+		estimated := models.Weather{RecordedAt: "2025-01-01", Humidity: 55, Temperature: 10, Instrument: "thermometer", Quality: models.QualityEstimated}
+		outcome, err = repo.Upsert(ctx, &estimated, ConflictOverwrite)
+		assert.Nil(t, err)
+		assert.Equal(t, Updated, outcome)
+		assert.Equal(t, models.QualityEstimated, estimated.Quality)
+		assert.Equal(t, "import", estimated.Source)
+		assert.Equal(t, "c", estimated.BatchID)
+		assert.Equal(t, "thermometer", estimated.Instrument)
+
+		revisions, err := repo.GetRevisions(ctx, "2025-01-01")
+		assert.Nil(t, err)
+		assert.Equal(t, models.QualityRaw, *revisions[2].BeforeQuality)
+		assert.Equal(t, models.QualityEstimated, *revisions[2].AfterQuality)
+
+		// reviewed quality does not carry over to new values, which are raw unless the writer sets a quality
+		reviewed := estimated
+		reviewed.Quality = models.QualityValidated
+		assert.Nil(t, repo.Update(ctx, &reviewed))
+		raw := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 10}
+		outcome, err = repo.Upsert(ctx, &raw, ConflictKeepNewer)
+		assert.Nil(t, err)
+		assert.Equal(t, Updated, outcome)
+		assert.Equal(t, models.QualityRaw, raw.Quality)
+		assert.Equal(t, "import", raw.Source)
+	})
+
+	t.Run("rejects an unknown conflict strategy", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.Upsert(ctx, &models.Weather{RecordedAt: "2025-01-01"}, "replace")
+		assert.NotNil(t, err)
+		records, _ := repo.GetRange(ctx, "", "")
+		assert.Empty(t, records)
+	})
+
+	t.Run("deletes records by id", func(t *testing.T) {
+		repo := newRepository(t)
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		assert.Nil(t, repo.Create(ctx, &record))
+		assert.Nil(t, repo.Delete(ctx, record.ID))
+
+		records, err := repo.GetRange(ctx, "", "")
+		assert.Nil(t, err)
+		assert.Empty(t, records)
+		assert.True(t, errors.Is(repo.Delete(ctx, record.ID), ErrNotFound))
+		assert.True(t, errors.Is(repo.Update(ctx, &record), ErrNotFound))
+	})
+
+	t.Run("records a revision of every change by the author of the context", func(t *testing.T) {
+		repo := newRepository(t)
+		authorCtx := WithAuthor(ctx, Author{Actor: "token:1", Source: SourceApi})
+
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10}
+		assert.Nil(t, repo.Create(authorCtx, &record))
+		update := models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 10}
+		_, err := repo.Upsert(ctx, &update, ConflictOverwrite)
+		assert.Nil(t, err)
+		// unchanged records have no revision
+		_, err = repo.Upsert(ctx, &models.Weather{RecordedAt: "2025-01-01", Humidity: 60, Temperature: 10}, ConflictOverwrite)
+		assert.Nil(t, err)
+		update.Temperature = 12
+		assert.Nil(t, repo.Update(ctx, &update))
+		assert.Nil(t, repo.Delete(WithAuthor(ctx, Author{Actor: "cli", Source: SourceAdmin}), record.ID))
+
+		revisions, err := repo.GetRevisions(ctx, "2025-01-01")
+		assert.Nil(t, err)
+		var operations []string
+		for _, revision := range revisions {
+			assert.Equal(t, record.ID, revision.WeatherID)
+			assert.False(t, revision.CreatedAt.IsZero())
+			operations = append(operations, revision.Operation)
+		}
+		assert.Equal(t, []string{OperationCreate, OperationUpdate, OperationUpdate, OperationDelete}, operations)
+
+		assert.Nil(t, revisions[0].BeforeHumidity)
+		assert.Equal(t, 50.0, *revisions[0].AfterHumidity)
+		assert.Equal(t, "token:1", revisions[0].Actor)
+		assert.Equal(t, SourceApi, revisions[0].Source)
+		assert.Equal(t, 50.0, *revisions[1].BeforeHumidity)
+		assert.Equal(t, 60.0, *revisions[1].AfterHumidity)
+		assert.Equal(t, 12.0, *revisions[2].AfterTemperature)
+		assert.Equal(t, 12.0, *revisions[3].BeforeTemperature)
+		assert.Nil(t, revisions[3].AfterHumidity)
+		assert.Equal(t, "cli", revisions[3].Actor)
+
+		revisions, err = repo.GetRevisions(ctx, "2025-01-02")
+		assert.Nil(t, err)
+		assert.Empty(t, revisions)
+	})
+
+	t.Run("reconstructs the records of a range as of a time", func(t *testing.T) {
+		repo := newRepository(t)
+		seed(t, repo)
+		// the pauses separate the seeded records from the changes after them
+		time.Sleep(10 * time.Millisecond)
+		seeded := time.Now()
+		time.Sleep(10 * time.Millisecond)
+
+		update := models.Weather{RecordedAt: "2025-01-02", Humidity: 20, Temperature: 2}
+		_, err := repo.Upsert(ctx, &update, ConflictOverwrite)
+		assert.Nil(t, err)
+		records, _ := repo.GetByDate(ctx, "2025-01-03")
+		assert.Nil(t, repo.Delete(ctx, records[0].ID))
+		assert.Nil(t, repo.Create(ctx, &models.Weather{RecordedAt: "2025-01-05", Humidity: 40, Temperature: 8}))
+
+		past, err := repo.GetRangeAsOf(ctx, "2025-01-02", "", seeded)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2025-01-02", "2025-01-03", "2025-01-04"}, dates(past))
+		assert.Equal(t, 60.0, past[0].Humidity)
+		assert.Equal(t, 70.0, past[1].Humidity)
+
+		current, err := repo.GetRangeAsOf(ctx, "2025-01-02", "", time.Now())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2025-01-02", "2025-01-04", "2025-01-05"}, dates(current))
+		assert.Equal(t, 20.0, current[0].Humidity)
+
+		before, err := repo.GetRangeAsOf(ctx, "", "", seeded.AddDate(0, 0, -1))
+		assert.Nil(t, err)
+		assert.Empty(t, before)
+	})
+
+	t.Run("reconstructs the provenance of records as of a time", func(t *testing.T) {
+		repo := newRepository(t)
+		record := models.Weather{RecordedAt: "2025-01-01", Humidity: 50, Temperature: 10, Source: "station", BatchID: "a", Instrument: "hygrometer"}
+		assert.Nil(t, repo.Create(ctx, &record))
+		time.Sleep(10 * time.Millisecond)
+		created := time.Now()
+		time.Sleep(10 * time.Millisecond)
+
+		update := models.Weather{RecordedAt: "2025-01-01", Humidity: 55, Temperature: 10, Source: "import", BatchID: "b", Quality: models.QualityEstimated}
+		_, err := repo.Upsert(ctx, &update, ConflictOverwrite)
+		assert.Nil(t, err)
+
+		past, err := repo.GetRangeAsOf(ctx, "", "", created)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(past))
+		assert.Equal(t, "station", past[0].Source)
+		assert.Equal(t, "a", past[0].BatchID)
+		assert.Equal(t, "hygrometer", past[0].Instrument)
+		assert.Equal(t, models.QualityRaw, past[0].Quality)
+
+		revisions, err := repo.GetRevisions(ctx, "2025-01-01")
+		assert.Nil(t, err)
+		assert.Equal(t, "station", *revisions[1].BeforeSource)
+		assert.Equal(t, "import", *revisions[1].AfterSource)
+		assert.Equal(t, "hygrometer", *revisions[1].AfterInstrument)
+	})
+
+	t.Run("aggregates a measurement over a range", func(t *testing.T) {
+		repo := newRepository(t)
+		seed(t, repo)
+
+		aggregate, err := repo.Aggregate(ctx, "Temperature", "2025-01-01", "2025-01-03")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, aggregate.Count)
+		assert.Equal(t, 10.0, aggregate.Min)
+		assert.Equal(t, 14.0, aggregate.Max)
+		assert.Equal(t, 36.0, aggregate.Sum)
+		assert.InDelta(t, 12.0, aggregate.Mean, 1e-9)
+
+		aggregate, err = repo.Aggregate(ctx, "Humidity", "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, 4, aggregate.Count)
+		assert.Equal(t, 70.0, aggregate.Max)
+
+		aggregate, err = repo.Aggregate(ctx, "Humidity", "2025-02-01", "")
+		assert.Nil(t, err)
+		assert.Equal(t, Aggregate{}, aggregate)
+
+		_, err = repo.Aggregate(ctx, "RecordedAt", "", "")
+		assert.True(t, errors.Is(err, ErrUnknownMeasurement))
+	})
+
+	t.Run("aggregates a measurement over calendar days across years", func(t *testing.T) {
+		repo := newRepository(t)
+		seed(t, repo)
+		assert.Nil(t, repo.Create(ctx, &models.Weather{RecordedAt: "2024-01-02", Humidity: 40, Temperature: 8}))
+
+		aggregate, err := repo.AggregateMonthDays(ctx, "Temperature", []string{"01-02", "01-03"}, "2025-01-03")
+		assert.Nil(t, err)
+		assert.Equal(t, Aggregate{Count: 2, Min: 8, Max: 12, Mean: 10, Sum: 20}, aggregate)
+
+		aggregate, err = repo.AggregateMonthDays(ctx, "Temperature", nil, "")
+		assert.Nil(t, err)
+		assert.Equal(t, Aggregate{}, aggregate)
+
+		_, err = repo.AggregateMonthDays(ctx, "RecordedAt", []string{"01-02"}, "")
+		assert.True(t, errors.Is(err, ErrUnknownMeasurement))
+	})
+
+	t.Run("merges aggregates", func(t *testing.T) {
+		merged := Aggregate{Count: 2, Min: 8, Max: 12, Mean: 10, Sum: 20}.Merge(Aggregate{Count: 1, Min: 14, Max: 14, Mean: 14, Sum: 14})
+		assert.Equal(t, Aggregate{Count: 3, Min: 8, Max: 14, Sum: 34, Mean: 34.0 / 3}, merged)
+		assert.Equal(t, merged, merged.Merge(Aggregate{}))
+		assert.Equal(t, merged, Aggregate{}.Merge(merged))
+	})
+
+	t.Run("keeps the changes of a transaction only when it succeeds", func(t *testing.T) {
+		repo := newRepository(t)
+		seed(t, repo)
+
+		failure := errors.New("failure")
+		err := repo.Transaction(ctx, func(tx WeatherRepository) error {
+			records, err := tx.GetByDate(ctx, "2025-01-01")
+			assert.Nil(t, err)
+			assert.Nil(t, tx.Delete(ctx, records[0].ID))
+			assert.Nil(t, tx.Create(ctx, &models.Weather{RecordedAt: "2025-01-05", Humidity: 80, Temperature: 16}))
+
+			// changes are visible within the transaction
+			records, err = tx.GetRange(ctx, "", "")
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"2025-01-02", "2025-01-03", "2025-01-04", "2025-01-05"}, dates(records))
+			return failure
+		})
+		assert.True(t, errors.Is(err, failure))
+
+		records, err := repo.GetRange(ctx, "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2025-01-01", "2025-01-02", "2025-01-03", "2025-01-04"}, dates(records))
+
+		err = repo.Transaction(ctx, func(tx WeatherRepository) error {
+			return tx.Create(ctx, &models.Weather{RecordedAt: "2025-01-05", Humidity: 80, Temperature: 16})
+		})
+		assert.Nil(t, err)
+		records, err = repo.GetByDate(ctx, "2025-01-05")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(records))
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		repo := newRepository(t)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				record := models.Weather{RecordedAt: fmt.Sprintf("2025-01-%02d", i+1), Humidity: float64(i), Temperature: 10}
+				assert.Nil(t, repo.Create(ctx, &record))
+				_, err := repo.GetRange(ctx, "", "")
+				assert.Nil(t, err)
+				_, err = repo.Aggregate(ctx, "Humidity", "", "")
+				assert.Nil(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		aggregate, err := repo.Aggregate(ctx, "Humidity", "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, 20, aggregate.Count)
+		assert.Equal(t, 190.0, aggregate.Sum)
+	})
+}
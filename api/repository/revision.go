@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"weatherapi/models"
+)
+
+// Sources of changes, recorded by the revisions of the records
+const (
+	SourceApi    = "api"
+	SourceIngest = "ingest"
+	SourceAdmin  = "admin"
+)
+
+// Operations of revisions
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Author identifies who made a change and through what, every write records it in the revisions of the records
+type Author struct {
+	Actor  string
+	Source string
+}
+
+type authorKey struct{}
+
+// WithAuthor returns a context whose writes are recorded as changes by the author
+func WithAuthor(ctx context.Context, author Author) context.Context {
+	return context.WithValue(ctx, authorKey{}, author)
+}
+
+// AuthorOf returns the author of the context, which is empty when none was set
+func AuthorOf(ctx context.Context) Author {
+	author, _ := ctx.Value(authorKey{}).(Author)
+	return author
+}
+
+// newRevision records the change of a record from before to after by the author of the context, either may be nil.
+// The time of the revision is left to the repository.
+func newRevision(ctx context.Context, operation string, before *models.Weather, after *models.Weather) models.WeatherRevision {
+	author := AuthorOf(ctx)
+	revision := models.WeatherRevision{Operation: operation, Actor: author.Actor, Source: author.Source}
+	if before != nil {
+		humidity, temperature, quality := before.Humidity, before.Temperature, before.Quality
+		source, batchID, instrument := before.Source, before.BatchID, before.Instrument
+		revision.WeatherID, revision.RecordedAt = before.ID, before.RecordedAt
+		revision.BeforeHumidity, revision.BeforeTemperature, revision.BeforeQuality = &humidity, &temperature, &quality
+		revision.BeforeSource, revision.BeforeBatchID, revision.BeforeInstrument = &source, &batchID, &instrument
+	}
+	if after != nil {
+		humidity, temperature, quality := after.Humidity, after.Temperature, after.Quality
+		source, batchID, instrument := after.Source, after.BatchID, after.Instrument
+		revision.WeatherID, revision.RecordedAt = after.ID, after.RecordedAt
+		revision.AfterHumidity, revision.AfterTemperature, revision.AfterQuality = &humidity, &temperature, &quality
+		revision.AfterSource, revision.AfterBatchID, revision.AfterInstrument = &source, &batchID, &instrument
+	}
+	return revision
+}
+
+// replayRevisions reconstructs the records from their revisions, which are ordered by date and time of the change.
+// The last revision of a date holds the values of its record, unless it was deleted.
+func replayRevisions(revisions []models.WeatherRevision) []models.Weather {
+	records := []models.Weather{}
+	for i, revision := range revisions {
+		if i+1 < len(revisions) && revisions[i+1].RecordedAt == revision.RecordedAt {
+			continue
+		}
+		if revision.Operation == OperationDelete {
+			continue
+		}
+		record := models.Weather{RecordedAt: revision.RecordedAt, Humidity: *revision.AfterHumidity, Temperature: *revision.AfterTemperature}
+		// the provenance of revisions made before it was recorded is unknown, and stays empty
+		for _, field := range []struct {
+			value    *string
+			restored *string
+		}{
+			{revision.AfterQuality, &record.Quality}, {revision.AfterSource, &record.Source},
+			{revision.AfterBatchID, &record.BatchID}, {revision.AfterInstrument, &record.Instrument},
+		} {
+			if field.value != nil {
+				*field.restored = *field.value
+			}
+		}
+		record.ID = revision.WeatherID
+		record.UpdatedAt = revision.CreatedAt
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+	"weatherapi/repository"
+	"weatherapi/services"
+	"weatherapi/utils"
+)
+
+// generateRecords returns plausible daily records: a seasonal cycle peaking in July with random day to day variation.
+// The same random seed always generates the same records.
+func generateRecords(from time.Time, days int, seed uint64) []services.WeatherRecordBody {
+	random := rand.New(rand.NewPCG(seed, seed))
+	var records []services.WeatherRecordBody
+	for day := 0; day < days; day++ {
+		date := from.AddDate(0, 0, day)
+		season := math.Sin(2 * math.Pi * float64(utils.DayOfYear(date)-110) / 365)
+		temperature := 12 + 10*season + random.NormFloat64()*2.5
+		humidity := 65 - 15*season + random.NormFloat64()*10
+		records = append(records, services.WeatherRecordBody{
+			RecordedAt:  date.Format("2006-01-02"),
+			Humidity:    math.Round(min(100, max(0, humidity))*100) / 100,
+			Temperature: math.Round(temperature*100) / 100,
+			Source:      "seed",
+		})
+	}
+	return records
+}
+
+// runSeed creates generated records, existing dates are kept
+func runSeed(args []string) int {
+	flags, env := newFlagSet("seed", "seed -generate [flags]")
+	generate := flags.Bool("generate", false, "generate records, required")
+	days := flags.Int("days", 365, "number of days to generate")
+	from := flags.String("from", "", "first date to generate, YYYY-MM-DD (default: days before today)")
+	seed := flags.Uint64("random-seed", 1, "seed of the generated values")
+	args, code, ok := parseFlags(flags, args)
+	if !ok {
+		return code
+	}
+	if len(args) > 0 {
+		return usageError(flags, "seed takes no arguments")
+	}
+	if !*generate {
+		return usageError(flags, "seed only generates records, pass -generate (use ingest to load records from a file)")
+	}
+	if *days < 1 {
+		return usageError(flags, "days has to be positive")
+	}
+
+	var start time.Time
+	if *from != "" {
+		parsed, err := time.Parse("2006-01-02", *from)
+		if err != nil {
+			return usageError(flags, "invalid date: %s", *from)
+		}
+		start = parsed
+	}
+
+	service, conf, closeDb, code := openService(env)
+	if code != exitOK {
+		return code
+	}
+	defer closeDb()
+
+	if start.IsZero() {
+		start = utils.Today(utils.SystemClock{}, conf.Location).AddDate(0, 0, -*days)
+	}
+
+	ctx := withCommandAuthor(context.Background(), repository.SourceAdmin)
+	result, err := saveRecords(ctx, service, conf.Location, generateRecords(start, *days, *seed), repository.ConflictSkip)
+	fmt.Println(result)
+	if err != nil {
+		return fail(exitFailure, "%v", err)
+	}
+	if result.rejected > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
@@ -1,47 +1,74 @@
 package server
 
 import (
+	"fmt"
 	"log"
+	"os"
 	"strings"
-	"sync"
-	"weatherapi/configs"
+	"sync/atomic"
+	"time"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
-var (
-	dbInstance *gorm.DB
-	dbOnce     sync.Once
-)
+// gorm's default logger with stderr instead of stdout, which the commands of the binary keep for their output
+var dbLogger = logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+	SlowThreshold: 200 * time.Millisecond,
+	LogLevel:      logger.Warn,
+	Colorful:      true,
+})
+
+// now is the time of the timestamps set by gorm. UTC keeps them comparable, SQLite compares them as text and the TIMESTAMP
+// columns of Postgres store no time zone.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// memoryScheme keeps the weather records in memory, see repository.MemoryWeatherRepository. The other tables live in an
+// in-memory SQLite database of the process, nothing outlives it.
+const memoryScheme = "memory://"
+
+// memoryDatabases numbers the in-memory databases, every connection string opens a database of its own
+var memoryDatabases atomic.Int64
+
+// IsMemory reports whether the connection string keeps everything in memory
+func IsMemory(connectionString string) bool {
+	return strings.HasPrefix(connectionString, memoryScheme)
+}
+
+// OpenDb connects to the database of the connection string, which starts with postgresql://, sqlite:// or memory://
+func OpenDb(connectionString string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	if IsMemory(connectionString) {
+		// the connections of the pool share the named database, which lives until the last one is closed
+		dialector = sqlite.Open(fmt.Sprintf("file:weatherapi-memory-%d?mode=memory&cache=shared", memoryDatabases.Add(1)))
+	} else if strings.HasPrefix(connectionString, "postgresql://") {
+		dialector = postgres.Open(connectionString)
+	} else if strings.HasPrefix(connectionString, "sqlite://") {
+		sqliteConnection, _ := strings.CutPrefix(connectionString, "sqlite://")
+		dialector = sqlite.Open(sqliteConnection)
+	}
+
+	if dialector == nil {
+		return nil, fmt.Errorf("unsupported database connection string: %s", connectionString)
+	}
 
-func GetDb() *gorm.DB {
-	dbOnce.Do(func() {
-		conf := configs.Get()
-		var err error
-
-		var dialector gorm.Dialector
-		if strings.HasPrefix(conf.DbConnectionString, "postgresql://") {
-			dialector = postgres.Open(conf.DbConnectionString)
-		} else if strings.HasPrefix(conf.DbConnectionString, "sqlite://") {
-			sqliteConnection, _ := strings.CutPrefix(conf.DbConnectionString, "sqlite://")
-			dialector = sqlite.Open(sqliteConnection)
-		}
-
-		if dialector == nil {
-			log.Fatalf("unsupported database connection string: %s", conf.DbConnectionString)
-		}
-
-		dbInstance, err = gorm.Open(dialector, &gorm.Config{})
-
-		if err != nil {
-			log.Fatalf("failed to connect database: %v", err)
-		}
-	})
-	return dbInstance
+	// unique constraint violations are reported as gorm.ErrDuplicatedKey by both dialects
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true, Logger: dbLogger, NowFunc: now})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %v", err)
+	}
+	return db, nil
 }
 
-func init() {
-	GetDb()
+// CloseDb closes the connection pool of the database
+func CloseDb(db *gorm.DB) error {
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.Close()
 }
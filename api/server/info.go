@@ -0,0 +1,9 @@
+package server
+
+import "time"
+
+// Version is set at build time with -ldflags "-X weatherapi/server.Version=<version>"
+var Version = "dev"
+
+// StartedAt is the time the process started
+var StartedAt = time.Now()
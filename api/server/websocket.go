@@ -1,17 +1,76 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
+	"weatherapi/logging"
+	"weatherapi/metrics"
 
 	"github.com/gofiber/contrib/socketio"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
+// connected clients by UUID, the disconnect event may fire more than once per connection
+var clients sync.Map
+
+// the socketio listeners are process-global, they are registered once however many apps are set up
+var listenersOnce sync.Once
+
+// interval in which CloseWebSockets checks whether all clients disconnected
+const closePollInterval = 50 * time.Millisecond
+
+// ConnectedClients returns the number of currently connected WebSocket clients
+func ConnectedClients() int {
+	count := 0
+	clients.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+var websocketErrors = metrics.Counter("websocket_errors_total", "Number of WebSocket connections closed by an error")
+
+// logEvent logs a connection event with the request id of the upgrade request
+func logEvent(level slog.Level, ep *socketio.EventPayload) {
+	requestId, _ := ep.Kws.Locals(logging.RequestIDKey).(string)
+	attrs := []any{"event", ep.Name, "uuid", ep.Kws.UUID, "user_id", ep.Kws.GetStringAttribute("user_id")}
+	if ep.Error != nil {
+		attrs = append(attrs, "error", ep.Error)
+	}
+	slog.Log(logging.WithRequestID(context.Background(), requestId), level, "WebSocket event", attrs...)
+}
+
+// CloseWebSockets sends every client a close frame asking it to reconnect, and waits until all clients disconnected
+// or the context is done. The close frame is queued after the messages already pending for a client, so those are still delivered.
+func CloseWebSockets(ctx context.Context) error {
+	message := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting, please reconnect")
+	clients.Range(func(_, value any) bool {
+		kws := value.(*socketio.Websocket)
+		// the queue of a client blocks when it is full, which must not hold up the shutdown
+		go kws.Emit(message, socketio.CloseMessage)
+		return true
+	})
+
+	ticker := time.NewTicker(closePollInterval)
+	defer ticker.Stop()
+	for ConnectedClients() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d WebSocket clients still connected: %w", ConnectedClients(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 func RegisterWebSocket(app *fiber.App) {
 	app.Use("/ws", func(c *fiber.Ctx) error {
-		log.Println("WebSocket upgrade request")
+		slog.InfoContext(c.UserContext(), "WebSocket upgrade request")
 		if websocket.IsWebSocketUpgrade(c) {
 			c.Locals("allowed", true)
 			return c.Next()
@@ -19,21 +78,30 @@ func RegisterWebSocket(app *fiber.App) {
 		return fiber.ErrUpgradeRequired
 	})
 
-	socketio.On(socketio.EventDisconnect, func(ep *socketio.EventPayload) {
-		log.Printf("Disconnection event - User: %s", ep.Kws.GetStringAttribute("user_id"))
-	})
+	listenersOnce.Do(func() {
+		socketio.On(socketio.EventConnect, func(ep *socketio.EventPayload) {
+			logEvent(slog.LevelInfo, ep)
+		})
 
-	socketio.On(socketio.EventClose, func(ep *socketio.EventPayload) {
-		log.Printf("Close event - User: %s", ep.Kws.GetStringAttribute("user_id"))
-	})
+		socketio.On(socketio.EventDisconnect, func(ep *socketio.EventPayload) {
+			clients.Delete(ep.Kws.UUID)
+			logEvent(slog.LevelInfo, ep)
+		})
+
+		socketio.On(socketio.EventClose, func(ep *socketio.EventPayload) {
+			logEvent(slog.LevelInfo, ep)
+		})
 
-	socketio.On(socketio.EventError, func(ep *socketio.EventPayload) {
-		log.Printf("Error event - User: %s", ep.Kws.GetStringAttribute("user_id"))
+		socketio.On(socketio.EventError, func(ep *socketio.EventPayload) {
+			websocketErrors.Inc()
+			logEvent(slog.LevelError, ep)
+		})
 	})
 
 	app.Get("/ws/:id", socketio.New(func(kws *socketio.Websocket) {
 		userId := kws.Params("id")
 		kws.SetAttribute("user_id", userId)
+		clients.Store(kws.UUID, kws)
 		kws.Emit([]byte(fmt.Sprintf("Hello user: %s with UUID: %s", userId, kws.UUID)), socketio.TextMessage)
 	}))
 }
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"weatherapi/configs"
+	"weatherapi/models"
+	"weatherapi/repository"
+	"weatherapi/utils"
+)
+
+type AnomalyMethod string
+
+const (
+	AnomalyZScore AnomalyMethod = "zscore"
+	AnomalyIQR    AnomalyMethod = "iqr"
+)
+
+type AnomalySettings struct {
+	Method AnomalyMethod
+	// scores with an absolute value above the threshold are flagged
+	Threshold float64
+}
+
+type AnomalyScore struct {
+	Field string `json:"field"`
+	// "recent" compares against the preceding days, "seasonal" against the same period in past years
+	Baseline string  `json:"baseline"`
+	Value    float64 `json:"value"`
+	Score    float64 `json:"score"`
+}
+
+type AnomalyResult struct {
+	Record WeatherRecordResponse `json:"record"`
+	Scores []AnomalyScore        `json:"scores"`
+}
+
+func ParseAnomalyMethod(value string) (AnomalyMethod, error) {
+	switch AnomalyMethod(value) {
+	case AnomalyZScore, AnomalyIQR:
+		return AnomalyMethod(value), nil
+	}
+	return "", fmt.Errorf("unknown anomaly method: %s", value)
+}
+
+// DefaultAnomalySettings returns the settings used when scoring records on ingest
+func (s *Service) DefaultAnomalySettings() (AnomalySettings, error) {
+	conf := s.conf
+	method, err := ParseAnomalyMethod(conf.AnomalyMethod)
+	if err != nil {
+		return AnomalySettings{}, err
+	}
+	return AnomalySettings{Method: method, Threshold: conf.AnomalyThreshold}, nil
+}
+
+func score(value float64, history []float64, method AnomalyMethod) (float64, bool) {
+	switch method {
+	case AnomalyZScore:
+		stdDev := utils.StdDev(history)
+		if stdDev == 0 || math.IsNaN(stdDev) {
+			return 0, false
+		}
+		return (value - utils.Mean(history)) / stdDev, true
+	case AnomalyIQR:
+		q1 := utils.Quantile(history, 0.25)
+		q3 := utils.Quantile(history, 0.75)
+		iqr := q3 - q1
+		if iqr == 0 {
+			return 0, false
+		}
+		// distance outside of the interquartile range, in multiples of the range
+		if value < q1 {
+			return (value - q1) / iqr, true
+		}
+		if value > q3 {
+			return (value - q3) / iqr, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// anomalyHistory indexes the records that scoring compares against by their date, see loadAnomalyHistory
+type anomalyHistory struct {
+	byDate    map[string]models.Weather
+	firstYear int
+}
+
+// add adds or replaces the record of its date in the history
+func (h *anomalyHistory) add(record models.Weather, columnsConfig *configs.ColumnsConfig) error {
+	date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+	if err != nil {
+		return err
+	}
+	h.byDate[date.Format(columnsConfig.DateFormat)] = record
+	if h.firstYear == 0 || date.Year() < h.firstYear {
+		h.firstYear = date.Year()
+	}
+	return nil
+}
+
+// loadAnomalyHistory loads the records that scoring the records from and to the given dates compares against: the trailing
+// window before each of them, and the seasonal window around their calendar days in past years
+func (s *Service) loadAnomalyHistory(ctx context.Context, repo repository.WeatherRepository, from time.Time, to time.Time) (anomalyHistory, error) {
+	conf := s.conf
+	columnsConfig := s.columns
+
+	recent, err := repo.GetRange(ctx, from.AddDate(0, 0, -conf.AnomalyWindowDays).Format(columnsConfig.DateFormat), to.Format(columnsConfig.DateFormat))
+	if err != nil {
+		return anomalyHistory{}, err
+	}
+	// the calendar days of the seasonal windows, a day more on each side covers the shift of the days of the year after
+	// February 29th
+	windowDays := conf.AnomalySeasonalWindowDays + 1
+	var days []int
+	seen := map[int]bool{}
+	for date := from.AddDate(0, 0, -windowDays); !date.After(to.AddDate(0, 0, windowDays)) && len(seen) < daysPerYear; date = date.AddDate(0, 0, 1) {
+		if day := utils.DayOfYear(date); !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	seasonal, err := repo.GetByMonthDays(ctx, utils.MonthDays(days))
+	if err != nil {
+		return anomalyHistory{}, err
+	}
+
+	history := anomalyHistory{byDate: map[string]models.Weather{}}
+	for _, record := range append(seasonal, recent...) {
+		if err := history.add(record, columnsConfig); err != nil {
+			return anomalyHistory{}, err
+		}
+	}
+	return history, nil
+}
+
+// baselines returns the records of the history in the trailing window before the date, and those within the seasonal window
+// of the date's calendar day in earlier years, both ordered by date
+func (h *anomalyHistory) baselines(date time.Time, conf *configs.Config, columnsConfig *configs.ColumnsConfig) ([]models.Weather, []models.Weather) {
+	var recent, seasonal []models.Weather
+	for day := date.AddDate(0, 0, -conf.AnomalyWindowDays); day.Before(date); day = day.AddDate(0, 0, 1) {
+		if record, ok := h.byDate[day.Format(columnsConfig.DateFormat)]; ok {
+			recent = append(recent, record)
+		}
+	}
+
+	windowDays := conf.AnomalySeasonalWindowDays
+	seen := map[string]bool{}
+	// windows may cross a year boundary, so the anniversary in the year before the first record is included
+	for year := h.firstYear - 1; year < date.Year(); year++ {
+		anniversary := time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		for offset := -windowDays; offset <= windowDays; offset++ {
+			day := anniversary.AddDate(0, 0, offset)
+			key := day.Format(columnsConfig.DateFormat)
+			if record, ok := h.byDate[key]; ok && day.Before(date) && !seen[key] {
+				seen[key] = true
+				seasonal = append(seasonal, record)
+			}
+		}
+	}
+	return recent, seasonal
+}
+
+// scoreRecord compares each measurement of the record against its recent history and against the same period in past years.
+// Only records of the history before the record's date are considered.
+func (s *Service) scoreRecord(record models.Weather, history *anomalyHistory, settings AnomalySettings, columnsConfig *configs.ColumnsConfig) ([]AnomalyScore, error) {
+	conf := s.conf
+
+	date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+	if err != nil {
+		return nil, err
+	}
+	recent, seasonal := history.baselines(date, conf, columnsConfig)
+
+	var anomalies []AnomalyScore
+	baselines := []struct {
+		name    string
+		records []models.Weather
+	}{{"recent", recent}, {"seasonal", seasonal}}
+
+	for _, baseline := range baselines {
+		if len(baseline.records) < conf.AnomalyMinSamples {
+			continue
+		}
+		for _, measurement := range columnsConfig.Measurements {
+			value, ok := record.Measurement(measurement.Name)
+			if !ok {
+				continue
+			}
+			var values []float64
+			for _, historical := range baseline.records {
+				historicalValue, _ := historical.Measurement(measurement.Name)
+				values = append(values, historicalValue)
+			}
+			result, ok := score(value, values, settings.Method)
+			if ok && math.Abs(result) > settings.Threshold {
+				anomalies = append(anomalies, AnomalyScore{
+					Field:    strings.ToLower(measurement.Name),
+					Baseline: baseline.name,
+					Value:    value,
+					Score:    result,
+				})
+			}
+		}
+	}
+	return anomalies, nil
+}
+
+// flagAnomalies stores the outcome of the scoring on the record
+func flagAnomalies(record *models.Weather, anomalies []AnomalyScore) {
+	var fields []string
+	for _, anomaly := range anomalies {
+		if !slices.Contains(fields, anomaly.Field) {
+			fields = append(fields, anomaly.Field)
+		}
+	}
+	record.Anomaly = len(fields) > 0
+	record.AnomalyFields = strings.Join(fields, ",")
+}
+
+// ScanForAnomalies scores all records in the given range against the history preceding each of them
+func (s *Service) ScanForAnomalies(ctx context.Context, from string, to string, settings AnomalySettings) ([]AnomalyResult, error) {
+	columnsConfig := s.columns
+
+	fromDate, err := parseRecordedAt(from, columnsConfig)
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := parseRecordedAt(to, columnsConfig)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.weather.GetRange(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	history, err := s.loadAnomalyHistory(ctx, s.weather, fromDate, toDate)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []AnomalyResult{}
+	for _, record := range records {
+		anomalies, err := s.scoreRecord(record, &history, settings, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if len(anomalies) == 0 {
+			continue
+		}
+		flagAnomalies(&record, anomalies)
+		formatted, err := getFormattedWeatherRecordUnits(&[]models.Weather{record}, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, AnomalyResult{Record: formatted[0], Scores: anomalies})
+	}
+	return results, nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+	"weatherapi/models"
+	"weatherapi/utils"
+)
+
+// QueryResult holds the records of a query together with the validators used for conditional requests
+type QueryResult struct {
+	Records []WeatherRecordResponse
+	// strong validator derived from the returned content and the update time of the underlying records
+	ETag string
+	// latest update time of the underlying records, zero when there are none
+	LastModified time.Time
+}
+
+type CacheStatistics struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// queryCache is an LRU of query results with hit and miss counters
+type queryCache struct {
+	entries *utils.LRU[string, cachedResult]
+	// time a result is kept, writes of other processes (e.g. the seed command) are only seen once it expired. 0 keeps results until a write
+	ttl    time.Duration
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	// every purge starts a new generation, results of queries that started in an earlier one may be stale and are not added
+	mutex      sync.Mutex
+	generation uint64
+}
+
+type cachedResult struct {
+	result    QueryResult
+	expiresAt time.Time
+}
+
+func newQueryCache(size int, ttl time.Duration) *queryCache {
+	return &queryCache{entries: utils.NewLRU[string, cachedResult](size), ttl: ttl}
+}
+
+func (c *queryCache) get(key string, now time.Time) (QueryResult, bool) {
+	cached, ok := c.entries.Get(key)
+	if !ok || (c.ttl > 0 && !now.Before(cached.expiresAt)) {
+		return QueryResult{}, false
+	}
+	return cached.result, true
+}
+
+func (c *queryCache) currentGeneration() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+// add caches the result of a query that started in the given generation, unless the cache was purged since
+func (c *queryCache) add(key string, result QueryResult, generation uint64, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	c.entries.Add(key, cachedResult{result: result, expiresAt: now.Add(c.ttl)})
+}
+
+func (c *queryCache) purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.entries.Purge()
+}
+
+func newQueryResult(records []models.Weather, results []WeatherRecordResponse) (QueryResult, error) {
+	content, err := json.Marshal(results)
+	if err != nil {
+		return QueryResult{}, fmt.Errorf("error marshalling results: %v", err)
+	}
+
+	hash := sha256.New()
+	hash.Write(content)
+	var lastModified time.Time
+	for _, record := range records {
+		fmt.Fprintf(hash, "|%d", record.UpdatedAt.UnixNano())
+		if record.UpdatedAt.After(lastModified) {
+			lastModified = record.UpdatedAt
+		}
+	}
+
+	return QueryResult{
+		Records:      results,
+		ETag:         `"` + hex.EncodeToString(hash.Sum(nil)) + `"`,
+		LastModified: lastModified,
+	}, nil
+}
+
+// cachedQuery returns the cached result of the query, or runs and caches it
+func (s *Service) cachedQuery(ctx context.Context, key string, query func() (QueryResult, error)) (QueryResult, error) {
+	cache := s.cache
+	if result, ok := cache.get(key, s.clock.Now()); ok {
+		cache.hits.Add(1)
+		slog.DebugContext(ctx, "Query cache hit", "key", key)
+		return result, nil
+	}
+	cache.misses.Add(1)
+	slog.DebugContext(ctx, "Query cache miss", "key", key)
+
+	// a write that completes while the query runs purges the cache, the result may predate it
+	generation := cache.currentGeneration()
+	result, err := query()
+	if err != nil {
+		return QueryResult{}, err
+	}
+	cache.add(key, result, generation, s.clock.Now())
+	return result, nil
+}
+
+// InvalidateCache drops all cached query results, it needs to be called after every write
+func (s *Service) InvalidateCache() {
+	s.cache.purge()
+}
+
+func (s *Service) GetCacheStatistics() CacheStatistics {
+	return CacheStatistics{
+		Hits:   s.cache.hits.Load(),
+		Misses: s.cache.misses.Load(),
+		Size:   s.cache.entries.Len(),
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"time"
+	"weatherapi/configs"
+	"weatherapi/utils"
+)
+
+type Period struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Days int    `json:"days"`
+}
+
+// ComparisonPoint aligns the records of both periods by their day within the period, starting at 1.
+// A side is null when the period is shorter or has no record for that day.
+type ComparisonPoint struct {
+	Day   int                    `json:"day"`
+	A     *WeatherRecordResponse `json:"a"`
+	B     *WeatherRecordResponse `json:"b"`
+	Delta *RawWeatherRecordUnits `json:"delta"`
+}
+
+type PeriodStatistics struct {
+	Count int                   `json:"count"`
+	Mean  RawWeatherRecordUnits `json:"mean"`
+	Min   RawWeatherRecordUnits `json:"min"`
+	Max   RawWeatherRecordUnits `json:"max"`
+}
+
+type StatisticsDelta struct {
+	Mean RawWeatherRecordUnits `json:"mean"`
+	Min  RawWeatherRecordUnits `json:"min"`
+	Max  RawWeatherRecordUnits `json:"max"`
+}
+
+type ComparisonSummary struct {
+	A *PeriodStatistics `json:"a"`
+	B *PeriodStatistics `json:"b"`
+	// b minus a, only when both periods have records
+	Delta *StatisticsDelta `json:"delta"`
+}
+
+type ComparisonResponse struct {
+	A       Period            `json:"a"`
+	B       Period            `json:"b"`
+	Series  []ComparisonPoint `json:"series"`
+	Summary ComparisonSummary `json:"summary"`
+}
+
+// difference returns b minus a for every measurement
+func difference(a RawWeatherRecordUnits, b RawWeatherRecordUnits, columnsConfig *configs.ColumnsConfig) RawWeatherRecordUnits {
+	var delta RawWeatherRecordUnits
+	for _, measurement := range columnsConfig.Measurements {
+		valueA, _ := a.Measurement(measurement.Name)
+		valueB, _ := b.Measurement(measurement.Name)
+		delta.setMeasurement(measurement.Name, valueB-valueA)
+	}
+	return delta
+}
+
+func periodStatistics(records []WeatherRecordResponse, columnsConfig *configs.ColumnsConfig) *PeriodStatistics {
+	if len(records) == 0 {
+		return nil
+	}
+	statistics := &PeriodStatistics{Count: len(records)}
+	for _, measurement := range columnsConfig.Measurements {
+		var values []float64
+		for _, record := range records {
+			value, _ := record.Raw.Measurement(measurement.Name)
+			values = append(values, value)
+		}
+		statistics.Mean.setMeasurement(measurement.Name, utils.Mean(values))
+		statistics.Min.setMeasurement(measurement.Name, utils.Quantile(values, 0))
+		statistics.Max.setMeasurement(measurement.Name, utils.Quantile(values, 1))
+	}
+	return statistics
+}
+
+// recordsByDayOfPeriod indexes records by their day within the period, starting at 1
+func recordsByDayOfPeriod(records []WeatherRecordResponse, from time.Time, columnsConfig *configs.ColumnsConfig) (map[int]WeatherRecordResponse, error) {
+	byDay := map[int]WeatherRecordResponse{}
+	for _, record := range records {
+		date, err := parseRecordedAt(record.Date, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		byDay[utils.DaysBetween(from, date)+1] = record
+	}
+	return byDay, nil
+}
+
+func (s *Service) loadPeriod(ctx context.Context, from string, to string, columnsConfig *configs.ColumnsConfig) (Period, []WeatherRecordResponse, map[int]WeatherRecordResponse, error) {
+	fromDate, err := parseRecordedAt(from, columnsConfig)
+	if err != nil {
+		return Period{}, nil, nil, err
+	}
+	toDate, err := parseRecordedAt(to, columnsConfig)
+	if err != nil {
+		return Period{}, nil, nil, err
+	}
+
+	result, err := s.GetWeatherRecordsForRange(ctx, from, to, RangeOptions{})
+	if err != nil {
+		return Period{}, nil, nil, err
+	}
+	records := result.Records
+	byDay, err := recordsByDayOfPeriod(records, fromDate, columnsConfig)
+	if err != nil {
+		return Period{}, nil, nil, err
+	}
+
+	period := Period{From: from, To: to, Days: utils.DaysBetween(fromDate, toDate) + 1}
+	return period, records, byDay, nil
+}
+
+// ComparePeriods aligns two periods day by day and summarizes how they differ.
+// Periods of unequal length are aligned from their start, the shorter one leaves its side of the trailing days empty.
+func (s *Service) ComparePeriods(ctx context.Context, fromA string, toA string, fromB string, toB string) (ComparisonResponse, error) {
+	columnsConfig := s.columns
+
+	periodA, recordsA, byDayA, err := s.loadPeriod(ctx, fromA, toA, columnsConfig)
+	if err != nil {
+		return ComparisonResponse{}, err
+	}
+	periodB, recordsB, byDayB, err := s.loadPeriod(ctx, fromB, toB, columnsConfig)
+	if err != nil {
+		return ComparisonResponse{}, err
+	}
+
+	response := ComparisonResponse{A: periodA, B: periodB, Series: []ComparisonPoint{}}
+	for day := 1; day <= max(periodA.Days, periodB.Days); day++ {
+		point := ComparisonPoint{Day: day}
+		if record, ok := byDayA[day]; ok {
+			point.A = &record
+		}
+		if record, ok := byDayB[day]; ok {
+			point.B = &record
+		}
+		if point.A != nil && point.B != nil {
+			delta := difference(point.A.Raw, point.B.Raw, columnsConfig)
+			point.Delta = &delta
+		}
+		response.Series = append(response.Series, point)
+	}
+
+	response.Summary.A = periodStatistics(recordsA, columnsConfig)
+	response.Summary.B = periodStatistics(recordsB, columnsConfig)
+	if response.Summary.A != nil && response.Summary.B != nil {
+		response.Summary.Delta = &StatisticsDelta{
+			Mean: difference(response.Summary.A.Mean, response.Summary.B.Mean, columnsConfig),
+			Min:  difference(response.Summary.A.Min, response.Summary.B.Min, columnsConfig),
+			Max:  difference(response.Summary.A.Max, response.Summary.B.Max, columnsConfig),
+		}
+	}
+	return response, nil
+}
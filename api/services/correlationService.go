@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"weatherapi/configs"
+	"weatherapi/utils"
+)
+
+type LaggedCorrelation struct {
+	Lag int `json:"lag"`
+	N   int `json:"n"`
+	// null when undefined, e.g. without variance or with fewer than two pairs
+	Pearson *float64 `json:"pearson"`
+}
+
+type CorrelationResponse struct {
+	X         string   `json:"x"`
+	Y         string   `json:"y"`
+	Lag       int      `json:"lag"`
+	N         int      `json:"n"`
+	Pearson   *float64 `json:"pearson"`
+	Spearman  *float64 `json:"spearman"`
+	Slope     *float64 `json:"slope"`
+	Intercept *float64 `json:"intercept"`
+	RSquared  *float64 `json:"r_squared"`
+	// only included when a maximum lag is requested
+	CrossCorrelation []LaggedCorrelation `json:"cross_correlation,omitempty"`
+}
+
+// defined returns nil for values that cannot be represented in JSON
+func defined(value float64) *float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return &value
+}
+
+// pairsWithLag pairs x on each of the days with y lag days later, skipping days where y is missing
+func pairsWithLag(days []time.Time, x map[time.Time]float64, y map[time.Time]float64, lag int) ([]float64, []float64) {
+	var xs, ys []float64
+	for _, day := range days {
+		if yValue, ok := y[day.AddDate(0, 0, lag)]; ok {
+			xs = append(xs, x[day])
+			ys = append(ys, yValue)
+		}
+	}
+	return xs, ys
+}
+
+// GetCorrelation correlates two measurements over the range, pairing x with y lag days later.
+// When maxLag is positive, the Pearson coefficient of every lag between -maxLag and maxLag is included.
+func (s *Service) GetCorrelation(ctx context.Context, x configs.Measurement, y configs.Measurement, from string, to string, lag int, maxLag int) (CorrelationResponse, error) {
+	columnsConfig := s.columns
+
+	fromDate, err := parseRecordedAt(from, columnsConfig)
+	if err != nil {
+		return CorrelationResponse{}, err
+	}
+	toDate, err := parseRecordedAt(to, columnsConfig)
+	if err != nil {
+		return CorrelationResponse{}, err
+	}
+
+	// lagged values of y may lie outside of the requested range
+	reach := max(abs(lag), maxLag)
+	records, err := s.weather.GetRange(ctx, fromDate.AddDate(0, 0, -reach).Format(columnsConfig.DateFormat), toDate.AddDate(0, 0, reach).Format(columnsConfig.DateFormat))
+	if err != nil {
+		return CorrelationResponse{}, err
+	}
+
+	// the records are ordered by date, days holds those within the requested range
+	var days []time.Time
+	xValues := map[time.Time]float64{}
+	yValues := map[time.Time]float64{}
+	for _, record := range records {
+		date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+		if err != nil {
+			return CorrelationResponse{}, err
+		}
+		if !date.Before(fromDate) && !date.After(toDate) {
+			days = append(days, date)
+		}
+		xValue, ok := record.Measurement(x.Name)
+		if !ok {
+			return CorrelationResponse{}, fmt.Errorf("measurement %s is not stored", x.Name)
+		}
+		yValue, ok := record.Measurement(y.Name)
+		if !ok {
+			return CorrelationResponse{}, fmt.Errorf("measurement %s is not stored", y.Name)
+		}
+		xValues[date] = xValue
+		yValues[date] = yValue
+	}
+
+	xs, ys := pairsWithLag(days, xValues, yValues, lag)
+	slope, intercept, rSquared := utils.LinearRegression(xs, ys)
+	response := CorrelationResponse{
+		X:         strings.ToLower(x.Name),
+		Y:         strings.ToLower(y.Name),
+		Lag:       lag,
+		N:         len(xs),
+		Pearson:   defined(utils.Pearson(xs, ys)),
+		Spearman:  defined(utils.Spearman(xs, ys)),
+		Slope:     defined(slope),
+		Intercept: defined(intercept),
+		RSquared:  defined(rSquared),
+	}
+
+	for candidate := -maxLag; maxLag > 0 && candidate <= maxLag; candidate++ {
+		if err := ctx.Err(); err != nil {
+			return CorrelationResponse{}, err
+		}
+		laggedXs, laggedYs := pairsWithLag(days, xValues, yValues, candidate)
+		response.CrossCorrelation = append(response.CrossCorrelation, LaggedCorrelation{
+			Lag:     candidate,
+			N:       len(laggedXs),
+			Pearson: defined(utils.Pearson(laggedXs, laggedYs)),
+		})
+	}
+	return response, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"weatherapi/configs"
+)
+
+type DegreeDayOptions struct {
+	// base temperature, in Unit
+	Base float64
+	// "C" or "F"
+	Unit string
+	// "day", "week" (ISO weeks), "month" or "year"
+	Bucket string
+}
+
+type DegreeDayCoverage struct {
+	ExpectedDays int     `json:"expected_days"`
+	ObservedDays int     `json:"observed_days"`
+	MissingDays  int     `json:"missing_days"`
+	Coverage     float64 `json:"coverage"`
+}
+
+type DegreeDayBucket struct {
+	Start         string  `json:"start"`
+	End           string  `json:"end"`
+	HDD           float64 `json:"hdd"`
+	CDD           float64 `json:"cdd"`
+	CumulativeHDD float64 `json:"cumulative_hdd"`
+	CumulativeCDD float64 `json:"cumulative_cdd"`
+	DegreeDayCoverage
+}
+
+type DegreeDayTotals struct {
+	HDD float64 `json:"hdd"`
+	CDD float64 `json:"cdd"`
+	DegreeDayCoverage
+}
+
+type DegreeDaysResponse struct {
+	Base    float64           `json:"base"`
+	Unit    string            `json:"unit"`
+	Bucket  string            `json:"bucket"`
+	Buckets []DegreeDayBucket `json:"buckets"`
+	Totals  DegreeDayTotals   `json:"totals"`
+}
+
+func IsValidDegreeDayBucket(bucket string) bool {
+	switch bucket {
+	case "day", "week", "month", "year":
+		return true
+	}
+	return false
+}
+
+func IsValidTemperatureUnit(unit string) bool {
+	return unit == "C" || unit == "F"
+}
+
+// temperatureUnit maps the configured temperature format (e.g. °C) to its unit letter
+func temperatureUnit(columnsConfig *configs.ColumnsConfig) string {
+	return strings.TrimPrefix(columnsConfig.TemperatureFormat, "°")
+}
+
+func ConvertTemperature(value float64, fromUnit string, toUnit string) float64 {
+	if fromUnit == toUnit {
+		return value
+	}
+	if toUnit == "F" {
+		return value*9/5 + 32
+	}
+	return (value - 32) * 5 / 9
+}
+
+func bucketStart(date time.Time, bucket string) time.Time {
+	switch bucket {
+	case "week":
+		// ISO weeks start on Monday
+		return date.AddDate(0, 0, -((int(date.Weekday()) + 6) % 7))
+	case "month":
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "year":
+		return time.Date(date.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return date
+}
+
+func nextBucketStart(start time.Time, bucket string) time.Time {
+	switch bucket {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	case "year":
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+func newCoverage(expectedDays int, observedDays int) DegreeDayCoverage {
+	coverage := DegreeDayCoverage{
+		ExpectedDays: expectedDays,
+		ObservedDays: observedDays,
+		MissingDays:  expectedDays - observedDays,
+	}
+	if expectedDays > 0 {
+		coverage.Coverage = float64(observedDays) / float64(expectedDays)
+	}
+	return coverage
+}
+
+// GetDegreeDays sums heating and cooling degree days of the stored daily temperatures per bucket.
+// Buckets at the edges are clipped to the requested range, and missing days are reported rather than estimated.
+func (s *Service) GetDegreeDays(ctx context.Context, from string, to string, options DegreeDayOptions) (DegreeDaysResponse, error) {
+	columnsConfig := s.columns
+
+	fromDate, err := time.Parse(columnsConfig.DateFormat, from)
+	if err != nil {
+		return DegreeDaysResponse{}, fmt.Errorf("error parsing date: %v", err)
+	}
+	toDate, err := time.Parse(columnsConfig.DateFormat, to)
+	if err != nil {
+		return DegreeDaysResponse{}, fmt.Errorf("error parsing date: %v", err)
+	}
+
+	records, err := s.weather.GetRange(ctx, from, to)
+	if err != nil {
+		return DegreeDaysResponse{}, err
+	}
+
+	storedUnit := temperatureUnit(columnsConfig)
+	temperatures := map[time.Time]float64{}
+	for _, record := range records {
+		date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+		if err != nil {
+			return DegreeDaysResponse{}, err
+		}
+		temperatures[date] = ConvertTemperature(record.Temperature, storedUnit, options.Unit)
+	}
+
+	response := DegreeDaysResponse{
+		Base:    options.Base,
+		Unit:    options.Unit,
+		Bucket:  options.Bucket,
+		Buckets: []DegreeDayBucket{},
+	}
+	expectedTotal, observedTotal := 0, 0
+
+	for start := bucketStart(fromDate, options.Bucket); !start.After(toDate); start = nextBucketStart(start, options.Bucket) {
+		if err := ctx.Err(); err != nil {
+			return DegreeDaysResponse{}, err
+		}
+		end := nextBucketStart(start, options.Bucket).AddDate(0, 0, -1)
+		clippedStart := maxDate(start, fromDate)
+		clippedEnd := minDate(end, toDate)
+
+		bucket := DegreeDayBucket{
+			Start: clippedStart.Format(columnsConfig.DateFormat),
+			End:   clippedEnd.Format(columnsConfig.DateFormat),
+		}
+		expected, observed := 0, 0
+		for day := clippedStart; !day.After(clippedEnd); day = day.AddDate(0, 0, 1) {
+			expected++
+			temperature, ok := temperatures[day]
+			if !ok {
+				continue
+			}
+			observed++
+			bucket.HDD += max(0, options.Base-temperature)
+			bucket.CDD += max(0, temperature-options.Base)
+		}
+
+		response.Totals.HDD += bucket.HDD
+		response.Totals.CDD += bucket.CDD
+		bucket.CumulativeHDD = response.Totals.HDD
+		bucket.CumulativeCDD = response.Totals.CDD
+		bucket.DegreeDayCoverage = newCoverage(expected, observed)
+		response.Buckets = append(response.Buckets, bucket)
+
+		expectedTotal += expected
+		observedTotal += observed
+	}
+	response.Totals.DegreeDayCoverage = newCoverage(expectedTotal, observedTotal)
+
+	return response, nil
+}
+
+func maxDate(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minDate(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"weatherapi/configs"
+	"weatherapi/models"
+	"weatherapi/repository"
+)
+
+type ExtremesResponse struct {
+	Highs []WeatherRecordResponse `json:"highs"`
+	Lows  []WeatherRecordResponse `json:"lows"`
+}
+
+// RecordAnnotation marks a measurement that beats the previous record of its scope
+type RecordAnnotation struct {
+	Field string `json:"field"`
+	// "high" or "low"
+	Kind string `json:"kind"`
+	// "daily" (same calendar day), "monthly" (same calendar month) or "all_time"
+	Scope    string  `json:"scope"`
+	Previous float64 `json:"previous"`
+}
+
+// GetExtremes returns the n highest and lowest records of every measurement in the given range
+func (s *Service) GetExtremes(ctx context.Context, from string, to string, n int) (map[string]ExtremesResponse, error) {
+	columnsConfig := s.columns
+
+	records, err := s.weather.GetRange(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]ExtremesResponse{}
+	for _, measurement := range columnsConfig.Measurements {
+		highs := topRecords(records, measurement.Name, n, func(a float64, b float64) bool { return a > b })
+		lows := topRecords(records, measurement.Name, n, func(a float64, b float64) bool { return a < b })
+
+		formattedHighs, err := getFormattedWeatherRecordUnits(&highs, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		formattedLows, err := getFormattedWeatherRecordUnits(&lows, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		results[strings.ToLower(measurement.Name)] = ExtremesResponse{
+			Highs: append([]WeatherRecordResponse{}, formattedHighs...),
+			Lows:  append([]WeatherRecordResponse{}, formattedLows...),
+		}
+	}
+	return results, nil
+}
+
+// topRecords returns the first n records when ordered by the measurement, ties keep the order of the records by date
+func topRecords(records []models.Weather, measurement string, n int, before func(a float64, b float64) bool) []models.Weather {
+	sorted := slices.Clone(records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := sorted[i].Measurement(measurement)
+		b, _ := sorted[j].Measurement(measurement)
+		return before(a, b)
+	})
+	return sorted[:min(n, len(sorted))]
+}
+
+// findNewRecords compares the record against the records of all other dates and returns the daily, monthly and all-time records
+// it beats. Scopes without any previous value are skipped, so the first reading of a day does not count as a record.
+// The previous records are aggregated by the repository, which also holds the records written before in its transaction.
+func findNewRecords(ctx context.Context, repo repository.WeatherRepository, record models.Weather, columnsConfig *configs.ColumnsConfig) ([]RecordAnnotation, error) {
+	date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+	if err != nil {
+		return nil, err
+	}
+	day := date.Format("01-02")
+	var month []string
+	for monthDay := time.Date(2000, date.Month(), 1, 0, 0, 0, 0, time.UTC); monthDay.Month() == date.Month(); monthDay = monthDay.AddDate(0, 0, 1) {
+		month = append(month, monthDay.Format("01-02"))
+	}
+	before := date.AddDate(0, 0, -1).Format(columnsConfig.DateFormat)
+	after := date.AddDate(0, 0, 1).Format(columnsConfig.DateFormat)
+
+	var annotations []RecordAnnotation
+	for _, measurement := range columnsConfig.Measurements {
+		value, ok := record.Measurement(measurement.Name)
+		if !ok {
+			continue
+		}
+		scopes := map[string]repository.Aggregate{}
+		if scopes["daily"], err = repo.AggregateMonthDays(ctx, measurement.Name, []string{day}, record.RecordedAt); err != nil {
+			return nil, err
+		}
+		if scopes["monthly"], err = repo.AggregateMonthDays(ctx, measurement.Name, month, record.RecordedAt); err != nil {
+			return nil, err
+		}
+		earlier, err := repo.Aggregate(ctx, measurement.Name, "", before)
+		if err != nil {
+			return nil, err
+		}
+		later, err := repo.Aggregate(ctx, measurement.Name, after, "")
+		if err != nil {
+			return nil, err
+		}
+		scopes["all_time"] = earlier.Merge(later)
+
+		for _, scope := range []string{"daily", "monthly", "all_time"} {
+			previous := scopes[scope]
+			if previous.Count == 0 {
+				continue
+			}
+			field := strings.ToLower(measurement.Name)
+			if value > previous.Max {
+				annotations = append(annotations, RecordAnnotation{Field: field, Kind: "high", Scope: scope, Previous: previous.Max})
+			}
+			if value < previous.Min {
+				annotations = append(annotations, RecordAnnotation{Field: field, Kind: "low", Scope: scope, Previous: previous.Min})
+			}
+		}
+	}
+	return annotations, nil
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"weatherapi/configs"
+	"weatherapi/models"
+	"weatherapi/utils"
+)
+
+type ForecastInterval struct {
+	Lower RawWeatherRecordUnits `json:"lower"`
+	Upper RawWeatherRecordUnits `json:"upper"`
+}
+
+type ForecastOptions struct {
+	Model      utils.ForecastModel
+	SeasonDays int
+	Days       int
+}
+
+type ErrorMetrics struct {
+	MAE  float64 `json:"mae"`
+	RMSE float64 `json:"rmse"`
+}
+
+type BacktestResponse struct {
+	Model    utils.ForecastModel     `json:"model"`
+	Holdout  int                     `json:"holdout"`
+	Metrics  map[string]ErrorMetrics `json:"metrics"`
+	Forecast []WeatherRecordResponse `json:"forecast"`
+	Actual   []WeatherRecordResponse `json:"actual"`
+}
+
+// loadDailySeries returns the trailing segment of the stored records as an evenly spaced daily series, interpolating missing days.
+// The segment starts after the latest gap longer than FillMaxGapDays, so gaps earlier in the history do not prevent forecasts.
+func (s *Service) loadDailySeries(ctx context.Context) ([]models.Weather, error) {
+	columnsConfig := s.columns
+
+	records, err := s.weather.GetRange(ctx, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	start := len(records) - 1
+	for ; start > 0; start-- {
+		previousDate, err := parseRecordedAt(records[start-1].RecordedAt, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		currentDate, err := parseRecordedAt(records[start].RecordedAt, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if missing := int(currentDate.Sub(previousDate).Hours()/24) - 1; missing > s.conf.FillMaxGapDays {
+			break
+		}
+	}
+	return fillGaps(records[max(start, 0):], "", "", FillLinear, s.conf.FillMaxGapDays, columnsConfig)
+}
+
+// forecastFrom predicts the days following the given daily series
+func (s *Service) forecastFrom(history []models.Weather, options ForecastOptions) ([]WeatherRecordResponse, error) {
+	columnsConfig := s.columns
+
+	if len(history) == 0 {
+		return nil, utils.ErrInsufficientHistory
+	}
+
+	forecasts := map[string]utils.ForecastResult{}
+	for _, measurement := range columnsConfig.Measurements {
+		var series []float64
+		for _, record := range history {
+			value, _ := record.Measurement(measurement.Name)
+			series = append(series, value)
+		}
+		forecast, err := utils.Forecast(series, options.Model, options.SeasonDays, options.Days)
+		if err != nil {
+			return nil, err
+		}
+		forecasts[measurement.Name] = forecast
+	}
+
+	lastDate, err := parseRecordedAt(history[len(history)-1].RecordedAt, columnsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var predictions []models.Weather
+	for day := 0; day < options.Days; day++ {
+		prediction := models.Weather{RecordedAt: lastDate.AddDate(0, 0, day+1).Format(columnsConfig.DateFormat)}
+		for _, measurement := range columnsConfig.Measurements {
+			prediction.SetMeasurement(measurement.Name, clampPercentage(measurement, forecasts[measurement.Name].Point[day]))
+		}
+		predictions = append(predictions, prediction)
+	}
+
+	results, err := getFormattedWeatherRecordUnits(&predictions, columnsConfig)
+	if err != nil {
+		return nil, err
+	}
+	for day := range results {
+		interval := &ForecastInterval{}
+		for _, measurement := range columnsConfig.Measurements {
+			forecast := forecasts[measurement.Name]
+			interval.Lower.setMeasurement(measurement.Name, clampPercentage(measurement, forecast.Lower[day]))
+			interval.Upper.setMeasurement(measurement.Name, clampPercentage(measurement, forecast.Upper[day]))
+		}
+		results[day].Forecast = true
+		results[day].Interval = interval
+	}
+	return results, nil
+}
+
+// percentages such as the humidity are kept within the range accepted on ingest
+func clampPercentage(measurement configs.Measurement, value float64) float64 {
+	if measurement.Unit != "%" {
+		return value
+	}
+	return max(0, min(100, value))
+}
+
+// GetForecast predicts the days following the last stored record
+func (s *Service) GetForecast(ctx context.Context, options ForecastOptions) ([]WeatherRecordResponse, error) {
+	history, err := s.loadDailySeries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.forecastFrom(history, options)
+}
+
+// Backtest fits the model on all but the last holdout days and compares its forecast against them
+func (s *Service) Backtest(ctx context.Context, options ForecastOptions, holdout int) (BacktestResponse, error) {
+	columnsConfig := s.columns
+
+	history, err := s.loadDailySeries(ctx)
+	if err != nil {
+		return BacktestResponse{}, err
+	}
+	if len(history) <= holdout {
+		return BacktestResponse{}, utils.ErrInsufficientHistory
+	}
+
+	training := history[:len(history)-holdout]
+	holdoutRecords := history[len(history)-holdout:]
+
+	options.Days = holdout
+	forecast, err := s.forecastFrom(training, options)
+	if err != nil {
+		return BacktestResponse{}, err
+	}
+	actual, err := getFormattedWeatherRecordUnits(&holdoutRecords, columnsConfig)
+	if err != nil {
+		return BacktestResponse{}, err
+	}
+
+	metrics := map[string]ErrorMetrics{}
+	for _, measurement := range columnsConfig.Measurements {
+		var actualValues, predictedValues []float64
+		for day := range actual {
+			actualValue, _ := actual[day].Raw.Measurement(measurement.Name)
+			predictedValue, _ := forecast[day].Raw.Measurement(measurement.Name)
+			actualValues = append(actualValues, actualValue)
+			predictedValues = append(predictedValues, predictedValue)
+		}
+		metrics[strings.ToLower(measurement.Name)] = ErrorMetrics{
+			MAE:  utils.MAE(actualValues, predictedValues),
+			RMSE: utils.RMSE(actualValues, predictedValues),
+		}
+	}
+
+	return BacktestResponse{
+		Model:    options.Model,
+		Holdout:  holdout,
+		Metrics:  metrics,
+		Forecast: forecast,
+		Actual:   actual,
+	}, nil
+}
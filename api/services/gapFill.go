@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"weatherapi/configs"
+	"weatherapi/models"
+)
+
+type FillMethod string
+
+const (
+	// the zero value disables filling
+	FillNone     FillMethod = ""
+	FillLinear   FillMethod = "linear"
+	FillPrevious FillMethod = "previous"
+)
+
+var ErrGapTooLarge = errors.New("gap exceeds the maximum number of days that may be filled")
+
+func ParseFillMethod(value string) (FillMethod, error) {
+	switch FillMethod(value) {
+	case FillNone, "none":
+		return FillNone, nil
+	case FillLinear, FillPrevious:
+		return FillMethod(value), nil
+	}
+	return "", fmt.Errorf("unknown fill method: %s", value)
+}
+
+// fillGaps synthesizes records for the days missing between from and to, an empty bound leaves the range open on that side.
+// The records may include readings outside of the range, which interpolate the days at its edges like the days between
+// two readings in it. Days at an edge without a reading beyond it get the values of the nearest reading. Only the days
+// in the range are returned, and a gap longer than maxGapDays is refused when any of its days lies in the range.
+// The records are expected to be sorted by date.
+func fillGaps(records []models.Weather, from string, to string, method FillMethod, maxGapDays int, columnsConfig *configs.ColumnsConfig) ([]models.Weather, error) {
+	if len(records) == 0 {
+		return records, nil
+	}
+	dates := make([]time.Time, len(records))
+	for i, record := range records {
+		date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		dates[i] = date
+	}
+	first, last := dates[0], dates[len(dates)-1]
+	if from != "" {
+		date, err := time.Parse(columnsConfig.DateFormat, from)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date: %v", err)
+		}
+		first = date
+	}
+	if to != "" {
+		date, err := time.Parse(columnsConfig.DateFormat, to)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date: %v", err)
+		}
+		last = date
+	}
+	inRange := func(date time.Time) bool {
+		return !date.Before(first) && !date.After(last)
+	}
+
+	filled := []models.Weather{}
+	// fill synthesizes the missing days after the start of the gap, interpolating towards the end of the gap
+	fill := func(start time.Time, previous models.Weather, next models.Weather, span int) error {
+		missing := span - 1
+		if missing > maxGapDays && !start.AddDate(0, 0, 1).After(last) && !start.AddDate(0, 0, missing).Before(first) {
+			return fmt.Errorf("%w: %d days missing after %s", ErrGapTooLarge, missing, start.Format(columnsConfig.DateFormat))
+		}
+		for day := 1; day <= missing; day++ {
+			date := start.AddDate(0, 0, day)
+			if !inRange(date) {
+				continue
+			}
+			synthetic := models.Weather{
+				RecordedAt: date.Format(columnsConfig.DateFormat),
+				Synthetic:  true,
+			}
+			switch method {
+			case FillLinear:
+				weight := float64(day) / float64(span)
+				synthetic.Humidity = previous.Humidity + (next.Humidity-previous.Humidity)*weight
+				synthetic.Temperature = previous.Temperature + (next.Temperature-previous.Temperature)*weight
+			case FillPrevious:
+				synthetic.Humidity = previous.Humidity
+				synthetic.Temperature = previous.Temperature
+			}
+			filled = append(filled, synthetic)
+		}
+		return nil
+	}
+
+	// the days before the first reading and after the last one have a single neighbour, whose values they get
+	if span := int(dates[0].Sub(first).Hours()/24) + 1; span > 1 {
+		if err := fill(first.AddDate(0, 0, -1), records[0], records[0], span); err != nil {
+			return nil, err
+		}
+	}
+	for i, record := range records {
+		if i > 0 {
+			if err := fill(dates[i-1], records[i-1], record, int(dates[i].Sub(dates[i-1]).Hours()/24)); err != nil {
+				return nil, err
+			}
+		}
+		if inRange(dates[i]) {
+			filled = append(filled, record)
+		}
+	}
+	if span := int(last.Sub(dates[len(dates)-1]).Hours()/24) + 1; span > 1 {
+		if err := fill(dates[len(dates)-1], records[len(records)-1], records[len(records)-1], span); err != nil {
+			return nil, err
+		}
+	}
+	return filled, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"weatherapi/migrations"
+	"weatherapi/server"
+)
+
+const (
+	CheckOk     = "ok"
+	CheckFailed = "failed"
+)
+
+// timeout of a single dependency check, orchestrators probe often and must not pile up requests
+const checkTimeout = 2 * time.Second
+
+type CheckResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+type StatusResponse struct {
+	Version          string    `json:"version"`
+	StartedAt        time.Time `json:"started_at"`
+	UptimeSeconds    float64   `json:"uptime_seconds"`
+	DbDialect        string    `json:"db_dialect"`
+	WebSocketClients int       `json:"websocket_clients"`
+	// creation time of the most recently stored record, null without records
+	LastIngestion *time.Time    `json:"last_ingestion"`
+	Checks        []CheckResult `json:"checks"`
+}
+
+func runCheck(ctx context.Context, name string, check func(ctx context.Context) error) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{Name: name, Status: CheckOk, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = CheckFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (s *Service) checkDatabase(ctx context.Context) error {
+	sqlDb, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
+
+func (s *Service) checkMigrations(ctx context.Context) error {
+	return migrations.Check(ctx, s.db)
+}
+
+func (s *Service) checkColumns(ctx context.Context) error {
+	columnsConfig := s.columns
+	if columnsConfig.DateFormat == "" || len(columnsConfig.Measurements) == 0 {
+		return fmt.Errorf("columns.yaml defines no date format or measurements")
+	}
+	return nil
+}
+
+// ReadinessChecks checks every dependency needed to serve requests
+func (s *Service) ReadinessChecks(ctx context.Context) ReadinessResponse {
+	response := ReadinessResponse{
+		Status: CheckOk,
+		Checks: []CheckResult{
+			runCheck(ctx, "database", s.checkDatabase),
+			runCheck(ctx, "migrations", s.checkMigrations),
+			runCheck(ctx, "columns", s.checkColumns),
+		},
+	}
+	for _, check := range response.Checks {
+		if check.Status != CheckOk {
+			response.Status = CheckFailed
+		}
+	}
+	return response
+}
+
+func (s *Service) lastIngestion(ctx context.Context) (*time.Time, error) {
+	return s.weather.GetLastCreatedAt(ctx)
+}
+
+func (s *Service) GetStatus(ctx context.Context, now time.Time) StatusResponse {
+	response := StatusResponse{
+		Version:          server.Version,
+		StartedAt:        server.StartedAt,
+		UptimeSeconds:    now.Sub(server.StartedAt).Seconds(),
+		DbDialect:        s.db.Dialector.Name(),
+		WebSocketClients: server.ConnectedClients(),
+		Checks:           s.ReadinessChecks(ctx).Checks,
+	}
+
+	ingestion := runCheck(ctx, "last_ingestion", func(ctx context.Context) error {
+		var err error
+		response.LastIngestion, err = s.lastIngestion(ctx)
+		return err
+	})
+	response.Checks = append(response.Checks, ingestion)
+	return response
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"weatherapi/configs"
+	"weatherapi/utils"
+)
+
+type HistogramValue struct {
+	Raw       float64 `json:"raw"`
+	Formatted string  `json:"formatted"`
+}
+
+type HistogramBin struct {
+	Lower HistogramValue `json:"lower"`
+	Upper HistogramValue `json:"upper"`
+	Count int            `json:"count"`
+}
+
+type HistogramResponse struct {
+	Field string         `json:"field"`
+	Unit  string         `json:"unit"`
+	Count int            `json:"count"`
+	Bins  []HistogramBin `json:"bins"`
+	// values outside of explicitly requested bin edges
+	Below       int                       `json:"below"`
+	Above       int                       `json:"above"`
+	Percentiles map[string]HistogramValue `json:"percentiles"`
+}
+
+var histogramPercentiles = map[string]float64{"p5": 0.05, "p25": 0.25, "p50": 0.50, "p75": 0.75, "p95": 0.95}
+
+// loadMeasurement returns the values of a single measurement in the given range, ordered by date
+func (s *Service) loadMeasurement(ctx context.Context, measurement configs.Measurement, from string, to string) ([]float64, error) {
+	records, err := s.weather.GetRange(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []float64
+	for _, record := range records {
+		value, ok := record.Measurement(measurement.Name)
+		if !ok {
+			return nil, fmt.Errorf("measurement %s is not stored", measurement.Name)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// GetHistogram returns the distribution of a measurement, using the given bin edges or else the given number of equal width bins
+func (s *Service) GetHistogram(ctx context.Context, measurement configs.Measurement, from string, to string, bins int, edges []float64) (HistogramResponse, error) {
+	values, err := s.loadMeasurement(ctx, measurement, from, to)
+	if err != nil {
+		return HistogramResponse{}, err
+	}
+
+	format := func(value float64) HistogramValue {
+		return HistogramValue{Raw: value, Formatted: utils.FormatFloat(value, measurement.Unit)}
+	}
+
+	response := HistogramResponse{
+		Field:       strings.ToLower(measurement.Name),
+		Unit:        measurement.Unit,
+		Count:       len(values),
+		Bins:        []HistogramBin{},
+		Percentiles: map[string]HistogramValue{},
+	}
+	if len(values) == 0 {
+		return response, nil
+	}
+
+	if edges == nil {
+		edges = utils.EqualWidthEdges(utils.Quantile(values, 0), utils.Quantile(values, 1), bins)
+	}
+	counts, below, above := utils.Histogram(values, edges)
+	for i, count := range counts {
+		response.Bins = append(response.Bins, HistogramBin{
+			Lower: format(edges[i]),
+			Upper: format(edges[i+1]),
+			Count: count,
+		})
+	}
+	response.Below = below
+	response.Above = above
+
+	for name, q := range histogramPercentiles {
+		response.Percentiles[name] = format(utils.Quantile(values, q))
+	}
+	return response, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"time"
+	"weatherapi/models"
+)
+
+// RevisionResponse is a change of the record of a date, before is omitted for creates and after for deletes
+type RevisionResponse struct {
+	Operation string                 `json:"operation"`
+	Before    *RawWeatherRecordUnits `json:"before,omitempty"`
+	After     *RawWeatherRecordUnits `json:"after,omitempty"`
+	// quality state and provenance of the record before and after the change
+	BeforeQuality    *string     `json:"before_quality,omitempty"`
+	AfterQuality     *string     `json:"after_quality,omitempty"`
+	BeforeProvenance *Provenance `json:"before_provenance,omitempty"`
+	AfterProvenance  *Provenance `json:"after_provenance,omitempty"`
+	Actor            string      `json:"actor"`
+	Source           string      `json:"source"`
+	ChangedAt        time.Time   `json:"changed_at"`
+}
+
+// Provenance is where the values of a record came from, see models.Weather
+type Provenance struct {
+	Source     string `json:"source,omitempty"`
+	BatchID    string `json:"batch_id,omitempty"`
+	Instrument string `json:"instrument,omitempty"`
+}
+
+// revisionProvenance returns the provenance of one side of a revision, nil when that side has none or it was not recorded
+func revisionProvenance(source *string, batchID *string, instrument *string) *Provenance {
+	if source == nil || batchID == nil || instrument == nil {
+		return nil
+	}
+	return &Provenance{Source: *source, BatchID: *batchID, Instrument: *instrument}
+}
+
+// revisionUnits returns the values of one side of a revision, nil when that side has none
+func revisionUnits(humidity *float64, temperature *float64) *RawWeatherRecordUnits {
+	if humidity == nil || temperature == nil {
+		return nil
+	}
+	return &RawWeatherRecordUnits{Humidity: *humidity, Temperature: *temperature}
+}
+
+// GetWeatherHistory returns every change of the record of the date, oldest first. A date that was deleted and created again
+// has the revisions of both records.
+func (s *Service) GetWeatherHistory(ctx context.Context, date string) ([]RevisionResponse, error) {
+	revisions, err := s.weather.GetRevisions(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	history := []RevisionResponse{}
+	for _, revision := range revisions {
+		history = append(history, newRevisionResponse(revision))
+	}
+	return history, nil
+}
+
+func newRevisionResponse(revision models.WeatherRevision) RevisionResponse {
+	return RevisionResponse{
+		Operation:        revision.Operation,
+		Before:           revisionUnits(revision.BeforeHumidity, revision.BeforeTemperature),
+		After:            revisionUnits(revision.AfterHumidity, revision.AfterTemperature),
+		BeforeQuality:    revision.BeforeQuality,
+		AfterQuality:     revision.AfterQuality,
+		BeforeProvenance: revisionProvenance(revision.BeforeSource, revision.BeforeBatchID, revision.BeforeInstrument),
+		AfterProvenance:  revisionProvenance(revision.AfterSource, revision.AfterBatchID, revision.AfterInstrument),
+		Actor:            revision.Actor,
+		Source:           revision.Source,
+		ChangedAt:        revision.CreatedAt.UTC(),
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"weatherapi/models"
+
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with the idempotency key is in progress")
+)
+
+// StoredResponse is the response to the first request with an idempotency key
+type StoredResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// BeginIdempotentRequest claims the key of the actor for a request, or returns the stored response of the request that claimed it first.
+// A nil response means the caller handles the request, and has to complete or release the key afterwards with the time of the
+// claim. The claim is leased to the request, once the lease passed without a response, e.g. because the process died, the key
+// can be claimed again. The fingerprint identifies the request, reusing a key for a different request fails with
+// ErrIdempotencyKeyReused.
+func (s *Service) BeginIdempotentRequest(ctx context.Context, actor string, key string, fingerprint string, now time.Time, lease time.Duration) (*StoredResponse, error) {
+	db := s.db.WithContext(ctx)
+	now = claimTime(now)
+
+	// expired keys and leases are removed first, so their requests are handled again
+	if err := db.Where("expires_at <= ?", now).Delete(&models.IdempotencyKey{}).Error; err != nil {
+		return nil, fmt.Errorf("error removing expired idempotency keys: %v", err)
+	}
+
+	claim := models.IdempotencyKey{
+		Actor:          actor,
+		IdempotencyKey: key,
+		Fingerprint:    fingerprint,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(lease),
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&claim)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error claiming idempotency key: %v", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	var existing models.IdempotencyKey
+	result = db.Limit(1).Find(&existing, "actor = ? AND idempotency_key = ?", actor, key)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error loading idempotency key: %v", result.Error)
+	}
+	// the first request may have failed and released the key since, in which case the request can be retried
+	if result.RowsAffected == 0 {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if existing.Status == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return &StoredResponse{Status: *existing.Status, ContentType: existing.ContentType, Body: []byte(existing.Body)}, nil
+}
+
+// claimTime identifies a claim by its creation time, at the resolution both dialects store
+func claimTime(now time.Time) time.Time {
+	return now.UTC().Truncate(time.Microsecond)
+}
+
+// CompleteIdempotentRequest stores the response of the request that claimed the key at the given time, and keeps it for
+// IDEMPOTENCY_TTL. A request whose lease passed no longer holds the key, its response is not stored.
+func (s *Service) CompleteIdempotentRequest(ctx context.Context, actor string, key string, claimedAt time.Time, response StoredResponse, now time.Time) error {
+	return s.db.WithContext(ctx).Model(&models.IdempotencyKey{}).
+		Where("actor = ? AND idempotency_key = ? AND created_at = ? AND status IS NULL", actor, key, claimTime(claimedAt)).
+		Updates(map[string]any{
+			"status":       response.Status,
+			"content_type": response.ContentType,
+			"body":         string(response.Body),
+			"expires_at":   now.UTC().Add(s.conf.IdempotencyTTL),
+		}).Error
+}
+
+// ReleaseIdempotencyKey removes the claim of a request that failed, so it can be retried
+func (s *Service) ReleaseIdempotencyKey(ctx context.Context, actor string, key string, claimedAt time.Time) error {
+	return s.db.WithContext(ctx).
+		Where("actor = ? AND idempotency_key = ? AND created_at = ? AND status IS NULL", actor, key, claimTime(claimedAt)).
+		Delete(&models.IdempotencyKey{}).Error
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"log/slog"
+	"time"
+	"weatherapi/metrics"
+
+	"gorm.io/gorm"
+)
+
+var (
+	recordsCreated  = metrics.Counter("weather_records_created_total", "Number of weather records created")
+	recordsUpdated  = metrics.Counter("weather_records_updated_total", "Number of weather records updated")
+	recordsDeleted  = metrics.Counter("weather_records_deleted_total", "Number of weather records deleted")
+	dbQueryDuration = metrics.Histogram("db_query_duration_seconds", "Duration of database queries in seconds", metrics.DefaultBuckets, "operation", "table")
+)
+
+const queryStartKey = "metrics:query_start"
+
+// InstrumentDb records the duration of every query of the database in db_query_duration_seconds.
+// Instrumenting the same database again has no effect.
+func InstrumentDb(db *gorm.DB) {
+	callbacks := db.Callback()
+	if callbacks.Query().Get("metrics:after_query") != nil {
+		return
+	}
+
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(queryStartKey, time.Now())
+	}
+	after := func(operation string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			start, ok := tx.InstanceGet(queryStartKey)
+			if !ok {
+				return
+			}
+			dbQueryDuration.Observe(time.Since(start.(time.Time)).Seconds(), operation, tx.Statement.Table)
+		}
+	}
+
+	errs := []error{
+		callbacks.Create().Before("gorm:create").Register("metrics:before_create", before),
+		callbacks.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		callbacks.Query().Before("gorm:query").Register("metrics:before_query", before),
+		callbacks.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		callbacks.Update().Before("gorm:update").Register("metrics:before_update", before),
+		callbacks.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		callbacks.Row().Before("gorm:row").Register("metrics:before_row", before),
+		callbacks.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		callbacks.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		callbacks.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	}
+	for _, err := range errs {
+		if err != nil {
+			slog.Error("Error instrumenting database", "error", err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"weatherapi/configs"
+	"weatherapi/models"
+	"weatherapi/utils"
+
+	"gorm.io/gorm"
+)
+
+const daysPerYear = 365
+
+type NormalUnits struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P10   float64 `json:"p10"`
+	P25   float64 `json:"p25"`
+	P50   float64 `json:"p50"`
+	P75   float64 `json:"p75"`
+	P90   float64 `json:"p90"`
+}
+
+type NormalResponse struct {
+	DayOfYear    int                    `json:"doy"`
+	Measurements map[string]NormalUnits `json:"measurements"`
+}
+
+// dayDistance returns the number of days between two days of the year, wrapping around the turn of the year
+func dayDistance(a int, b int) int {
+	distance := a - b
+	if distance < 0 {
+		distance = -distance
+	}
+	return min(distance, daysPerYear-distance)
+}
+
+func isInReferencePeriod(year int, conf *configs.Config) bool {
+	if conf.NormalsStartYear != 0 && year < conf.NormalsStartYear {
+		return false
+	}
+	if conf.NormalsEndYear != 0 && year > conf.NormalsEndYear {
+		return false
+	}
+	return true
+}
+
+// affectedDaysOfYear returns all days of the year whose normals pool values recorded on the given day of the year
+func affectedDaysOfYear(dayOfYear int, windowDays int) []int {
+	var days []int
+	for day := 1; day <= daysPerYear; day++ {
+		if dayDistance(day, dayOfYear) <= windowDays {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// recomputeNormals rebuilds the materialized normals of the given days of the year from the records in the reference period
+func (s *Service) recomputeNormals(tx *gorm.DB, records []models.Weather, daysOfYear []int) error {
+	conf := s.conf
+	columnsConfig := s.columns
+
+	recordsByDay := map[int][]models.Weather{}
+	for _, record := range records {
+		date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+		if err != nil {
+			return err
+		}
+		if isInReferencePeriod(date.Year(), conf) {
+			day := utils.DayOfYear(date)
+			recordsByDay[day] = append(recordsByDay[day], record)
+		}
+	}
+
+	var normals []models.WeatherNormal
+	for _, day := range daysOfYear {
+		var pooled []models.Weather
+		for offset := -conf.NormalsWindowDays; offset <= conf.NormalsWindowDays; offset++ {
+			// wrap around the turn of the year, day 0 is day 365
+			pooledDay := ((day+offset-1)%daysPerYear+daysPerYear)%daysPerYear + 1
+			pooled = append(pooled, recordsByDay[pooledDay]...)
+		}
+		if len(pooled) == 0 {
+			continue
+		}
+
+		for _, measurement := range columnsConfig.Measurements {
+			var values []float64
+			for _, record := range pooled {
+				value, _ := record.Measurement(measurement.Name)
+				values = append(values, value)
+			}
+			normals = append(normals, models.WeatherNormal{
+				DayOfYear: day,
+				Field:     strings.ToLower(measurement.Name),
+				Count:     len(values),
+				Mean:      utils.Mean(values),
+				P10:       utils.Quantile(values, 0.10),
+				P25:       utils.Quantile(values, 0.25),
+				P50:       utils.Quantile(values, 0.50),
+				P75:       utils.Quantile(values, 0.75),
+				P90:       utils.Quantile(values, 0.90),
+			})
+		}
+	}
+
+	if err := tx.Where("day_of_year IN ?", daysOfYear).Delete(&models.WeatherNormal{}).Error; err != nil {
+		return fmt.Errorf("error deleting normals: %v", err)
+	}
+	if len(normals) == 0 {
+		return nil
+	}
+	if err := tx.Create(&normals).Error; err != nil {
+		return fmt.Errorf("error storing normals: %v", err)
+	}
+	return nil
+}
+
+// updateNormals incrementally recomputes the normals that pool values from the calendar days of the records.
+// Only the records of the days pooled by those normals are loaded, once for all records.
+func (s *Service) updateNormals(ctx context.Context, records ...models.Weather) error {
+	conf := s.conf
+	columnsConfig := s.columns
+
+	affected := map[int]bool{}
+	for _, record := range records {
+		date, err := parseRecordedAt(record.RecordedAt, columnsConfig)
+		if err != nil {
+			return err
+		}
+		if !isInReferencePeriod(date.Year(), conf) {
+			continue
+		}
+		for _, day := range affectedDaysOfYear(utils.DayOfYear(date), conf.NormalsWindowDays) {
+			affected[day] = true
+		}
+	}
+	if len(affected) == 0 {
+		return nil
+	}
+
+	// the normals of the affected days pool the days within the window around them
+	pooled := map[int]bool{}
+	for day := range affected {
+		for _, pooledDay := range affectedDaysOfYear(day, conf.NormalsWindowDays) {
+			pooled[pooledDay] = true
+		}
+	}
+	pooledRecords, err := s.weather.GetByMonthDays(ctx, utils.MonthDays(slices.Sorted(maps.Keys(pooled))))
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.recomputeNormals(tx, pooledRecords, slices.Sorted(maps.Keys(affected)))
+	})
+}
+
+// RecomputeAllNormals rebuilds the normals of every day of the year
+func (s *Service) RecomputeAllNormals(ctx context.Context) error {
+	db := s.db.WithContext(ctx)
+
+	records, err := s.weather.GetRange(ctx, "", "")
+	if err != nil {
+		return err
+	}
+
+	var days []int
+	for day := 1; day <= daysPerYear; day++ {
+		days = append(days, day)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return s.recomputeNormals(tx, records, days)
+	})
+	if err == nil {
+		// departures from normal are part of cached results
+		s.InvalidateCache()
+		slog.InfoContext(ctx, "Recomputed normals of every day of the year")
+	}
+	return err
+}
+
+func loadNormals(db *gorm.DB, daysOfYear []int) (map[int]map[string]models.WeatherNormal, error) {
+	var normals []models.WeatherNormal
+	query := db.Order("day_of_year").Order("field")
+	if daysOfYear != nil {
+		query = query.Where("day_of_year IN ?", daysOfYear)
+	}
+	if err := query.Find(&normals).Error; err != nil {
+		return nil, fmt.Errorf("error loading normals: %v", err)
+	}
+
+	byDay := map[int]map[string]models.WeatherNormal{}
+	for _, normal := range normals {
+		if byDay[normal.DayOfYear] == nil {
+			byDay[normal.DayOfYear] = map[string]models.WeatherNormal{}
+		}
+		byDay[normal.DayOfYear][normal.Field] = normal
+	}
+	return byDay, nil
+}
+
+// GetNormals returns the normals of the given day of the year, or of all days when dayOfYear is 0
+func (s *Service) GetNormals(ctx context.Context, dayOfYear int) ([]NormalResponse, error) {
+	db := s.db.WithContext(ctx)
+
+	var days []int
+	if dayOfYear != 0 {
+		days = []int{dayOfYear}
+	}
+	byDay, err := loadNormals(db, days)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []NormalResponse{}
+	for day := 1; day <= daysPerYear; day++ {
+		normals, ok := byDay[day]
+		if !ok {
+			continue
+		}
+		result := NormalResponse{DayOfYear: day, Measurements: map[string]NormalUnits{}}
+		for field, normal := range normals {
+			result.Measurements[field] = NormalUnits{
+				Count: normal.Count,
+				Mean:  normal.Mean,
+				P10:   normal.P10,
+				P25:   normal.P25,
+				P50:   normal.P50,
+				P75:   normal.P75,
+				P90:   normal.P90,
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// addDepartures sets each record's deviation from the normal of its day of the year, when a normal exists
+func addDepartures(db *gorm.DB, results []WeatherRecordResponse, columnsConfig *configs.ColumnsConfig) error {
+	byDay, err := loadNormals(db, nil)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range results {
+		date, err := parseRecordedAt(result.Date, columnsConfig)
+		if err != nil {
+			return err
+		}
+		normals := byDay[utils.DayOfYear(date)]
+		// a departure needs the normals of every measurement
+		departure := &RawWeatherRecordUnits{}
+		for _, measurement := range columnsConfig.Measurements {
+			normal, hasNormal := normals[strings.ToLower(measurement.Name)]
+			value, hasValue := result.Raw.Measurement(measurement.Name)
+			if !hasNormal || !hasValue {
+				departure = nil
+				break
+			}
+			departure.setMeasurement(measurement.Name, value-normal.Mean)
+		}
+		results[i].Departure = departure
+	}
+	return nil
+}
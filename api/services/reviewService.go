@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"weatherapi/models"
+	"weatherapi/repository"
+)
+
+// ErrInvalidQualityTransition rejects a review that moves a record to a quality state it cannot reach from its current one
+var ErrInvalidQualityTransition = errors.New("invalid quality transition")
+
+// qualityTransitions lists the states a review can move a record to from each state. Corrected and estimated values are
+// replacements of suspect ones, a validated record has to be flagged as suspect before it can be replaced.
+var qualityTransitions = map[string][]string{
+	models.QualityRaw:       {models.QualityValidated, models.QualitySuspect},
+	models.QualitySuspect:   {models.QualityValidated, models.QualityCorrected, models.QualityEstimated},
+	models.QualityValidated: {models.QualitySuspect},
+	models.QualityCorrected: {models.QualityValidated, models.QualitySuspect},
+	models.QualityEstimated: {models.QualityValidated, models.QualitySuspect},
+}
+
+// ReviewWeatherRecord moves the record of the date to the quality state, the change is recorded in its history like any other
+// write. Fails with repository.ErrNotFound when the date has no record and with ErrInvalidQualityTransition when the state
+// cannot be reached from the current one.
+func (s *Service) ReviewWeatherRecord(ctx context.Context, date string, quality string) (WeatherRecordResponse, error) {
+	var reviewed models.Weather
+	var from string
+	err := s.weather.Transaction(ctx, func(tx repository.WeatherRepository) error {
+		records, err := tx.GetByDate(ctx, date)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return repository.ErrNotFound
+		}
+		reviewed = records[0]
+		from = reviewed.Quality
+		if !slices.Contains(qualityTransitions[from], quality) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidQualityTransition, from, quality)
+		}
+		reviewed.Quality = quality
+		return tx.Update(ctx, &reviewed)
+	})
+	if err != nil {
+		return WeatherRecordResponse{}, err
+	}
+
+	results, err := getFormattedWeatherRecordUnits(&[]models.Weather{reviewed}, s.columns)
+	if err != nil {
+		return WeatherRecordResponse{}, fmt.Errorf("error formatting results: %v", err)
+	}
+	// the values did not change, so the normals stay the same
+	s.InvalidateCache()
+	recordsUpdated.Inc()
+	slog.InfoContext(ctx, "Reviewed weather record", "date", date, "from", from, "to", quality)
+	return results[0], nil
+}
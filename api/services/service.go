@@ -0,0 +1,32 @@
+package services
+
+import (
+	"weatherapi/configs"
+	"weatherapi/repository"
+	"weatherapi/utils"
+
+	"gorm.io/gorm"
+)
+
+// Service runs the queries and computations of the API. Weather records are read and written through the repository,
+// the database holds the materialized normals.
+type Service struct {
+	db      *gorm.DB
+	weather repository.WeatherRepository
+	conf    *configs.Config
+	columns *configs.ColumnsConfig
+	cache   *queryCache
+	// the current time of the tokens and of the query cache, the repository and gorm take theirs from the same clock
+	clock utils.Clock
+}
+
+func New(db *gorm.DB, weather repository.WeatherRepository, conf *configs.Config, columns *configs.ColumnsConfig, clock utils.Clock) *Service {
+	return &Service{
+		db:      db,
+		weather: weather,
+		conf:    conf,
+		columns: columns,
+		cache:   newQueryCache(conf.QueryCacheSize, conf.QueryCacheTTL),
+		clock:   clock,
+	}
+}
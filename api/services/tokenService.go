@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"weatherapi/models"
+)
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// hashToken returns the stored form of a token secret
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken stores a new API token and returns it with its secret, which cannot be retrieved afterwards
+func (s *Service) CreateToken(ctx context.Context, name string) (models.ApiToken, string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return models.ApiToken{}, "", err
+	}
+	secret := hex.EncodeToString(random)
+
+	token := models.ApiToken{Name: name, TokenHash: hashToken(secret)}
+	if err := s.db.WithContext(ctx).Create(&token).Error; err != nil {
+		return models.ApiToken{}, "", err
+	}
+	return token, secret, nil
+}
+
+// ListTokens returns all tokens including the revoked ones, ordered by creation
+func (s *Service) ListTokens(ctx context.Context) ([]models.ApiToken, error) {
+	var tokens []models.ApiToken
+	err := s.db.WithContext(ctx).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeToken revokes the token with the given id, revoking a token twice keeps the time of the first revocation
+func (s *Service) RevokeToken(ctx context.Context, id uint) (models.ApiToken, error) {
+	db := s.db.WithContext(ctx)
+
+	var token models.ApiToken
+	result := db.Limit(1).Find(&token, id)
+	if result.Error != nil {
+		return models.ApiToken{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ApiToken{}, ErrTokenNotFound
+	}
+	if token.RevokedAt != nil {
+		return token, nil
+	}
+
+	now := s.clock.Now().UTC()
+	token.RevokedAt = &now
+	return token, db.Model(&token).Update("revoked_at", now).Error
+}
+
+// FindValidToken returns the token the secret belongs to, or nil when there is none or it is revoked
+func (s *Service) FindValidToken(ctx context.Context, secret string) (*models.ApiToken, error) {
+	var tokens []models.ApiToken
+	err := s.db.WithContext(ctx).
+		Where("token_hash = ? AND revoked_at IS NULL", hashToken(secret)).
+		Limit(1).Find(&tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
@@ -1,27 +1,56 @@
 package services
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
 	"strings"
 	"time"
 	"weatherapi/configs"
 	"weatherapi/models"
-	"weatherapi/server"
+	"weatherapi/repository"
 	"weatherapi/utils"
-
-	"gorm.io/gorm"
 )
 
 type WeatherRecordBody struct {
 	RecordedAt  string  `json:"date"`
 	Humidity    float64 `json:"humidity"`
 	Temperature float64 `json:"temperature"`
+	// when the values were last changed at the source, compared by on_conflict=keep_newer (default: the time of the request)
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// where the values came from, and how far they can be trusted (default: raw)
+	Source     string `json:"source,omitempty"`
+	BatchID    string `json:"batch_id,omitempty"`
+	Instrument string `json:"instrument,omitempty"`
+	Quality    string `json:"quality,omitempty"`
 }
 
 type RawWeatherRecordUnits struct {
 	Humidity    float64 `json:"humidity"`
 	Temperature float64 `json:"temperature"`
 }
+
+// Measurement returns the value of the measurement with the given name (as configured in columns.yaml)
+func (u RawWeatherRecordUnits) Measurement(name string) (float64, bool) {
+	field := reflect.ValueOf(u).FieldByName(name)
+	if !field.IsValid() || field.Kind() != reflect.Float64 {
+		return 0, false
+	}
+	return field.Float(), true
+}
+
+// setMeasurement sets the value of the measurement with the given name, like Measurement reads it
+func (u *RawWeatherRecordUnits) setMeasurement(name string, value float64) {
+	if field := reflect.ValueOf(u).Elem().FieldByName(name); field.IsValid() && field.Kind() == reflect.Float64 {
+		field.SetFloat(value)
+	}
+}
+
 type FormattedWeatherRecordUnits struct {
 	Humidity    string `json:"humidity"`
 	Temperature string `json:"temperature"`
@@ -30,22 +59,116 @@ type WeatherRecordResponse struct {
 	Date      string                      `json:"date"`
 	Raw       RawWeatherRecordUnits       `json:"raw"`
 	Formatted FormattedWeatherRecordUnits `json:"formatted"`
+	Synthetic bool                        `json:"synthetic,omitempty"`
+	// measurements flagged by anomaly detection when the record was ingested
+	AnomalyFields []string `json:"anomaly_fields,omitempty"`
+	// deviation from the climatological normal of the day, only included on request
+	Departure *RawWeatherRecordUnits `json:"departure,omitempty"`
+	// set on predicted records, together with the bounds of the prediction interval
+	Forecast bool              `json:"forecast,omitempty"`
+	Interval *ForecastInterval `json:"interval,omitempty"`
+	// daily, monthly or all-time records beaten by a newly created record
+	Records []RecordAnnotation `json:"records,omitempty"`
+	// quality state and provenance of stored records, empty on generated ones
+	Quality    string `json:"quality,omitempty"`
+	Source     string `json:"source,omitempty"`
+	BatchID    string `json:"batch_id,omitempty"`
+	Instrument string `json:"instrument,omitempty"`
+}
+
+// ValidationError rejects a weather record, Reason identifies the failed check
+type ValidationError struct {
+	Reason  string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidateWeatherRecord checks a record before it is created, the date has to be a valid day that is not after today
+func ValidateWeatherRecord(record *WeatherRecordBody, clock utils.Clock, location *time.Location) error {
+	if !utils.IsValidDate(record.RecordedAt) {
+		return &ValidationError{Reason: "invalid_date", Message: fmt.Sprintf("invalid date: %q", record.RecordedAt)}
+	}
+	if utils.IsDateInFuture(record.RecordedAt, clock, location) {
+		return &ValidationError{Reason: "future_date", Message: fmt.Sprintf("date is in the future: %s", record.RecordedAt)}
+	}
+	if record.Humidity < 0 || record.Humidity > 100 {
+		return &ValidationError{Reason: "humidity_out_of_range", Message: fmt.Sprintf("humidity out of range: %v", record.Humidity)}
+	}
+	// the other states are the outcome of a review, see qualityTransitions
+	if record.Quality != "" && record.Quality != models.QualityRaw && record.Quality != models.QualityEstimated {
+		return &ValidationError{Reason: "invalid_quality", Message: fmt.Sprintf("quality has to be raw or estimated on writes: %q", record.Quality)}
+	}
+	return nil
+}
+
+// RangeOptions holds the optional behaviour of range queries
+type RangeOptions struct {
+	Fill          FillMethod
+	WithDeparture bool
+	// reconstructs the records as they were at the time from their revisions, nil for the current records
+	AsOf *time.Time
+	// only returns the records in one of the quality states, all records when empty
+	Quality []string
+}
+
+// withQuality returns the records that are in one of the quality states
+func withQuality(records []models.Weather, quality []string) []models.Weather {
+	filtered := []models.Weather{}
+	for _, record := range records {
+		if slices.Contains(quality, record.Quality) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+func parseRecordedAt(recordedAt string, columnsConfig *configs.ColumnsConfig) (time.Time, error) {
+	// support for both date and datetimes
+	layout := columnsConfig.DateFormat
+	if strings.Contains(recordedAt, "T") {
+		layout = time.RFC3339
+	}
+	date, err := time.Parse(layout, recordedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing date: %v", err)
+	}
+	return date, nil
+}
+
+// shiftDate moves the date by the number of days, an empty date stays empty like an open bound of a range
+func shiftDate(date string, days int, columnsConfig *configs.ColumnsConfig) (string, error) {
+	if date == "" {
+		return "", nil
+	}
+	parsed, err := time.Parse(columnsConfig.DateFormat, date)
+	if err != nil {
+		return "", fmt.Errorf("error parsing date: %v", err)
+	}
+	return parsed.AddDate(0, 0, days).Format(columnsConfig.DateFormat), nil
 }
 
 func getFormattedWeatherRecordUnits(weatherRecords *[]models.Weather, columnsConfig *configs.ColumnsConfig) ([]WeatherRecordResponse, error) {
 	var results []WeatherRecordResponse
 	for _, record := range *weatherRecords {
-		// support for both date and datetimes
-		layout := columnsConfig.DateFormat
-		if strings.Contains(record.RecordedAt, "T") {
-			layout = time.RFC3339
-		}
-		dateFormatted, err := time.Parse(layout, record.RecordedAt)
+		dateFormatted, err := parseRecordedAt(record.RecordedAt, columnsConfig)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing date: %v", err)
+			return nil, err
+		}
+		var anomalyFields []string
+		if record.Anomaly {
+			anomalyFields = strings.Split(record.AnomalyFields, ",")
 		}
 		results = append(results, WeatherRecordResponse{
-			Date: dateFormatted.Format(columnsConfig.DateFormat),
+			Date:          dateFormatted.Format(columnsConfig.DateFormat),
+			Synthetic:     record.Synthetic,
+			AnomalyFields: anomalyFields,
+			Quality:       record.Quality,
+			Source:        record.Source,
+			BatchID:       record.BatchID,
+			Instrument:    record.Instrument,
 			Raw: RawWeatherRecordUnits{
 				Humidity:    record.Humidity,
 				Temperature: record.Temperature,
@@ -59,49 +182,315 @@ func getFormattedWeatherRecordUnits(weatherRecords *[]models.Weather, columnsCon
 	return results, nil
 }
 
-func GetWeatherRecordsForSingleDay(from string) ([]WeatherRecordResponse, error) {
-	db := server.GetDb()
-	columnsConfig := configs.GetColumns()
+func (s *Service) GetWeatherRecordsForSingleDay(ctx context.Context, from string) (QueryResult, error) {
+	columnsConfig := s.columns
+
+	return s.cachedQuery(ctx, fmt.Sprintf("day|%s", from), func() (QueryResult, error) {
+		weatherRecords, err := s.weather.GetByDate(ctx, from)
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		results, err := getFormattedWeatherRecordUnits(&weatherRecords, columnsConfig)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		return newQueryResult(weatherRecords, results)
+	})
+}
+
+func (s *Service) GetWeatherRecordsForRange(ctx context.Context, from string, to string, options RangeOptions) (QueryResult, error) {
+	db := s.db.WithContext(ctx)
+	columnsConfig := s.columns
+
+	key := fmt.Sprintf("range|%s|%s|%s|%t", from, to, options.Fill, options.WithDeparture)
+	if options.AsOf != nil {
+		key += fmt.Sprintf("|%d", options.AsOf.UnixNano())
+	}
+	if len(options.Quality) > 0 {
+		key += "|" + strings.Join(options.Quality, ",")
+	}
+	return s.cachedQuery(ctx, key, func() (QueryResult, error) {
+		// the readings next to the range interpolate the days missing at its edges
+		queryFrom, queryTo := from, to
+		if options.Fill != FillNone {
+			var err error
+			if queryFrom, err = shiftDate(from, -s.conf.FillMaxGapDays-1, columnsConfig); err != nil {
+				return QueryResult{}, err
+			}
+			if queryTo, err = shiftDate(to, s.conf.FillMaxGapDays+1, columnsConfig); err != nil {
+				return QueryResult{}, err
+			}
+		}
+
+		var weatherRecords []models.Weather
+		var err error
+		if options.AsOf != nil {
+			weatherRecords, err = s.weather.GetRangeAsOf(ctx, queryFrom, queryTo, *options.AsOf)
+		} else {
+			weatherRecords, err = s.weather.GetRange(ctx, queryFrom, queryTo)
+		}
+		if err != nil {
+			return QueryResult{}, err
+		}
+		if len(options.Quality) > 0 {
+			weatherRecords = withQuality(weatherRecords, options.Quality)
+		}
+
+		if options.Fill != FillNone {
+			filled, err := fillGaps(weatherRecords, from, to, options.Fill, s.conf.FillMaxGapDays, columnsConfig)
+			if err != nil {
+				return QueryResult{}, err
+			}
+			weatherRecords = filled
+		}
+
+		results, err := getFormattedWeatherRecordUnits(&weatherRecords, columnsConfig)
+		if err != nil {
+			return QueryResult{}, err
+		}
 
-	var weatherRecords *[]models.Weather
-	db.Where("recorded_at = ?", from).Find(&weatherRecords)
+		if options.WithDeparture {
+			if err := addDepartures(db, results, columnsConfig); err != nil {
+				return QueryResult{}, err
+			}
+		}
+		return newQueryResult(weatherRecords, results)
+	})
+}
 
-	return getFormattedWeatherRecordUnits(weatherRecords, columnsConfig)
+// newWeatherRecord is the stored form of a request body
+func newWeatherRecord(body WeatherRecordBody) models.Weather {
+	record := models.Weather{
+		RecordedAt:  body.RecordedAt,
+		Humidity:    body.Humidity,
+		Temperature: body.Temperature,
+		Source:      body.Source,
+		BatchID:     body.BatchID,
+		Instrument:  body.Instrument,
+		Quality:     body.Quality,
+	}
+	// the update time of the body decides repository.ConflictKeepNewer, UTC like the timestamps of the database
+	if body.UpdatedAt != nil {
+		record.UpdatedAt = body.UpdatedAt.UTC()
+	}
+	return record
 }
 
-func GetWeatherRecordsForRange(from string, to string) ([]WeatherRecordResponse, error) {
-	db := server.GetDb()
-	columnsConfig := configs.GetColumns()
+// saveRecord scores the record against the history it is stored into, and upserts it by the strategy.
+// Records that were beaten are only reported when the record was inserted or updated.
+func (s *Service) saveRecord(ctx context.Context, tx repository.WeatherRepository, record *models.Weather, history *anomalyHistory, settings AnomalySettings, strategy repository.ConflictStrategy) (WeatherRecordResponse, repository.UpsertOutcome, error) {
+	columnsConfig := s.columns
+
+	anomalies, err := s.scoreRecord(*record, history, settings, columnsConfig)
+	if err != nil {
+		return WeatherRecordResponse{}, "", fmt.Errorf("error scoring record: %v", err)
+	}
+	flagAnomalies(record, anomalies)
 
-	var weatherRecords *[]models.Weather
-	db.Where("recorded_at >= ?", from).Where("recorded_at <= ?", to).Find(&weatherRecords)
+	newRecords, err := findNewRecords(ctx, tx, *record, columnsConfig)
+	if err != nil {
+		return WeatherRecordResponse{}, "", fmt.Errorf("error comparing against records: %v", err)
+	}
 
-	return getFormattedWeatherRecordUnits(weatherRecords, columnsConfig)
+	outcome, err := tx.Upsert(ctx, record, strategy)
+	if err != nil {
+		return WeatherRecordResponse{}, "", err
+	}
+
+	results, err := getFormattedWeatherRecordUnits(&[]models.Weather{*record}, columnsConfig)
+	if err != nil {
+		return WeatherRecordResponse{}, "", fmt.Errorf("error formatting results: %v", err)
+	}
+	result := results[0]
+	if outcome != repository.Unchanged {
+		result.Records = newRecords
+	}
+	return result, outcome, nil
 }
 
-func CreateWeatherRecord(record *WeatherRecordBody) (WeatherRecordResponse, error) {
-	db := server.GetDb()
-	columnsConfig := configs.GetColumns()
+// recordsSaved clears the cached query results, and updates the normals of the saved records. The records are already
+// committed, so a failed update of the normals is logged rather than failing the write: a retry would only conflict with the
+// saved record, while the normals are derived from the stored records and can be rebuilt with RecomputeAllNormals.
+func (s *Service) recordsSaved(ctx context.Context, records ...models.Weather) {
+	s.InvalidateCache()
+	if err := s.updateNormals(ctx, records...); err != nil {
+		slog.ErrorContext(ctx, "Error updating normals", "records", len(records), "error", err)
+	}
+}
+
+// SaveWeatherRecord writes the record, the strategy decides what happens when its date already has a record.
+// repository.ConflictError fails with repository.ErrDuplicateDate in that case.
+func (s *Service) SaveWeatherRecord(ctx context.Context, record *WeatherRecordBody, strategy repository.ConflictStrategy) (WeatherRecordResponse, repository.UpsertOutcome, error) {
+	anomalySettings, err := s.DefaultAnomalySettings()
+	if err != nil {
+		return WeatherRecordResponse{}, "", err
+	}
 
 	var result WeatherRecordResponse
+	var outcome repository.UpsertOutcome
+	weatherRecord := newWeatherRecord(*record)
+	date, err := parseRecordedAt(weatherRecord.RecordedAt, s.columns)
+	if err != nil {
+		return WeatherRecordResponse{}, "", err
+	}
+	err = s.weather.Transaction(ctx, func(tx repository.WeatherRepository) error {
+		history, err := s.loadAnomalyHistory(ctx, tx, date, date)
+		if err != nil {
+			return err
+		}
+		result, outcome, err = s.saveRecord(ctx, tx, &weatherRecord, &history, anomalySettings, strategy)
+		return err
+	})
+	if err != nil {
+		return WeatherRecordResponse{}, "", err
+	}
+
+	switch outcome {
+	case repository.Inserted:
+		recordsCreated.Inc()
+	case repository.Updated:
+		recordsUpdated.Inc()
+	}
+	if outcome != repository.Unchanged {
+		s.recordsSaved(ctx, weatherRecord)
+	}
+	slog.InfoContext(ctx, "Saved weather record", "date", result.Date, "outcome", outcome, "anomaly_fields", result.AnomalyFields, "new_records", len(result.Records))
+	return result, outcome, nil
+}
 
-	err := db.Transaction(func(tx *gorm.DB) error {
-		weatherRecord := models.Weather{
-			RecordedAt:  record.RecordedAt,
-			Humidity:    record.Humidity,
-			Temperature: record.Temperature,
+// DeleteWeatherRecord deletes the record of the date, and fails with repository.ErrNotFound when there is none
+func (s *Service) DeleteWeatherRecord(ctx context.Context, date string) error {
+	var deleted models.Weather
+	err := s.weather.Transaction(ctx, func(tx repository.WeatherRepository) error {
+		records, err := tx.GetByDate(ctx, date)
+		if err != nil {
+			return err
 		}
-		if err := tx.Create(&weatherRecord).Error; err != nil {
-			return fmt.Errorf("error creating record: %v", err)
+		if len(records) == 0 {
+			return repository.ErrNotFound
 		}
+		deleted = records[0]
+		return tx.Delete(ctx, deleted.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	recordsDeleted.Inc()
+	// the normals of the day are recomputed without the record
+	s.recordsSaved(ctx, deleted)
+	slog.InfoContext(ctx, "Deleted weather record", "date", date)
+	return nil
+}
+
+// BatchConflict is the outcome of a record of a batch whose date already has a record, with repository.ConflictError
+const BatchConflict = "conflict"
+
+// BatchResult is the outcome of a single record of a batch
+type BatchResult struct {
+	Date string `json:"date"`
+	// "inserted", "updated", "unchanged", or "conflict" when the record was not written
+	Outcome string                 `json:"outcome"`
+	Record  *WeatherRecordResponse `json:"record,omitempty"`
+}
+
+type BatchResponse struct {
+	// the ingestion batch of the records that did not name their own
+	BatchID   string        `json:"batch_id"`
+	Inserted  int           `json:"inserted"`
+	Updated   int           `json:"updated"`
+	Unchanged int           `json:"unchanged"`
+	Conflicts int           `json:"conflicts"`
+	Results   []BatchResult `json:"results"`
+}
+
+// newBatchID returns a random id for the records of a batch
+func newBatchID() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
 
-		results, err := getFormattedWeatherRecordUnits(&[]models.Weather{weatherRecord}, columnsConfig)
+// SaveWeatherRecords writes the records in order within a single transaction, the strategy decides what happens to records whose
+// date already has a record. With repository.ConflictError those are reported as conflicts, any other error rolls back the whole batch.
+func (s *Service) SaveWeatherRecords(ctx context.Context, records []WeatherRecordBody, strategy repository.ConflictStrategy) (BatchResponse, error) {
+	anomalySettings, err := s.DefaultAnomalySettings()
+	if err != nil {
+		return BatchResponse{}, err
+	}
+
+	batchID, err := newBatchID()
+	if err != nil {
+		return BatchResponse{}, err
+	}
+
+	// the history covers the dates of the whole batch
+	var from, to time.Time
+	for i, record := range records {
+		date, err := parseRecordedAt(record.RecordedAt, s.columns)
 		if err != nil {
-			return fmt.Errorf("error formatting results: %v", err)
+			return BatchResponse{}, err
+		}
+		if i == 0 || date.Before(from) {
+			from = date
+		}
+		if i == 0 || date.After(to) {
+			to = date
+		}
+	}
+
+	var response BatchResponse
+	var saved []models.Weather
+	err = s.weather.Transaction(ctx, func(tx repository.WeatherRepository) error {
+		response = BatchResponse{BatchID: batchID, Results: []BatchResult{}}
+		saved = nil
+
+		// the records of the batch are part of the history of the records after them
+		history, err := s.loadAnomalyHistory(ctx, tx, from, to)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			weatherRecord := newWeatherRecord(record)
+			if weatherRecord.BatchID == "" {
+				weatherRecord.BatchID = batchID
+			}
+			result, outcome, err := s.saveRecord(ctx, tx, &weatherRecord, &history, anomalySettings, strategy)
+			if errors.Is(err, repository.ErrDuplicateDate) {
+				response.Conflicts++
+				response.Results = append(response.Results, BatchResult{Date: record.RecordedAt, Outcome: BatchConflict})
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error saving record of %s: %w", record.RecordedAt, err)
+			}
+			response.Results = append(response.Results, BatchResult{Date: result.Date, Outcome: string(outcome), Record: &result})
+			switch outcome {
+			case repository.Inserted:
+				response.Inserted++
+			case repository.Updated:
+				response.Updated++
+			case repository.Unchanged:
+				response.Unchanged++
+				continue
+			}
+			saved = append(saved, weatherRecord)
+			if err := history.add(weatherRecord, s.columns); err != nil {
+				return err
+			}
 		}
-		result = results[0]
 		return nil
 	})
+	if err != nil {
+		return BatchResponse{}, err
+	}
 
-	return result, err
+	recordsCreated.Add(float64(response.Inserted))
+	recordsUpdated.Add(float64(response.Updated))
+	s.recordsSaved(ctx, saved...)
+	slog.InfoContext(ctx, "Saved weather records", "batch_id", batchID, "inserted", response.Inserted, "updated", response.Updated, "unchanged", response.Unchanged, "conflicts", response.Conflicts)
+	return response, nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"weatherapi/services"
+)
+
+// runTokens manages the API tokens stored in the database, which are accepted next to API_TOKEN
+func runTokens(args []string) int {
+	flags, env := newFlagSet("tokens", "tokens [flags] create -name <name>|list|revoke <id>")
+	name := flags.String("name", "", "name of the created token, e.g. who or what uses it")
+	args, code, ok := parseFlags(flags, args)
+	if !ok {
+		return code
+	}
+	if len(args) == 0 {
+		return usageError(flags, "expected create, list or revoke")
+	}
+
+	var id uint64
+	switch {
+	case args[0] == "create" && len(args) == 1:
+		if *name == "" {
+			return usageError(flags, "create needs a -name")
+		}
+	case args[0] == "list" && len(args) == 1:
+	case args[0] == "revoke" && len(args) == 2:
+		parsed, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return usageError(flags, "invalid token id: %s", args[1])
+		}
+		id = parsed
+	default:
+		return usageError(flags, "expected create, list or revoke <id>")
+	}
+
+	service, _, closeDb, code := openService(env)
+	if code != exitOK {
+		return code
+	}
+	defer closeDb()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		token, secret, err := service.CreateToken(ctx, *name)
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		fmt.Fprintf(os.Stderr, "created token %d (%s), the secret is only shown once:\n", token.ID, token.Name)
+		fmt.Println(secret)
+	case "list":
+		tokens, err := service.ListTokens(ctx)
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tCREATED AT\tREVOKED AT")
+		for _, token := range tokens {
+			revokedAt := "-"
+			if token.RevokedAt != nil {
+				revokedAt = token.RevokedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", token.ID, token.Name, token.CreatedAt.UTC().Format(time.RFC3339), revokedAt)
+		}
+		writer.Flush()
+	case "revoke":
+		token, err := service.RevokeToken(ctx, uint(id))
+		if errors.Is(err, services.ErrTokenNotFound) {
+			return fail(exitFailure, "no token with id %d", id)
+		}
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		fmt.Printf("revoked token %d (%s)\n", token.ID, token.Name)
+	}
+	return exitOK
+}
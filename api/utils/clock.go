@@ -0,0 +1,30 @@
+package utils
+
+import "time"
+
+// Clock abstracts the current time so it can be controlled in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock always returns the same time
+type FixedClock struct {
+	Time time.Time
+}
+
+func (c FixedClock) Now() time.Time {
+	return c.Time
+}
+
+// Today returns the current calendar day in the given location.
+// The day is returned as midnight UTC, which is how dates without a time are parsed, so the two can be compared.
+func Today(clock Clock, location *time.Location) time.Time {
+	now := clock.Now().In(location)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
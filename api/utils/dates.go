@@ -1,20 +1,111 @@
 package utils
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
 	"time"
 )
 
+var (
+	isoWeekPattern  = regexp.MustCompile(`^(\d{4})-W(\d{2})$`)
+	lastDaysPattern = regexp.MustCompile(`^last-(\d+)-days$`)
+)
+
 func IsValidDate(dateStr string) bool {
 	format := "2006-01-02"
 	_, err := time.Parse(format, dateStr)
 	return err == nil
 }
 
-func IsDateInFuture(dateStr string) bool {
+// IsDateInFuture reports whether the date lies after today, where today is determined by the clock in the given location
+func IsDateInFuture(dateStr string, clock Clock, location *time.Location) bool {
 	format := "2006-01-02"
 	date, err := time.Parse(format, dateStr)
 	if err != nil {
 		return false
 	}
-	return date.After(time.Now())
+	return date.After(Today(clock, location))
+}
+
+// DaysBetween returns the number of calendar days from one date to the other, negative when to lies before from.
+// Unlike a time.Duration it does not saturate, so it holds for dates centuries apart.
+func DaysBetween(from time.Time, to time.Time) int {
+	day := func(date time.Time) int64 {
+		return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+	}
+	return int(day(to) - day(from))
+}
+
+// DayOfYear returns the day of the year on a 365 day calendar.
+// In leap years February 29th shares its day with February 28th, so calendar days line up across years.
+func DayOfYear(date time.Time) int {
+	day := date.YearDay()
+	isLeapYear := time.Date(date.Year(), time.December, 31, 0, 0, 0, 0, time.UTC).YearDay() == 366
+	if isLeapYear && day >= 60 {
+		day--
+	}
+	return day
+}
+
+// MonthDays returns the calendar days, formatted as MM-DD, of the days of the year on the calendar of DayOfYear.
+// February 29th is included with February 28th, whose day of the year it shares.
+func MonthDays(daysOfYear []int) []string {
+	var monthDays []string
+	for _, day := range daysOfYear {
+		// a year that is not a leap year has a calendar day for every day of the year
+		monthDay := time.Date(2001, time.January, day, 0, 0, 0, 0, time.UTC).Format("01-02")
+		monthDays = append(monthDays, monthDay)
+		if monthDay == "02-28" {
+			monthDays = append(monthDays, "02-29")
+		}
+	}
+	return monthDays
+}
+
+// ResolveDateRange resolves a date token to the first and last day it covers. Supported tokens are
+// a day (2024-06-01), a month (2024-06), an ISO week (2024-W23), a year (2024),
+// and the relative tokens today, yesterday and last-N-days, which are resolved against the given day (see Today).
+func ResolveDateRange(token string, today time.Time) (time.Time, time.Time, error) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch token {
+	case "today":
+		return today, today, nil
+	case "yesterday":
+		yesterday := today.AddDate(0, 0, -1)
+		return yesterday, yesterday, nil
+	}
+
+	if match := lastDaysPattern.FindStringSubmatch(token); match != nil {
+		days, err := strconv.Atoi(match[1])
+		if err != nil || days < 1 {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid number of days: %s", token)
+		}
+		return today.AddDate(0, 0, -(days - 1)), today, nil
+	}
+
+	if match := isoWeekPattern.FindStringSubmatch(token); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		week, _ := strconv.Atoi(match[2])
+		// week 1 is the week containing January 4th
+		january4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+		monday := january4.AddDate(0, 0, -((int(january4.Weekday())+6)%7)+(week-1)*7)
+		// a week belongs to the year its Thursday falls in
+		if thursdayYear, _ := monday.AddDate(0, 0, 3).ISOWeek(); week < 1 || thursdayYear != year {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid ISO week: %s", token)
+		}
+		return monday, monday.AddDate(0, 0, 6), nil
+	}
+
+	if day, err := time.Parse("2006-01-02", token); err == nil {
+		return day, day, nil
+	}
+	if month, err := time.Parse("2006-01", token); err == nil {
+		return month, month.AddDate(0, 1, -1), nil
+	}
+	if year, err := time.Parse("2006", token); err == nil {
+		return year, year.AddDate(1, 0, -1), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date: %s", token)
 }
@@ -26,21 +26,97 @@ func TestDateValidity(t *testing.T) {
 	assert.Equal(t, false, input)
 }
 func TestIsDateInFuture(t *testing.T) {
+	clock := FixedClock{Time: time.Date(2024, time.June, 12, 23, 30, 0, 0, time.UTC)}
+
 	// Date in the future
-	futureDate := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
-	assert.Equal(t, true, IsDateInFuture(futureDate))
+	assert.Equal(t, true, IsDateInFuture("2024-06-13", clock, time.UTC))
 
 	// Date in the past
-	pastDate := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-	assert.Equal(t, false, IsDateInFuture(pastDate))
+	assert.Equal(t, false, IsDateInFuture("2024-06-11", clock, time.UTC))
 
 	// Today's date (should not be in the future)
-	today := time.Now().Format("2006-01-02")
-	assert.Equal(t, false, IsDateInFuture(today))
+	assert.Equal(t, false, IsDateInFuture("2024-06-12", clock, time.UTC))
+
+	// Today depends on the reference timezone: it is already the 13th in Auckland, and still the 12th in Los Angeles
+	auckland, _ := time.LoadLocation("Pacific/Auckland")
+	assert.Equal(t, false, IsDateInFuture("2024-06-13", clock, auckland))
+	losAngeles, _ := time.LoadLocation("America/Los_Angeles")
+	assert.Equal(t, true, IsDateInFuture("2024-06-13", clock, losAngeles))
 
 	// Invalid date string
-	assert.Equal(t, false, IsDateInFuture("not-a-date"))
+	assert.Equal(t, false, IsDateInFuture("not-a-date", clock, time.UTC))
 
 	// Empty string
-	assert.Equal(t, false, IsDateInFuture(""))
+	assert.Equal(t, false, IsDateInFuture("", clock, time.UTC))
+}
+
+func TestToday(t *testing.T) {
+	clock := FixedClock{Time: time.Date(2024, time.June, 12, 23, 30, 0, 0, time.UTC)}
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	assert.Equal(t, time.Date(2024, time.June, 12, 0, 0, 0, 0, time.UTC), Today(clock, time.UTC))
+	assert.Equal(t, time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC), Today(clock, tokyo))
+}
+
+func TestDaysBetween(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	assert.Equal(t, 0, DaysBetween(date(2024, time.March, 1), date(2024, time.March, 1)))
+	assert.Equal(t, 2, DaysBetween(date(2024, time.February, 28), date(2024, time.March, 1)))
+	assert.Equal(t, -1, DaysBetween(date(2024, time.March, 1), date(2024, time.February, 29)))
+	// far beyond the ~292 years a time.Duration holds
+	assert.Equal(t, 3652058, DaysBetween(date(1, time.January, 1), date(9999, time.December, 31)))
+}
+
+func TestDayOfYear(t *testing.T) {
+	assert.Equal(t, 1, DayOfYear(time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 365, DayOfYear(time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC)))
+
+	// leap years line up with common years
+	assert.Equal(t, 59, DayOfYear(time.Date(2024, time.February, 28, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 59, DayOfYear(time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 60, DayOfYear(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 365, DayOfYear(time.Date(2024, time.December, 31, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestMonthDays(t *testing.T) {
+	assert.Equal(t, []string{"01-01", "03-01", "12-31"}, MonthDays([]int{1, 60, 365}))
+	// February 29th shares its day of the year with February 28th
+	assert.Equal(t, []string{"02-28", "02-29"}, MonthDays([]int{59}))
+	assert.Empty(t, MonthDays(nil))
+}
+
+func TestResolveDateRange(t *testing.T) {
+	today := time.Date(2024, time.June, 12, 15, 30, 0, 0, time.UTC)
+	day := func(value string) time.Time {
+		date, _ := time.Parse("2006-01-02", value)
+		return date
+	}
+
+	cases := map[string][2]string{
+		"2024-06-01":   {"2024-06-01", "2024-06-01"},
+		"2024-06":      {"2024-06-01", "2024-06-30"},
+		"2024-02":      {"2024-02-01", "2024-02-29"},
+		"2024":         {"2024-01-01", "2024-12-31"},
+		"2024-W23":     {"2024-06-03", "2024-06-09"},
+		"2024-W01":     {"2024-01-01", "2024-01-07"},
+		"2021-W01":     {"2021-01-04", "2021-01-10"},
+		"2020-W53":     {"2020-12-28", "2021-01-03"},
+		"today":        {"2024-06-12", "2024-06-12"},
+		"yesterday":    {"2024-06-11", "2024-06-11"},
+		"last-7-days":  {"2024-06-06", "2024-06-12"},
+		"last-1-days":  {"2024-06-12", "2024-06-12"},
+		"last-31-days": {"2024-05-13", "2024-06-12"},
+	}
+	for token, expected := range cases {
+		from, to, err := ResolveDateRange(token, today)
+		assert.Nil(t, err, token)
+		assert.Equal(t, day(expected[0]), from, token)
+		assert.Equal(t, day(expected[1]), to, token)
+	}
+
+	for _, token := range []string{"", "not-a-date", "2024-13", "2024-W00", "2024-W53", "2024-W5", "last-0-days", "last-x-days", "2024-06-01T00:00:00", "24"} {
+		_, _, err := ResolveDateRange(token, today)
+		assert.NotNil(t, err, token)
+	}
 }
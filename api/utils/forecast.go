@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+type ForecastModel string
+
+const (
+	SeasonalNaive ForecastModel = "seasonal_naive"
+	Holt          ForecastModel = "holt"
+	HoltWinters   ForecastModel = "holt_winters"
+)
+
+var ErrInsufficientHistory = errors.New("not enough history to fit the model")
+
+// z-score of the two sided 95% prediction interval
+const predictionIntervalZ = 1.96
+
+// candidate values for the smoothing parameters, the best combination is picked by minimizing the one step ahead error
+var smoothingGrid = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+
+type ForecastResult struct {
+	Point []float64
+	// bounds of the 95% prediction interval
+	Lower []float64
+	Upper []float64
+}
+
+func ParseForecastModel(value string) (ForecastModel, error) {
+	switch ForecastModel(value) {
+	case SeasonalNaive, Holt, HoltWinters:
+		return ForecastModel(value), nil
+	}
+	return "", fmt.Errorf("unknown forecast model: %s", value)
+}
+
+// Forecast fits the model on an evenly spaced series and predicts the next horizon values.
+// season is the length of the seasonal cycle and is ignored by the non seasonal models.
+func Forecast(series []float64, model ForecastModel, season int, horizon int) (ForecastResult, error) {
+	var point []float64
+	var residuals []float64
+
+	switch model {
+	case SeasonalNaive:
+		if season < 1 || len(series) < season+1 {
+			return ForecastResult{}, ErrInsufficientHistory
+		}
+		point, residuals = seasonalNaive(series, season, horizon)
+	case Holt:
+		if len(series) < 3 {
+			return ForecastResult{}, ErrInsufficientHistory
+		}
+		sse := math.Inf(1)
+		for _, alpha := range smoothingGrid {
+			for _, beta := range smoothingGrid {
+				candidate, candidateResiduals := holt(series, alpha, beta, horizon)
+				if candidateSSE := sumOfSquares(candidateResiduals); candidateSSE < sse {
+					sse, point, residuals = candidateSSE, candidate, candidateResiduals
+				}
+			}
+		}
+	case HoltWinters:
+		if season < 2 || len(series) < 2*season+1 {
+			return ForecastResult{}, ErrInsufficientHistory
+		}
+		sse := math.Inf(1)
+		for _, alpha := range smoothingGrid {
+			for _, beta := range smoothingGrid {
+				for _, gamma := range smoothingGrid {
+					candidate, candidateResiduals := holtWinters(series, alpha, beta, gamma, season, horizon)
+					if candidateSSE := sumOfSquares(candidateResiduals); candidateSSE < sse {
+						sse, point, residuals = candidateSSE, candidate, candidateResiduals
+					}
+				}
+			}
+		}
+	default:
+		return ForecastResult{}, fmt.Errorf("unknown forecast model: %s", model)
+	}
+
+	// the interval widens with the horizon, assuming independent one step ahead errors
+	sigma := math.Sqrt(sumOfSquares(residuals) / float64(len(residuals)))
+	result := ForecastResult{Point: point}
+	for h, value := range point {
+		width := predictionIntervalZ * sigma * math.Sqrt(float64(h+1))
+		result.Lower = append(result.Lower, value-width)
+		result.Upper = append(result.Upper, value+width)
+	}
+	return result, nil
+}
+
+func sumOfSquares(values []float64) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value * value
+	}
+	return sum
+}
+
+// seasonalNaive repeats the last observed season
+func seasonalNaive(series []float64, season int, horizon int) ([]float64, []float64) {
+	var residuals []float64
+	for t := season; t < len(series); t++ {
+		residuals = append(residuals, series[t]-series[t-season])
+	}
+
+	var forecast []float64
+	for h := 0; h < horizon; h++ {
+		forecast = append(forecast, series[len(series)-season+h%season])
+	}
+	return forecast, residuals
+}
+
+// holt is double exponential smoothing with an additive trend
+func holt(series []float64, alpha float64, beta float64, horizon int) ([]float64, []float64) {
+	level := series[0]
+	trend := series[1] - series[0]
+
+	var residuals []float64
+	for t := 1; t < len(series); t++ {
+		residuals = append(residuals, series[t]-(level+trend))
+		previousLevel := level
+		level = alpha*series[t] + (1-alpha)*(level+trend)
+		trend = beta*(level-previousLevel) + (1-beta)*trend
+	}
+
+	var forecast []float64
+	for h := 1; h <= horizon; h++ {
+		forecast = append(forecast, level+float64(h)*trend)
+	}
+	return forecast, residuals
+}
+
+// holtWinters is triple exponential smoothing with an additive trend and additive seasonality
+func holtWinters(series []float64, alpha float64, beta float64, gamma float64, season int, horizon int) ([]float64, []float64) {
+	// initialize from the first two seasons
+	level := Mean(series[:season])
+	trend := (Mean(series[season:2*season]) - level) / float64(season)
+	seasonals := make([]float64, len(series))
+	for t := 0; t < season; t++ {
+		seasonals[t] = series[t] - level
+	}
+
+	var residuals []float64
+	for t := season; t < len(series); t++ {
+		residuals = append(residuals, series[t]-(level+trend+seasonals[t-season]))
+		previousLevel := level
+		level = alpha*(series[t]-seasonals[t-season]) + (1-alpha)*(level+trend)
+		trend = beta*(level-previousLevel) + (1-beta)*trend
+		seasonals[t] = gamma*(series[t]-level) + (1-gamma)*seasonals[t-season]
+	}
+
+	var forecast []float64
+	for h := 1; h <= horizon; h++ {
+		seasonal := seasonals[len(series)-season+(h-1)%season]
+		forecast = append(forecast, level+float64(h)*trend+seasonal)
+	}
+	return forecast, residuals
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeasonalNaiveForecast(t *testing.T) {
+	series := []float64{1, 2, 3, 1, 2, 3, 1, 2, 4}
+	result, err := Forecast(series, SeasonalNaive, 3, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1, 2, 4, 1}, result.Point)
+
+	// the interval surrounds the point forecast and widens with the horizon
+	for h := range result.Point {
+		assert.Less(t, result.Lower[h], result.Point[h])
+		assert.Greater(t, result.Upper[h], result.Point[h])
+	}
+	assert.Greater(t, result.Upper[3]-result.Lower[3], result.Upper[0]-result.Lower[0])
+}
+
+func TestHoltForecastFollowsTrend(t *testing.T) {
+	var series []float64
+	for i := 0; i < 30; i++ {
+		series = append(series, 10+0.5*float64(i))
+	}
+	result, err := Forecast(series, Holt, 0, 3)
+	assert.Nil(t, err)
+	assert.InDeltaSlice(t, []float64{25, 25.5, 26}, result.Point, 0.0001)
+}
+
+func TestHoltWintersForecastFollowsSeason(t *testing.T) {
+	var series []float64
+	for i := 0; i < 70; i++ {
+		series = append(series, 20+5*math.Sin(2*math.Pi*float64(i)/7))
+	}
+	result, err := Forecast(series, HoltWinters, 7, 7)
+	assert.Nil(t, err)
+	for h, value := range result.Point {
+		assert.InDelta(t, 20+5*math.Sin(2*math.Pi*float64(70+h)/7), value, 0.5)
+	}
+}
+
+func TestForecastRequiresHistory(t *testing.T) {
+	_, err := Forecast([]float64{1, 2, 3}, SeasonalNaive, 7, 1)
+	assert.ErrorIs(t, err, ErrInsufficientHistory)
+
+	_, err = Forecast([]float64{1, 2}, Holt, 0, 1)
+	assert.ErrorIs(t, err, ErrInsufficientHistory)
+
+	_, err = Forecast([]float64{1, 2, 3, 4, 5, 6, 7, 8}, HoltWinters, 7, 1)
+	assert.ErrorIs(t, err, ErrInsufficientHistory)
+}
+
+func TestParseForecastModel(t *testing.T) {
+	model, err := ParseForecastModel("holt_winters")
+	assert.Nil(t, err)
+	assert.Equal(t, HoltWinters, model)
+
+	_, err = ParseForecastModel("crystal_ball")
+	assert.NotNil(t, err)
+}
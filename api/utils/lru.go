@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a concurrency safe cache that evicts the least recently used entry once its capacity is reached
+type LRU[K comparable, V any] struct {
+	mutex    sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU creates a cache holding up to capacity entries, a capacity below 1 disables caching
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  map[K]*list.Element{},
+	}
+}
+
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.capacity < 1 {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+// Purge removes all entries
+func (c *LRU[K, V]) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.order.Init()
+	c.entries = map[K]*list.Element{}
+}
+
+func (c *LRU[K, V]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
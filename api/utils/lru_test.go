@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	cache := NewLRU[string, int](2)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+
+	// reading "a" makes "b" the least recently used entry
+	value, ok := cache.Get("a")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 1, value)
+
+	cache.Add("c", 3)
+	_, ok = cache.Get("b")
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 2, cache.Len())
+
+	// updating an entry keeps the size
+	cache.Add("a", 10)
+	value, _ = cache.Get("a")
+	assert.Equal(t, 10, value)
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Purge()
+	_, ok = cache.Get("a")
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestLRUWithoutCapacity(t *testing.T) {
+	cache := NewLRU[string, int](0)
+	cache.Add("a", 1)
+	_, ok := cache.Get("a")
+	assert.Equal(t, false, ok)
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"math"
+	"sort"
+)
+
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// StdDev returns the sample standard deviation
+func StdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return math.NaN()
+	}
+	mean := Mean(values)
+	sum := 0.0
+	for _, value := range values {
+		sum += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
+
+// Quantile returns the q-th quantile (0 <= q <= 1) using linear interpolation between closest ranks
+func Quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+// MAE returns the mean absolute error between two equally long series
+func MAE(actual []float64, predicted []float64) float64 {
+	var errors []float64
+	for i := range actual {
+		errors = append(errors, math.Abs(actual[i]-predicted[i]))
+	}
+	return Mean(errors)
+}
+
+// RMSE returns the root mean squared error between two equally long series
+func RMSE(actual []float64, predicted []float64) float64 {
+	var errors []float64
+	for i := range actual {
+		errors = append(errors, (actual[i]-predicted[i])*(actual[i]-predicted[i]))
+	}
+	return math.Sqrt(Mean(errors))
+}
+
+// EqualWidthEdges splits the range between min and max into bins of equal width and returns the bin edges
+func EqualWidthEdges(min float64, max float64, bins int) []float64 {
+	edges := make([]float64, bins+1)
+	width := (max - min) / float64(bins)
+	for i := range edges {
+		edges[i] = min + width*float64(i)
+	}
+	// avoid rounding errors excluding the maximum
+	edges[bins] = max
+	return edges
+}
+
+// Histogram counts the values per bin, where bin i covers [edges[i], edges[i+1]) and the last bin includes its upper edge.
+// Values outside of the edges are counted separately.
+func Histogram(values []float64, edges []float64) (counts []int, below int, above int) {
+	counts = make([]int, len(edges)-1)
+	for _, value := range values {
+		if value < edges[0] {
+			below++
+			continue
+		}
+		if value > edges[len(edges)-1] {
+			above++
+			continue
+		}
+		bin := sort.SearchFloat64s(edges, value)
+		// SearchFloat64s returns the index of the first edge >= value, so values on an edge belong to the bin starting there
+		if bin < len(edges) && edges[bin] == value {
+			bin++
+		}
+		counts[min(bin-1, len(counts)-1)]++
+	}
+	return counts, below, above
+}
+
+// Pearson returns the Pearson correlation coefficient of two equally long series
+func Pearson(x []float64, y []float64) float64 {
+	if len(x) < 2 {
+		return math.NaN()
+	}
+	meanX, meanY := Mean(x), Mean(y)
+	var covariance, varianceX, varianceY float64
+	for i := range x {
+		covariance += (x[i] - meanX) * (y[i] - meanY)
+		varianceX += (x[i] - meanX) * (x[i] - meanX)
+		varianceY += (y[i] - meanY) * (y[i] - meanY)
+	}
+	if varianceX == 0 || varianceY == 0 {
+		return math.NaN()
+	}
+	return covariance / math.Sqrt(varianceX*varianceY)
+}
+
+// ranks returns the rank of each value starting at 1, ties share the average of their ranks
+func ranks(values []float64) []float64 {
+	indices := make([]int, len(values))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.Slice(indices, func(a, b int) bool {
+		return values[indices[a]] < values[indices[b]]
+	})
+
+	result := make([]float64, len(values))
+	for start := 0; start < len(indices); {
+		end := start
+		for end+1 < len(indices) && values[indices[end+1]] == values[indices[start]] {
+			end++
+		}
+		rank := float64(start+end)/2 + 1
+		for i := start; i <= end; i++ {
+			result[indices[i]] = rank
+		}
+		start = end + 1
+	}
+	return result
+}
+
+// Spearman returns the Spearman rank correlation coefficient of two equally long series
+func Spearman(x []float64, y []float64) float64 {
+	return Pearson(ranks(x), ranks(y))
+}
+
+// LinearRegression fits y = slope * x + intercept using least squares and returns the coefficient of determination
+func LinearRegression(x []float64, y []float64) (slope float64, intercept float64, rSquared float64) {
+	if len(x) < 2 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	meanX, meanY := Mean(x), Mean(y)
+	var covariance, varianceX float64
+	for i := range x {
+		covariance += (x[i] - meanX) * (y[i] - meanY)
+		varianceX += (x[i] - meanX) * (x[i] - meanX)
+	}
+	if varianceX == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	slope = covariance / varianceX
+	intercept = meanY - slope*meanX
+	r := Pearson(x, y)
+	return slope, intercept, r * r
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMean(t *testing.T) {
+	assert.Equal(t, 2.5, Mean([]float64{1, 2, 3, 4}))
+	assert.True(t, math.IsNaN(Mean([]float64{})))
+}
+
+func TestStdDev(t *testing.T) {
+	assert.InDelta(t, 1.2909944, StdDev([]float64{1, 2, 3, 4}), 0.000001)
+	assert.True(t, math.IsNaN(StdDev([]float64{1})))
+}
+
+func TestQuantile(t *testing.T) {
+	values := []float64{4, 1, 3, 2, 5}
+	assert.Equal(t, 1.0, Quantile(values, 0))
+	assert.Equal(t, 3.0, Quantile(values, 0.5))
+	assert.Equal(t, 5.0, Quantile(values, 1))
+	assert.Equal(t, 1.4, Quantile(values, 0.1))
+
+	// input is left untouched
+	assert.Equal(t, []float64{4, 1, 3, 2, 5}, values)
+
+	assert.True(t, math.IsNaN(Quantile([]float64{}, 0.5)))
+}
+
+func TestErrorMetrics(t *testing.T) {
+	actual := []float64{1, 2, 3, 4}
+	predicted := []float64{2, 2, 3, 1}
+	assert.Equal(t, 1.0, MAE(actual, predicted))
+	assert.InDelta(t, 1.5811388, RMSE(actual, predicted), 0.000001)
+}
+
+func TestEqualWidthEdges(t *testing.T) {
+	assert.Equal(t, []float64{0, 2.5, 5, 7.5, 10}, EqualWidthEdges(0, 10, 4))
+}
+
+func TestHistogram(t *testing.T) {
+	counts, below, above := Histogram([]float64{-1, 0, 1, 2.5, 4, 5, 6}, []float64{0, 2.5, 5})
+	assert.Equal(t, []int{2, 3}, counts)
+	assert.Equal(t, 1, below)
+	assert.Equal(t, 1, above)
+
+	// a single bin covering a single value
+	counts, below, above = Histogram([]float64{3, 3}, []float64{3, 3})
+	assert.Equal(t, []int{2}, counts)
+	assert.Equal(t, 0, below)
+	assert.Equal(t, 0, above)
+}
+
+func TestCorrelation(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5}
+	y := []float64{2, 4, 5, 4, 5}
+	assert.InDelta(t, 0.7745967, Pearson(x, y), 0.000001)
+	assert.InDelta(t, 0.7378648, Spearman(x, y), 0.000001)
+
+	// monotonic but not linear
+	assert.Equal(t, 1.0, Spearman([]float64{1, 2, 3}, []float64{1, 10, 1000}))
+	assert.InDelta(t, -1, Pearson([]float64{1, 2, 3}, []float64{3, 2, 1}), 0.000001)
+
+	// undefined without variance
+	assert.True(t, math.IsNaN(Pearson([]float64{1, 1, 1}, []float64{1, 2, 3})))
+	assert.True(t, math.IsNaN(Pearson([]float64{1}, []float64{1})))
+}
+
+func TestLinearRegression(t *testing.T) {
+	slope, intercept, rSquared := LinearRegression([]float64{1, 2, 3, 4, 5}, []float64{2, 4, 5, 4, 5})
+	assert.InDelta(t, 0.6, slope, 0.000001)
+	assert.InDelta(t, 2.2, intercept, 0.000001)
+	assert.InDelta(t, 0.6, rSquared, 0.000001)
+
+	slope, _, _ = LinearRegression([]float64{1, 1}, []float64{1, 2})
+	assert.True(t, math.IsNaN(slope))
+}
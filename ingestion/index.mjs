@@ -1,12 +1,19 @@
 import assert from "assert";
 import fs from "fs/promises";
 
-const { API_HOST, API_TOKEN } = process.env;
+const { API_HOST, API_TOKEN, ON_CONFLICT = "error" } = process.env;
 assert(API_HOST, "API_HOST is not set");
 assert(API_TOKEN, "API_TOKEN is not set");
 assert(URL.canParse(API_HOST), "API_HOST is not a valid URL");
+assert(
+  ["error", "skip", "overwrite", "keep_newer"].includes(ON_CONFLICT),
+  "ON_CONFLICT is not one of error, skip, overwrite or keep_newer"
+);
 
-const rawWeatherRecords = await fs.readFile("data/weather.dat", "utf-8");
+const SOURCE = "data/weather.dat";
+const rawWeatherRecords = await fs.readFile(SOURCE, "utf-8");
+// the records of a run are one ingestion batch
+const batchId = crypto.randomUUID();
 
 const weatherRecords = rawWeatherRecords
   .trim()
@@ -17,6 +24,8 @@ const weatherRecords = rawWeatherRecords
       date,
       humidity: Number(humidity),
       temperature: Number(temperature),
+      source: SOURCE,
+      batch_id: batchId,
     };
   });
 
@@ -25,26 +34,64 @@ console.log("🌞 Preparations complete");
 
 // Assignment Note: Could use Promise.all to process records in parallel
 console.log(
-  `Ready to ingest ${weatherRecords.length} weather records ⛅️🌂...`
+  `Ready to ingest ${weatherRecords.length} weather records as batch ${batchId} ⛅️🌂...`
 );
 console.time("Ingestion");
+// network errors are retried with the same Idempotency-Key, so a record is never created twice
+const MAX_ATTEMPTS = 3;
+// a retry that arrives while an earlier attempt is still handled is answered with 409 and Retry-After, and sent again
+const MAX_IN_PROGRESS_WAITS = 60;
+
+const sleep = (seconds) => new Promise((resolve) => setTimeout(resolve, seconds * 1000));
+
+async function sendRecord(record, idempotencyKey) {
+  let waits = 0;
+  for (let attempt = 1; ; ) {
+    let response;
+    try {
+      response = await fetch(`${API_HOST}/weather?on_conflict=${ON_CONFLICT}`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Api-Token": API_TOKEN,
+          "Idempotency-Key": idempotencyKey,
+        },
+        body: JSON.stringify(record),
+      });
+    } catch (error) {
+      if (attempt === MAX_ATTEMPTS) {
+        throw error;
+      }
+      console.log(`🌧️ Network error, retrying (${attempt}/${MAX_ATTEMPTS}):`, error.message);
+      attempt++;
+      continue;
+    }
+
+    const retryAfter = response.headers.get("Retry-After");
+    if (response.status === 409 && retryAfter !== null && waits < MAX_IN_PROGRESS_WAITS) {
+      waits++;
+      console.log(`🌧️ Earlier attempt still in progress, retrying in ${retryAfter}s:`, record.date);
+      await sleep(Number(retryAfter));
+      continue;
+    }
+    return response;
+  }
+}
+
 for (const record of weatherRecords) {
   console.log("Processing record:", record);
   try {
-    const response = await fetch(`${API_HOST}/weather`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json", "X-Api-Token": API_TOKEN },
-      body: JSON.stringify(record),
-    });
+    const response = await sendRecord(record, crypto.randomUUID());
 
-    if (response.status === 409) {
+    // a 409 without Retry-After is a record of the date that was stored before
+    if (response.status === 409 && !response.headers.has("Retry-After")) {
       console.log("🌧️ Duplicate record, skipping:", record.date);
       continue;
     }
     if (!response.ok) {
       throw new Error(`Network error! status: ${response.status}`);
     }
-    console.log("Record sent successfully:", record);
+    console.log(`Record sent successfully (${response.headers.get("X-Write-Outcome")}):`, record);
   } catch (error) {
     console.error("🌧️ Error sending record:", error);
     console.error("Stop further processing");